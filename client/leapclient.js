@@ -123,6 +123,12 @@ leap_model.prototype._validate_updates = function(user_updates) {
 		    "string" !== typeof(message.content) ) {
 			return "update message contained invalid type for content: " + JSON.stringify(update);
 		}
+		if ( undefined !== message.presence ) {
+			var presence_error = this._validate_presence(message.presence);
+			if ( presence_error !== undefined ) {
+				return "update message contained invalid presence: " + presence_error + ", " + JSON.stringify(update);
+			}
+		}
 		if ( undefined !== message.active &&
 		    "boolean" !== typeof(message.active) ) {
 			if ("string" !== typeof(message.active)) {
@@ -144,6 +150,39 @@ leap_model.prototype._validate_updates = function(user_updates) {
 	}
 };
 
+/* _validate_presence checks that a presence object contains arrays of numerical cursor positions and
+ * selections with numerical anchor and head fields. Returns an error message as a string if there was
+ * a problem.
+ */
+leap_model.prototype._validate_presence = function(presence) {
+	if ( null === presence || "object" !== typeof(presence) ) {
+		return "presence was not an object";
+	}
+	if ( undefined !== presence.cursors ) {
+		if ( !(presence.cursors instanceof Array) ) {
+			return "cursors was not an array";
+		}
+		for ( var i = 0, l = presence.cursors.length; i < l; i++ ) {
+			if ( "number" !== typeof(presence.cursors[i]) ) {
+				return "cursor was not a number";
+			}
+		}
+	}
+	if ( undefined !== presence.selections ) {
+		if ( !(presence.selections instanceof Array) ) {
+			return "selections was not an array";
+		}
+		for ( var j = 0, m = presence.selections.length; j < m; j++ ) {
+			var selection = presence.selections[j];
+			if ( null === selection || "object" !== typeof(selection) ||
+			    "number" !== typeof(selection.anchor) ||
+			    "number" !== typeof(selection.head) ) {
+				return "selection did not contain numerical anchor and head";
+			}
+		}
+	}
+};
+
 /* merge_transforms takes two transforms (the next to be sent, and the one that follows) and
  * attempts to merge them into one transform. This will not be possible with some combinations, and
  * the function returns a boolean to indicate whether the merge was successful.
@@ -224,9 +263,9 @@ leap_model.prototype._resolve_state = function() {
 	case this.SENDING:
 		return {};
 	case this.BUFFERING:
-		if ( ( this._version + this._unapplied.length ) >= (this._corrected_version - 1) ) {
+		if ( ( this._version + this._span(this._unapplied) ) >= (this._corrected_version - 1) ) {
 
-			this._version += this._unapplied.length + 1;
+			this._version += this._span(this._unapplied) + 1;
 			var to_collide = [ this._sending ].concat(this._unsent);
 			var unapplied = this._unapplied;
 
@@ -292,14 +331,27 @@ leap_model.prototype.submit = function(transform) {
 	return {};
 };
 
+/* _span returns the number of versions covered by a list of transforms, the server may coalesce
+ * consecutive transforms into one, in which case its version is the last of those it covers.
+ */
+leap_model.prototype._span = function(transforms) {
+	var span = 0;
+	for ( var i = 0, l = transforms.length; i < l; i++ ) {
+		span += ( "number" === typeof(transforms[i].span) && transforms[i].span > 1 ) ?
+			transforms[i].span : 1;
+	}
+	return span;
+};
+
 /* receive is the function to call when we have received transforms from our server. If we have
  * recently dispatched transforms and have yet to receive our correction then it is unsafe to apply
  * these changes to our local document, so the model will keep return these transforms to us when it
  * is known to be safe.
  */
 leap_model.prototype.receive = function(transforms) {
-	var expected_version = this._version + this._unapplied.length + 1;
-	if ( (transforms.length > 0) && (transforms[0].version !== expected_version) ) {
+	var expected_version = this._version + this._span(this._unapplied) + 1;
+	if ( (transforms.length > 0) &&
+	     (transforms[0].version - this._span([ transforms[0] ]) + 1 !== expected_version) ) {
 		return { error :
 			("Received unexpected transform version: " + transforms[0].version +
 				", expected: " + expected_version) };
@@ -307,7 +359,7 @@ leap_model.prototype.receive = function(transforms) {
 
 	switch (this._leap_state) {
 	case this.READY:
-		this._version += transforms.length;
+		this._version += this._span(transforms);
 		return { apply : transforms };
 	case this.BUFFERING:
 		this._unapplied = this._unapplied.concat(transforms);
@@ -332,12 +384,25 @@ leap_client = function() {
 
 	this._cursor_position = 0;
 
+	// The latest checksum received from the server and its version, and the version of the last resync.
+	this._checksum = null;
+	this._resync_version = 0;
+
+	// The optional display name and colour sent to the server when binding to a document.
+	this._display_name = "";
+	this._colour = "";
+
 	this.EVENT_TYPE = {
 		CONNECT: "connect",
 		DISCONNECT: "disconnect",
 		DOCUMENT: "document",
 		TRANSFORMS: "transforms",
 		USER: "user",
+		LOCKS: "locks",
+		COMMENTS: "comments",
+		SUGGESTIONS: "suggestions",
+		EXTERNAL_CHANGE: "external_change",
+		KICKED: "kicked",
 		ERROR: "error"
 	};
 
@@ -439,6 +504,13 @@ leap_client.prototype._process_message = function(message) {
 		if ( !(message.transforms instanceof Array) ) {
 			return "received non array transforms";
 		}
+		// Transforms sent before a resync may still arrive after it, and are already included.
+		if ( this._resync_version > 0 ) {
+			var resync_version = this._resync_version;
+			message.transforms = message.transforms.filter(function(tform) {
+				return tform.version > resync_version;
+			});
+		}
 		validate_error = this._model._validate_transforms(message.transforms);
 		if ( validate_error !== undefined ) {
 			return "received transforms with error: " + validate_error;
@@ -448,6 +520,9 @@ leap_client.prototype._process_message = function(message) {
 		if ( action_err !== undefined ) {
 			return "failed to receive transforms: " + action_err;
 		}
+		for ( var j = 0, lt = message.transforms.length; j < lt; j++ ) {
+			this._record_checksum(message.transforms[j].version, message.transforms[j].checksum);
+		}
 		break;
 	case "update":
 		if ( null === message.user_updates ||
@@ -477,9 +552,73 @@ leap_client.prototype._process_message = function(message) {
 		if ( action_err !== undefined ) {
 			return "model failed to correct: " + action_err;
 		}
+		this._record_checksum(message.version, message.checksum);
+		break;
+	case "resync":
+		if ( null === message.leap_document ||
+		   "object" !== typeof(message.leap_document) ||
+		   "string" !== typeof(message.leap_document.content) ) {
+			return "message resync type contained invalid document object";
+		}
+		if ( typeof(message.version) !== "number" || message.version <= 0 ) {
+			return "message resync received but without valid version";
+		}
+		this._model = new leap_model(message.version);
+		this._resync_version = message.version;
+		this._checksum = null;
+		this._record_checksum(message.version, message.checksum);
+		this._dispatch_event(this.EVENT_TYPE.DOCUMENT, [ message.leap_document ]);
+		break;
+	case "locks":
+		if ( typeof(message.error) === "string" ) {
+			return message.error;
+		}
+		if ( message.locks === undefined ) {
+			message.locks = [];
+		}
+		if ( !(message.locks instanceof Array) ) {
+			return "message locks type contained invalid locks";
+		}
+		this._dispatch_event(this.EVENT_TYPE.LOCKS, [ message.locks ]);
+		break;
+	case "comments":
+		if ( typeof(message.error) === "string" ) {
+			return message.error;
+		}
+		if ( message.comments === undefined ) {
+			message.comments = [];
+		}
+		if ( !(message.comments instanceof Array) ) {
+			return "message comments type contained invalid comments";
+		}
+		this._dispatch_event(this.EVENT_TYPE.COMMENTS, [ message.comments ]);
+		break;
+	case "suggestions":
+		if ( typeof(message.error) === "string" ) {
+			return message.error;
+		}
+		if ( message.suggestions === undefined ) {
+			message.suggestions = [];
+		}
+		if ( !(message.suggestions instanceof Array) ) {
+			return "message suggestions type contained invalid suggestions";
+		}
+		this._dispatch_event(this.EVENT_TYPE.SUGGESTIONS, [ message.suggestions ]);
+		break;
+	case "external_change":
+		this._dispatch_event(this.EVENT_TYPE.EXTERNAL_CHANGE, []);
+		break;
+	case "kicked":
+		// The server disconnects a kicked client once the reason has been sent.
+		this._dispatch_event(this.EVENT_TYPE.KICKED,
+			[ typeof(message.reason) === "string" ? message.reason : "" ]);
 		break;
 	case "error":
-		if ( this._socket !== null ) {
+		// A rate limited client remains connected, but the correction of its rejected transform
+		// will never arrive, so the document is resynced instead.
+		if ( message.code === "rate_limited" ) {
+			this.resync();
+		} else if ( this._socket !== null ) {
 			this._socket.close();
 		}
 		if ( typeof(message.error) === "string" ) {
@@ -491,6 +630,67 @@ leap_client.prototype._process_message = function(message) {
 	}
 };
 
+/* _record_checksum stores the checksum of the document at a version as sent by the server, if there is
+ * one.
+ */
+leap_client.prototype._record_checksum = function(version, checksum) {
+	if ( "number" === typeof(version) && "number" === typeof(checksum) ) {
+		this._checksum = { version: version, checksum: checksum };
+	}
+};
+
+/* checksum returns the checksum of content as computed by the server, which is a polynomial hash of
+ * the code points of the content, each multiplied by 65599 raised to the power of its position,
+ * modulo 67108859. All intermediate values remain exact within a double.
+ */
+leap_client.prototype.checksum = function(content) {
+	var hash = 0, power = 1, code;
+	for ( var i = 0, l = content.length; i < l; i++ ) {
+		code = content.charCodeAt(i);
+		if ( code >= 0xD800 && code <= 0xDBFF && i + 1 < l ) {
+			var low = content.charCodeAt(i + 1);
+			if ( low >= 0xDC00 && low <= 0xDFFF ) {
+				code = (code - 0xD800) * 0x400 + (low - 0xDC00) + 0x10000;
+				i++;
+			}
+		}
+		hash = (hash + code * power) % 67108859;
+		power = (power * 65599) % 67108859;
+	}
+	return hash;
+};
+
+/* verify compares the checksum of the local content of the document with the latest checksum sent by
+ * the server. Returns true or false, or undefined when the local content cannot currently be
+ * verified, either because the server has not sent a checksum or because there are changes in flight.
+ * When verification fails the client should call resync.
+ */
+leap_client.prototype.verify = function(content) {
+	if ( this._model === null || this._checksum === null ) {
+		return undefined;
+	}
+	if ( this._model._leap_state !== this._model.READY ||
+	     this._model._unapplied.length > 0 ||
+	     this._model._version !== this._checksum.version ) {
+		return undefined;
+	}
+	return this.checksum(content) === this._checksum.checksum;
+};
+
+/* resync asks the server for the authoritative content and version of the document, which is
+ * received through the document event and replaces the local content. Any local changes that were
+ * not yet sent are lost.
+ */
+leap_client.prototype.resync = function() {
+	if ( this._model === null ) {
+		return "leap_client must be initialized and joined to a document before resyncing";
+	}
+
+	this._socket.send(JSON.stringify({
+		command: "resync"
+	}));
+};
+
 /* send_transform is the function to call to send a transform off to the server. To keep the local
  * document responsive this transform should be applied to the document straight away. The
  * leap_client will decide when it is appropriate to dispatch the transform, and will manage
@@ -544,6 +744,252 @@ leap_client.prototype.update_cursor = function(position) {
 	}));
 };
 
+/* update_presence sends the server (and all other clients) the full set of your cursors and selected
+ * ranges, for editors that support multiple cursors. Cursors are an array of positions, and selections
+ * an array of objects each with an anchor (where the selection began) and head (where it ends).
+ */
+leap_client.prototype.update_presence = function(cursors, selections) {
+	var presence = {
+		cursors: cursors || [],
+		selections: selections || []
+	};
+	var validate_error = leap_model.prototype._validate_presence(presence);
+	if ( validate_error !== undefined ) {
+		return "must supply a valid presence: " + validate_error;
+	}
+
+	this._socket.send(JSON.stringify({
+		command:  "update",
+		presence: presence
+	}));
+};
+
+/* add_lock locks the range of the document from start up to end, so that only this user, or users
+ * with at least the optional access level, may edit it. The lock, and its later removal, are received
+ * through the locks event.
+ */
+leap_client.prototype.add_lock = function(start, end, access_level) {
+	if ( "number" !== typeof(start) || "number" !== typeof(end) || start < 0 || end <= start ) {
+		return "must supply start and end as a valid range";
+	}
+	if ( undefined !== access_level && "number" !== typeof(access_level) ) {
+		return "must supply access level as a number";
+	}
+
+	this._socket.send(JSON.stringify({
+		command:      "lock",
+		start:        start,
+		end:          end,
+		access_level: access_level || 0
+	}));
+};
+
+/* remove_lock removes a lock that this user is permitted to edit.
+ */
+leap_client.prototype.remove_lock = function(lock_id) {
+	if ( "string" !== typeof(lock_id) ) {
+		return "must supply lock id as a string";
+	}
+
+	this._socket.send(JSON.stringify({
+		command: "unlock",
+		lock_id: lock_id
+	}));
+};
+
+/* list_locks requests all locks of the document, which are received through the locks event.
+ */
+leap_client.prototype.list_locks = function() {
+	this._socket.send(JSON.stringify({
+		command: "list_locks"
+	}));
+};
+
+/* add_comment starts a comment thread on the range of the document from start up to end. The thread,
+ * and any later changes to it, are received through the comments event.
+ */
+leap_client.prototype.add_comment = function(start, end, content) {
+	if ( "number" !== typeof(start) || "number" !== typeof(end) || start < 0 || end < start ) {
+		return "must supply start and end as a valid range";
+	}
+	if ( "string" !== typeof(content) || content.length === 0 ) {
+		return "must supply comment content as a non-empty string";
+	}
+
+	this._socket.send(JSON.stringify({
+		command: "comment",
+		start:   start,
+		end:     end,
+		message: content
+	}));
+};
+
+/* reply_comment adds a comment to an existing comment thread.
+ */
+leap_client.prototype.reply_comment = function(thread_id, content) {
+	if ( "string" !== typeof(thread_id) ) {
+		return "must supply thread id as a string";
+	}
+	if ( "string" !== typeof(content) || content.length === 0 ) {
+		return "must supply comment content as a non-empty string";
+	}
+
+	this._socket.send(JSON.stringify({
+		command:   "reply_comment",
+		thread_id: thread_id,
+		message:   content
+	}));
+};
+
+/* resolve_comment marks a comment thread as resolved.
+ */
+leap_client.prototype.resolve_comment = function(thread_id) {
+	if ( "string" !== typeof(thread_id) ) {
+		return "must supply thread id as a string";
+	}
+
+	this._socket.send(JSON.stringify({
+		command:   "resolve_comment",
+		thread_id: thread_id
+	}));
+};
+
+/* list_comments requests all comment threads of the document, which are received through the comments
+ * event.
+ */
+leap_client.prototype.list_comments = function() {
+	this._socket.send(JSON.stringify({
+		command: "list_comments"
+	}));
+};
+
+/* suggest proposes a transform to the server without applying it, the transform must NOT be applied
+ * to the local document. Suggestions can only be made while the model has no local changes waiting
+ * to be sent or corrected, and are received through the suggestions event.
+ */
+leap_client.prototype.suggest = function(transform) {
+	if ( this._model === null ) {
+		return "leap_client must be initialized and joined to a document before suggesting";
+	}
+	if ( this._model._leap_state !== this._model.READY || this._model._unapplied.length > 0 ) {
+		return "cannot suggest while local changes are pending";
+	}
+
+	var validate_error = this._model._validate_transforms([ transform ]);
+	if ( validate_error !== undefined ) {
+		return validate_error;
+	}
+
+	transform.version = this._model._version + 1;
+	this._socket.send(JSON.stringify({
+		command:   "suggest",
+		transform: transform
+	}));
+};
+
+/* accept_suggestion asks the server to apply a suggestion, which is then received as a transform.
+ */
+leap_client.prototype.accept_suggestion = function(suggestion_id) {
+	if ( "string" !== typeof(suggestion_id) ) {
+		return "must supply suggestion id as a string";
+	}
+
+	this._socket.send(JSON.stringify({
+		command:       "accept_suggestion",
+		suggestion_id: suggestion_id
+	}));
+};
+
+/* reject_suggestion asks the server to discard a suggestion.
+ */
+leap_client.prototype.reject_suggestion = function(suggestion_id) {
+	if ( "string" !== typeof(suggestion_id) ) {
+		return "must supply suggestion id as a string";
+	}
+
+	this._socket.send(JSON.stringify({
+		command:       "reject_suggestion",
+		suggestion_id: suggestion_id
+	}));
+};
+
+/* list_suggestions requests all pending suggestions of the document, which are received through the
+ * suggestions event.
+ */
+leap_client.prototype.list_suggestions = function() {
+	this._socket.send(JSON.stringify({
+		command: "list_suggestions"
+	}));
+};
+
+/* undo asks the server to revert the most recent transform submitted by this client. The reverting
+ * transform is received like any other transform from the server.
+ */
+leap_client.prototype.undo = function() {
+	if ( this._model === null ) {
+		return "leap_client must be initialized and joined to a document before undoing";
+	}
+
+	this._socket.send(JSON.stringify({
+		command: "undo"
+	}));
+};
+
+/* redo asks the server to reapply the most recent transform of this client that was undone.
+ */
+leap_client.prototype.redo = function() {
+	if ( this._model === null ) {
+		return "leap_client must be initialized and joined to a document before redoing";
+	}
+
+	this._socket.send(JSON.stringify({
+		command: "redo"
+	}));
+};
+
+/* replace_content asks the server to replace the full content of the document. The server submits the
+ * difference as a transform, which is received like any other transform from the server, so the
+ * content should not be applied locally. Returns an error message if there are local changes pending,
+ * as the content would overwrite them.
+ */
+leap_client.prototype.replace_content = function(content) {
+	if ( this._model === null ) {
+		return "leap_client must be initialized and joined to a document before replacing content";
+	}
+	if ( typeof(content) !== "string" ) {
+		return "replace_content requires the content to be a string";
+	}
+	if ( this._model._leap_state !== this._model.READY || this._model._unapplied.length > 0 ) {
+		return "cannot replace content while local changes are pending";
+	}
+
+	this._socket.send(JSON.stringify({
+		command: "replace",
+		content: content
+	}));
+};
+
+/* set_profile sets the display name and colour of this client, which are shown to other users in
+ * the records of the clients of a document. The profile must be set before joining or creating a
+ * document. It will return an error message if there is a problem with the request.
+ */
+leap_client.prototype.set_profile = function(display_name, colour) {
+	if ( typeof(display_name) !== "string" ) {
+		return "display name was not a string type";
+	}
+
+	if ( typeof(colour) !== "string" ) {
+		return "colour was not a string type";
+	}
+
+	if ( this._document_id !== null ) {
+		return "profile must be set before joining a document";
+	}
+
+	this._display_name = display_name;
+	this._colour = colour;
+};
+
 /* join_document prompts the client to request to join a document from the server. It will return an
  * error message if there is a problem with the request.
  */
@@ -574,7 +1020,9 @@ leap_client.prototype.join_document = function(user_id, token, document_id) {
 		command : "find",
 		user_id : user_id,
 		token : token,
-		document_id : this._document_id
+		document_id : this._document_id,
+		display_name : this._display_name,
+		colour : this._colour
 	}));
 };
 
@@ -606,6 +1054,8 @@ leap_client.prototype.create_document = function(user_id, token, content) {
 		command : "create",
 		user_id : user_id,
 		token : token,
+		display_name : this._display_name,
+		colour : this._colour,
 		leap_document : {
 			content : content
 		}
@@ -646,7 +1096,8 @@ leap_client.prototype.connect = function(address, _websocket) {
 
 		var err = leap_obj._process_message.apply(leap_obj, [ message_obj ]);
 		if ( typeof(err) === "string" ) {
-			leap_obj._dispatch_event.apply(leap_obj, [ leap_obj.EVENT_TYPE.ERROR, [ err ] ]);
+			// Errors may carry a code, such as "rate_limited", as a second argument.
+			leap_obj._dispatch_event.apply(leap_obj, [ leap_obj.EVENT_TYPE.ERROR, [ err, message_obj.code ] ]);
 		}
 	};
 
@@ -0,0 +1,111 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+var lc = require('../leapclient').client;
+
+module.exports = function(test) {
+	"use strict";
+
+	var updates = [];
+
+	var socket = { readyState : 1 };
+
+	socket.close = function() {};
+
+	// First send response should be the same doc, emulating creation
+	socket.send = function(data) {
+		var obj = JSON.parse(data);
+		obj.leap_document.id = "testdocument";
+		obj.version = 1;
+		obj.response_type = "document";
+		socket.onmessage({ data : JSON.stringify(obj) });
+	};
+
+	var client = new lc();
+	client.connect("", socket);
+
+	var errors = [];
+	client.subscribe_event("error", function(err) {
+		errors.push(err);
+	});
+
+	client.create_document("test_id", "test_token", "hello world");
+	// Should now be primed and ready.
+
+	var documents = [];
+	client.subscribe_event("document", function(doc) {
+		documents.push(doc);
+	});
+
+	test.ok(client.checksum("ab") === 97 + 98 * 65599, "wrong checksum: " + client.checksum("ab"));
+	// The same value is expected by the unit tests of the server.
+	test.ok(client.checksum("hello 😀 world") === 5859554,
+		"wrong checksum: " + client.checksum("hello 😀 world"));
+
+	test.ok(client.verify("hello world") === undefined, "verified without a checksum");
+
+	socket.onmessage({ data : JSON.stringify({
+		response_type: "transforms",
+		transforms: [ {
+			position: 5, num_delete: 0, insert: " 😀",
+			version: 2, checksum: 5859554
+		} ]
+	}) });
+
+	test.ok(client.verify("hello 😀 world") === true, "failed to verify matching content");
+	test.ok(client.verify("hello world") === false, "verified diverged content");
+
+	var sent = [];
+	socket.send = function(data) {
+		sent.push(JSON.parse(data));
+	};
+
+	test.ok(client.resync() === undefined, "unexpected resync error");
+	test.ok(sent.length === 1 && sent[0].command === "resync", "wrong resync command: " + JSON.stringify(sent));
+
+	socket.onmessage({ data : JSON.stringify({
+		response_type: "resync",
+		leap_document: { id: "testdocument", content: "hello there" },
+		version: 4,
+		checksum: client.checksum("hello there")
+	}) });
+
+	test.ok(documents.length === 1 && documents[0].content === "hello there",
+		"wrong resync document: " + JSON.stringify(documents));
+	test.ok(client.verify("hello there") === true, "failed to verify resynced content");
+
+	// A transform sent before the resync is already included and should be ignored.
+	socket.onmessage({ data : JSON.stringify({
+		response_type: "transforms",
+		transforms: [ { position: 0, num_delete: 0, insert: "a", version: 4 } ]
+	}) });
+	test.ok(errors.length === 0, "unexpected errors: " + JSON.stringify(errors));
+
+	client.close();
+	test.done();
+};
+
+/*--------------------------------------------------------------------------------------------------
+ */
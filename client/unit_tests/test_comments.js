@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+var lc = require('../leapclient').client;
+
+module.exports = function(test) {
+	"use strict";
+
+	var updates = [];
+
+	var socket = { readyState : 1 };
+
+	socket.close = function() {};
+
+	// First send response should be the same doc, emulating creation
+	socket.send = function(data) {
+		var obj = JSON.parse(data);
+		obj.leap_document.id = "testdocument";
+		obj.version = 1;
+		obj.response_type = "document";
+		socket.onmessage({ data : JSON.stringify(obj) });
+	};
+
+	var client = new lc();
+	client.connect("", socket);
+
+	var errors = [];
+	client.subscribe_event("error", function(err) {
+		errors.push(err);
+	});
+
+	var comments = [];
+	client.subscribe_event("comments", function(threads) {
+		comments.push(threads);
+	});
+
+	var sent = [];
+	var closed = false;
+	socket.close = function() { closed = true; };
+	socket.send = function(data) {
+		var request = JSON.parse(data);
+		sent.push(request);
+
+		if ( request.command === "resolve_comment" ) {
+			socket.onmessage({ data : JSON.stringify({
+				response_type: "comments",
+				error: "resolve_comment error: comment thread was not found"
+			}) });
+			return;
+		}
+		socket.onmessage({ data : JSON.stringify({
+			response_type: "comments",
+			comments: [ {
+				id: "thread", start: request.start, end: request.end, resolved: false,
+				comments: [ { author: "test_id", content: request.message, created: 1 } ]
+			} ]
+		}) });
+	};
+
+	var err = client.add_comment(2, 6, "what is this?");
+	test.ok(err === undefined, "unexpected comment error: " + err);
+
+	err = client.add_comment(6, 2, "backwards");
+	test.ok(err !== undefined, "expected error from invalid range");
+
+	err = client.reply_comment("thread", "");
+	test.ok(err !== undefined, "expected error from empty reply");
+
+	test.ok(sent.length === 1, "wrong count of sent commands: " + sent.length);
+	test.ok(sent[0].command === "comment", "wrong command: " + sent[0].command);
+	test.ok(comments.length === 1 && comments[0][0].start === 2 && comments[0][0].end === 6,
+		"wrong comments: " + JSON.stringify(comments));
+
+	// Comment errors are reported without closing the socket.
+	client.resolve_comment("nope");
+	test.ok(errors.length === 1, "expected error from failed resolve: " + errors.length);
+	test.ok(!closed, "socket was closed by comment error");
+
+	client.close();
+	test.done();
+};
+
+/*--------------------------------------------------------------------------------------------------
+ */
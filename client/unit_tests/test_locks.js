@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+var lc = require('../leapclient').client;
+
+module.exports = function(test) {
+	"use strict";
+
+	var updates = [];
+
+	var socket = { readyState : 1 };
+
+	socket.close = function() {};
+
+	// First send response should be the same doc, emulating creation
+	socket.send = function(data) {
+		var obj = JSON.parse(data);
+		obj.leap_document.id = "testdocument";
+		obj.version = 1;
+		obj.response_type = "document";
+		socket.onmessage({ data : JSON.stringify(obj) });
+	};
+
+	var client = new lc();
+	client.connect("", socket);
+
+	var errors = [];
+	client.subscribe_event("error", function(err) {
+		errors.push(err);
+	});
+
+	var locks = [];
+	client.subscribe_event("locks", function(changed) {
+		locks.push(changed);
+	});
+
+	var sent = [];
+	var closed = false;
+	socket.close = function() { closed = true; };
+	socket.send = function(data) {
+		var request = JSON.parse(data);
+		sent.push(request);
+
+		if ( request.command === "unlock" ) {
+			socket.onmessage({ data : JSON.stringify({
+				response_type: "locks",
+				error: "unlock error: lock was not found"
+			}) });
+			return;
+		}
+		socket.onmessage({ data : JSON.stringify({
+			response_type: "locks",
+			locks: [ {
+				id: "lock", start: request.start, end: request.end, owner: "test_id",
+				access_level: request.access_level
+			} ]
+		}) });
+	};
+
+	var err = client.add_lock(2, 6);
+	test.ok(err === undefined, "unexpected lock error: " + err);
+
+	err = client.add_lock(6, 6);
+	test.ok(err !== undefined, "expected error from empty range");
+
+	err = client.add_lock(2, 6, "edit");
+	test.ok(err !== undefined, "expected error from invalid access level");
+
+	err = client.remove_lock(5);
+	test.ok(err !== undefined, "expected error from invalid lock id");
+
+	test.ok(sent.length === 1, "wrong count of sent commands: " + sent.length);
+	test.ok(sent[0].command === "lock" && sent[0].access_level === 0,
+		"wrong command: " + JSON.stringify(sent[0]));
+	test.ok(locks.length === 1 && locks[0][0].start === 2 && locks[0][0].end === 6,
+		"wrong locks: " + JSON.stringify(locks));
+
+	// Lock errors are reported without closing the socket.
+	client.remove_lock("nope");
+	test.ok(errors.length === 1, "expected error from failed unlock: " + errors.length);
+	test.ok(!closed, "socket was closed by lock error");
+
+	client.close();
+	test.done();
+};
+
+/*--------------------------------------------------------------------------------------------------
+ */
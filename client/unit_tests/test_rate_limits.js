@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+/*--------------------------------------------------------------------------------------------------
+ */
+var lc = require('../leapclient').client;
+
+module.exports = function(test) {
+	"use strict";
+
+	var socket = { readyState : 1 };
+
+	socket.close = function() {};
+
+	// First send response should be the same doc, emulating creation
+	socket.send = function(data) {
+		var obj = JSON.parse(data);
+		obj.leap_document.id = "testdocument";
+		obj.version = 1;
+		obj.response_type = "document";
+		socket.onmessage({ data : JSON.stringify(obj) });
+	};
+
+	var client = new lc();
+	client.connect("", socket);
+
+	var errors = [];
+	client.subscribe_event("error", function(err, code) {
+		errors.push([ err, code ]);
+	});
+
+	var documents = [];
+	client.subscribe_event("document", function(doc) {
+		documents.push(doc);
+	});
+
+	socket.onmessage({ data : JSON.stringify({
+		response_type: "document",
+		leap_document: { id: "testdocument", content: "hello world" },
+		version: 1
+	}) });
+
+	var sent = [];
+	var closed = false;
+	socket.close = function() { closed = true; };
+	socket.send = function(data) {
+		var request = JSON.parse(data);
+		sent.push(request);
+
+		if ( request.command === "submit" ) {
+			socket.onmessage({ data : JSON.stringify({
+				response_type: "error",
+				error: "submit error: client exceeded its rate limit of submissions",
+				code: "rate_limited"
+			}) });
+		} else if ( request.command === "resync" ) {
+			socket.onmessage({ data : JSON.stringify({
+				response_type: "resync",
+				leap_document: { id: "testdocument", content: "hello world" },
+				version: 2
+			}) });
+		}
+	};
+
+	var err = client.send_transform({ position: 0, insert: "a", num_delete: 0 });
+	test.ok(err === undefined, "unexpected submit error: " + err);
+
+	// A rate limited client stays connected and resyncs the transform that was rejected.
+	test.ok(!closed, "socket was closed by rate limit");
+	test.ok(errors.length === 1 && errors[0][1] === "rate_limited",
+		"wrong errors: " + JSON.stringify(errors));
+	test.ok(sent.length === 2 && sent[1].command === "resync",
+		"wrong sent commands: " + JSON.stringify(sent));
+	test.ok(documents.length === 2 && documents[1].content === "hello world",
+		"wrong documents: " + JSON.stringify(documents));
+
+	// Other errors still close the socket.
+	socket.onmessage({ data : JSON.stringify({ response_type: "error", error: "bad" }) });
+	test.ok(closed, "socket was not closed by error");
+
+	client.close();
+	test.done();
+};
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+var lc = require('../leapclient').client;
+
+module.exports = function(test) {
+	"use strict";
+
+	var updates = [];
+
+	var socket = { readyState : 1 };
+
+	socket.close = function() {};
+
+	// First send response should be the same doc, emulating creation
+	socket.send = function(data) {
+		var obj = JSON.parse(data);
+		obj.leap_document.id = "testdocument";
+		obj.version = 1;
+		obj.response_type = "document";
+		socket.onmessage({ data : JSON.stringify(obj) });
+	};
+
+	var client = new lc();
+	client.connect("", socket);
+
+	var errors = [];
+	client.subscribe_event("error", function(err) {
+		errors.push(err);
+	});
+
+	client.create_document("test_id", "test_token", "random content");
+	// Should now be primed and ready.
+
+	var suggestions = [];
+	client.subscribe_event("suggestions", function(received) {
+		suggestions.push(received);
+	});
+
+	var sent = [];
+	socket.send = function(data) {
+		var request = JSON.parse(data);
+		sent.push(request);
+
+		if ( request.command !== "suggest" ) {
+			return;
+		}
+		socket.onmessage({ data : JSON.stringify({
+			response_type: "suggestions",
+			suggestions: [ {
+				id: "suggestion", author: "test_id", created: 1,
+				edits: [ {
+					start: request.transform.position,
+					end: request.transform.position + request.transform.num_delete,
+					insert: request.transform.insert
+				} ]
+			} ]
+		}) });
+	};
+
+	var err = client.suggest({ position: 0, num_delete: 6, insert: "other" });
+	test.ok(err === undefined, "unexpected suggest error: " + err);
+
+	test.ok(sent.length === 1, "wrong count of sent commands: " + sent.length);
+	test.ok(sent[0].transform.version === 2, "wrong suggestion version: " + sent[0].transform.version);
+	test.ok(suggestions.length === 1 && suggestions[0][0].edits[0].end === 6,
+		"wrong suggestions: " + JSON.stringify(suggestions));
+
+	// Suggestions cannot be made while local changes await correction.
+	client.send_transform({ position: 0, num_delete: 0, insert: "a" });
+	err = client.suggest({ position: 0, num_delete: 1, insert: "b" });
+	test.ok(err !== undefined, "expected error from suggesting with pending changes");
+
+	err = client.accept_suggestion("suggestion");
+	test.ok(err === undefined, "unexpected accept error: " + err);
+	test.ok(sent[sent.length-1].command === "accept_suggestion", "wrong command: " + sent[sent.length-1].command);
+
+	test.ok(errors.length === 0, "unexpected errors: " + JSON.stringify(errors));
+
+	client.close();
+	test.done();
+};
+
+/*--------------------------------------------------------------------------------------------------
+ */
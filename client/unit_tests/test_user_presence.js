@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+var lc = require('../leapclient').client;
+
+module.exports = function(test) {
+	"use strict";
+
+	var updates = [];
+
+	var socket = { readyState : 1 };
+
+	socket.close = function() {};
+
+	// First send response should be the same doc, emulating creation
+	socket.send = function(data) {
+		var obj = JSON.parse(data);
+		obj.leap_document.id = "testdocument";
+		obj.version = 1;
+		obj.response_type = "document";
+		socket.onmessage({ data : JSON.stringify(obj) });
+	};
+
+	var client = new lc();
+	client.connect("", socket);
+
+	var errors = [];
+	client.subscribe_event("error", function(err) {
+		errors.push(err);
+	});
+
+	client.create_document("test_id", "test_token", "random content");
+	// Should now be primed and ready.
+
+	client.on("user", function(user) {
+		updates.push(user);
+	});
+
+	var sent = [];
+	socket.send = function(data) {
+		var update = JSON.parse(data);
+		sent.push(update);
+
+		socket.onmessage({ data : JSON.stringify({
+			response_type: "update",
+			user_updates: [ {
+				client: {
+					user_id    : "test",
+					session_id : "test"
+				},
+				message: {
+					active   : true,
+					presence : update.presence
+				},
+			} ]
+		}) });
+	};
+
+	var err = client.update_presence([ 1, 5 ], [ { anchor: 2, head: 4 } ]);
+	test.ok(err === undefined, "unexpected presence error: " + err);
+
+	err = client.update_presence([ "nope" ]);
+	test.ok(err !== undefined, "expected error from invalid cursor");
+
+	err = client.update_presence([], [ { anchor: 1 } ]);
+	test.ok(err !== undefined, "expected error from selection without head");
+
+	test.ok(sent.length === 1, "wrong count of sent updates: " + sent.length);
+	test.ok(sent[0].command === "update", "wrong command: " + sent[0].command);
+
+	test.ok(updates.length === 1, "wrong updates count: " + updates.length);
+	var presence = updates[0].message.presence;
+	test.ok(presence.cursors.length === 2 && presence.cursors[1] === 5,
+		"wrong cursors: " + JSON.stringify(presence.cursors));
+	test.ok(presence.selections.length === 1 && presence.selections[0].head === 4,
+		"wrong selections: " + JSON.stringify(presence.selections));
+
+	// Invalid presence from the server should be rejected.
+	socket.onmessage({ data : JSON.stringify({
+		response_type: "update",
+		user_updates: [ {
+			client: { user_id : "test", session_id : "test" },
+			message: { active : true, presence : { cursors : "nope" } }
+		} ]
+	}) });
+	test.ok(errors.length === 1, "expected error from invalid presence: " + errors.length);
+	test.ok(updates.length === 1, "invalid presence was dispatched");
+
+	client.close();
+	test.done();
+};
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -37,6 +37,7 @@ import (
 	"github.com/jeffail/leaps/lib/auth"
 	"github.com/jeffail/leaps/lib/register"
 	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/lib/webhook"
 	"github.com/jeffail/leaps/net"
 	"github.com/jeffail/util"
 	"github.com/jeffail/util/log"
@@ -61,6 +62,7 @@ type LeapsConfig struct {
 	CuratorConfig        lib.CuratorConfig        `json:"curator" yaml:"curator"`
 	HTTPServerConfig     net.HTTPServerConfig     `json:"http_server" yaml:"http_server"`
 	InternalServerConfig net.InternalServerConfig `json:"admin_server" yaml:"admin_server"`
+	WebhookConfig        webhook.Config           `json:"webhooks" yaml:"webhooks"`
 }
 
 /*--------------------------------------------------------------------------------------------------
@@ -125,6 +127,7 @@ func main() {
 		CuratorConfig:        lib.DefaultCuratorConfig(),
 		HTTPServerConfig:     net.DefaultHTTPServerConfig(),
 		InternalServerConfig: net.NewInternalServerConfig(),
+		WebhookConfig:        webhook.NewConfig(),
 	}
 
 	// A list of default config paths to check for if not explicitly defined
@@ -187,6 +190,16 @@ func main() {
 		return
 	}
 
+	// Webhooks, closed after the curator so that the final events of documents are sent
+	var webhooks *webhook.Dispatcher
+	if len(leapsConfig.WebhookConfig.Endpoints) > 0 {
+		if webhooks, err = webhook.NewDispatcher(leapsConfig.WebhookConfig, logger, stats); err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Webhook error: %v\n", err))
+			return
+		}
+		defer webhooks.Close()
+	}
+
 	// Curator of documents
 	curator, err := lib.NewCurator(leapsConfig.CuratorConfig, logger, stats, authenticator, documentStore)
 	if err != nil {
@@ -194,6 +207,9 @@ func main() {
 		return
 	}
 	defer curator.Close()
+	if webhooks != nil {
+		curator.AddObserver(webhooks)
+	}
 
 	// HTTP API
 	leapHTTP, err := net.CreateHTTPServer(curator, leapsConfig.HTTPServerConfig, logger, stats)
@@ -23,9 +23,12 @@ THE SOFTWARE.
 package lib
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/jeffail/leaps/lib/auth"
 	"github.com/jeffail/leaps/lib/store"
 	"github.com/jeffail/leaps/lib/util"
 	"github.com/jeffail/util/log"
@@ -39,11 +42,48 @@ import (
 BinderConfig - Holds configuration options for a binder.
 */
 type BinderConfig struct {
-	FlushPeriod           int64       `json:"flush_period_ms" yaml:"flush_period_ms"`
-	RetentionPeriod       int64       `json:"retention_period_s" yaml:"retention_period_s"`
-	ClientKickPeriod      int64       `json:"kick_period_ms" yaml:"kick_period_ms"`
-	CloseInactivityPeriod int64       `json:"close_inactivity_period_s" yaml:"close_inactivity_period_s"`
-	ModelConfig           ModelConfig `json:"transform_model" yaml:"transform_model"`
+	FlushPeriod           int64 `json:"flush_period_ms" yaml:"flush_period_ms"`
+	RetentionPeriod       int64 `json:"retention_period_s" yaml:"retention_period_s"`
+	CloseInactivityPeriod int64 `json:"close_inactivity_period_s" yaml:"close_inactivity_period_s"`
+
+	// How long a client has to accept its subscription, and to receive its remaining queue on close.
+	ClientKickPeriod int64 `json:"kick_period_ms" yaml:"kick_period_ms"`
+
+	// The number of transforms of each client that can be undone.
+	UndoLimit int `json:"undo_limit" yaml:"undo_limit"`
+
+	// Versions of flushed transforms kept in the journal of a document, zero disables journals.
+	// Journals are only kept by stores that support them, currently the memory and file stores.
+	JournalRetention int `json:"journal_retention" yaml:"journal_retention"`
+
+	// The access level required to accept or reject the suggestions of other users.
+	ReviewAccess auth.AccessLevel `json:"review_access" yaml:"review_access"`
+
+	// Items that can wait for a client before the overflow policy, "kick", "drop_presence" or
+	// "coalesce", is applied.
+	ClientQueueSize      int    `json:"client_queue_size" yaml:"client_queue_size"`
+	ClientOverflowPolicy string `json:"client_overflow_policy" yaml:"client_overflow_policy"`
+
+	// Directory of the write-ahead logs of unflushed transforms, disabled when empty.
+	WALDirectory string `json:"wal_directory" yaml:"wal_directory"`
+
+	// Events that can wait for each observer before further events are dropped.
+	EventQueueSize int `json:"event_queue_size" yaml:"event_queue_size"`
+
+	// Clients that can subscribe at once, unlimited when zero.
+	MaxClients int `json:"max_clients" yaml:"max_clients"`
+
+	// Rate limits of the transforms and messages of each client.
+	TransformRateLimit RateLimitConfig `json:"transform_rate_limit" yaml:"transform_rate_limit"`
+	MessageRateLimit   RateLimitConfig `json:"message_rate_limit" yaml:"message_rate_limit"`
+
+	// Rate limit offences after which a client is kicked, never when zero.
+	RateLimitKickAfter int `json:"rate_limit_kick_after" yaml:"rate_limit_kick_after"`
+
+	// How long a client may submit nothing, not even pings, before it is evicted, never when zero.
+	ClientIdleTimeout int64 `json:"client_idle_timeout_ms" yaml:"client_idle_timeout_ms"`
+
+	ModelConfig ModelConfig `json:"transform_model" yaml:"transform_model"`
 }
 
 /*
@@ -56,6 +96,18 @@ func DefaultBinderConfig() BinderConfig {
 		RetentionPeriod:       60,
 		ClientKickPeriod:      200,
 		CloseInactivityPeriod: 300,
+		UndoLimit:             100,
+		JournalRetention:      0,
+		ReviewAccess:          auth.EditAccess,
+		ClientQueueSize:       256,
+		ClientOverflowPolicy:  OverflowKick,
+		WALDirectory:          "",
+		EventQueueSize:        1000,
+		MaxClients:            0,
+		TransformRateLimit:    DefaultRateLimitConfig(),
+		MessageRateLimit:      DefaultRateLimitConfig(),
+		RateLimitKickAfter:    0,
+		ClientIdleTimeout:     0,
 		ModelConfig:           DefaultModelConfig(),
 	}
 }
@@ -63,6 +115,19 @@ func DefaultBinderConfig() BinderConfig {
 /*--------------------------------------------------------------------------------------------------
  */
 
+// Errors for the Binder type.
+var (
+	ErrNothingToUndo      = errors.New("there are no transforms to undo")
+	ErrNothingToRedo      = errors.New("there are no transforms to redo")
+	ErrUndoUnsupported    = errors.New("the transform model of this document does not support undo")
+	ErrContentUnsupported = errors.New(
+		"the transform model of this document does not support replacing the full content")
+	ErrOverflowPolicy = errors.New("client overflow policy was not recognised")
+	ErrTooManyClients = errors.New("document has reached its maximum number of clients")
+	ErrRateLimited    = errors.New("client exceeded its rate limit of submissions")
+	ErrClientNotFound = errors.New("client was not found")
+)
+
 /*
 Binder - Contains a single document and acts as a broker between multiple readers, writers and the
 storage strategy.
@@ -75,23 +140,71 @@ type Binder struct {
 	log    *log.Logger
 	stats  metrics.Aggregator
 
+	// Live content of the document, the content and type last read from or written to the store
+	// and its version, and the content at the latest version of the model, which is used for
+	// checksums. Only used when the model supports applying transforms to a rope.
+	content       *Rope
+	stored        string
+	storedType    string
+	storedVersion int
+	head          *Rope
+
+	// Journal of flushed transforms, transforms waiting for the next flush, and the version of the
+	// first transform of the journal. Nil when disabled or unsupported.
+	journal        *storeJournal
+	journalPending []OTransform
+	journalStart   int
+
+	// Write-ahead log of transforms that have not been flushed, nil when disabled.
+	wal *writeAheadLog
+
+	// Signals of modifications of the stored document made by something other than this binder,
+	// nil when the store cannot be watched, and whether a signal is yet to be handled by a flush.
+	watchChan     <-chan struct{}
+	stopWatch     func()
+	storeModified bool
+
+	// Queues of the observers of binder events, and those that were added to this binder alone.
+	observers      []*eventQueue
+	ownedObservers []*eventQueue
+	observerMut    sync.RWMutex
+
+	// Locked regions, comment threads and suggestions of the document, kept in relation to the
+	// latest version of the model. These can change without the content, in which case they are
+	// flagged for the next flush.
+	locks       []store.Lock
+	comments    []store.CommentThread
+	suggestions []store.Suggestion
+	metaChanged bool
+
 	// Clients
 	clients       []*BinderClient
+	history       map[*BinderClient]*undoHistory
+	cursors       map[*BinderClient]*Message
 	subscribeChan chan BinderSubscribeBundle
 
 	// Control channels
 	transformChan    chan TransformSubmission
+	undoChan         chan UndoSubmission
+	lockChan         chan LockSubmission
+	commentChan      chan CommentSubmission
+	suggestionChan   chan SuggestionSubmission
+	resyncChan       chan ResyncSubmission
+	contentChan      chan ContentSubmission
 	messageChan      chan MessageSubmission
 	usersRequestChan chan usersRequestObj
 	exitChan         chan *BinderClient
 	kickChan         chan kickRequest
+	metadataChan     chan MetadataSubmission
+	pingChan         chan *BinderClient
 	errorChan        chan<- BinderError
 	closedChan       chan struct{}
 }
 
 /*
 NewBinder - Creates a binder targeting an existing document determined via an ID. Must provide a
-store.Store to acquire the document and apply future updates to.
+store.Store to acquire the document and apply future updates to. The transform model of the binder
+is chosen from the registered models by the type of the document.
 */
 func NewBinder(
 	id string,
@@ -102,29 +215,90 @@ func NewBinder(
 	stats metrics.Aggregator,
 ) (*Binder, error) {
 
+	switch config.ClientOverflowPolicy {
+	case OverflowKick, OverflowDropPresence, OverflowCoalesce:
+	default:
+		stats.Incr("binder.new.error", 1)
+		return nil, ErrOverflowPolicy
+	}
+
+	if len(config.WALDirectory) > 0 {
+		recoverDocumentWAL(config.WALDirectory, id, block, config.ModelConfig, log, stats)
+	}
+
+	doc, err := block.Read(id)
+	if err != nil {
+		stats.Incr("binder.new.error", 1)
+		return nil, err
+	}
+	model, err := NewModel(doc.Type, config.ModelConfig)
+	if err != nil {
+		stats.Incr("binder.new.error", 1)
+		return nil, fmt.Errorf("failed to bind document of type '%v': %v", doc.Type, err)
+	}
+
 	binder := Binder{
 		ID:               id,
 		config:           config,
-		model:            CreateTextModel(config.ModelConfig),
+		model:            model,
 		block:            block,
 		log:              log.NewModule(":binder"),
 		stats:            stats,
 		clients:          make([]*BinderClient, 0),
+		history:          make(map[*BinderClient]*undoHistory),
+		cursors:          make(map[*BinderClient]*Message),
 		subscribeChan:    make(chan BinderSubscribeBundle),
 		transformChan:    make(chan TransformSubmission),
+		undoChan:         make(chan UndoSubmission),
+		lockChan:         make(chan LockSubmission),
+		commentChan:      make(chan CommentSubmission),
+		suggestionChan:   make(chan SuggestionSubmission),
+		resyncChan:       make(chan ResyncSubmission),
+		contentChan:      make(chan ContentSubmission),
 		messageChan:      make(chan MessageSubmission),
 		usersRequestChan: make(chan usersRequestObj),
 		exitChan:         make(chan *BinderClient),
 		kickChan:         make(chan kickRequest),
+		metadataChan:     make(chan MetadataSubmission),
+		pingChan:         make(chan *BinderClient),
 		errorChan:        errorChan,
 		closedChan:       make(chan struct{}),
 	}
+	if config.JournalRetention > 0 {
+		journalModel, modelOk := model.(JournaledModel)
+		journalStore, storeOk := block.(store.JournalStore)
+		if modelOk && storeOk {
+			journal := storeJournal{id: id, store: journalStore}
+			first, last, err := journal.versionRange()
+			if err != nil {
+				stats.Incr("binder.new.error", 1)
+				return nil, fmt.Errorf("failed to read journal of document: %v", err)
+			}
+			journalModel.SetJournal(journal, last)
+			binder.journal, binder.journalStart = &journal, first
+		}
+	}
 	binder.log.Debugln("Bound to document, attempting flush")
 
-	if _, err := binder.flush(); err != nil {
+	doc, err = binder.flush()
+	if err != nil {
 		stats.Incr("binder.new.error", 1)
 		return nil, err
 	}
+	if len(config.WALDirectory) > 0 {
+		if binder.wal, err = openWAL(config.WALDirectory, id); err == nil {
+			err = binder.wal.reset(doc.Content)
+		}
+		if err != nil {
+			stats.Incr("binder.new.error", 1)
+			return nil, err
+		}
+	}
+	if watchable, ok := block.(store.WatchableStore); ok {
+		if binder.watchChan, binder.stopWatch, err = watchable.Watch(id); err != nil {
+			binder.log.Errorf("Failed to watch document for external changes: %v\n", err)
+		}
+	}
 	go binder.loop()
 
 	stats.Incr("binder.new.success", 1)
@@ -135,15 +309,46 @@ func NewBinder(
  */
 
 /*
-BinderClient - A struct containing information about a connected client and channels used by the
-binder to push transforms and user updates out.
+BinderClient - A struct containing information about a connected client, the queue used by the
+binder to push transforms and user updates out, and the rate limits of the client.
 */
 type BinderClient struct {
 	UserID    string `json:"user_id"`
 	SessionID string `json:"session_id"`
 
-	transformChan chan<- OTransform
-	messageChan   chan<- MessageSubmission
+	access          auth.AccessLevel
+	queue           *clientQueue
+	transformBucket *tokenBucket
+	messageBucket   *tokenBucket
+	offences        int
+
+	metadata   ClientMetadata
+	joined     time.Time
+	lastActive time.Time
+}
+
+/*
+ClientInfo - A record of a client of a binder. Joined and LastActive are unix timestamps, where the
+last activity is the last time the client submitted anything other than a request for the document.
+The cursor is the last known cursor, selection or presence of the client, if it has sent one.
+*/
+type ClientInfo struct {
+	UserID     string `json:"user_id"`
+	SessionID  string `json:"session_id"`
+	Joined     int64  `json:"joined"`
+	LastActive int64  `json:"last_active"`
+	ReadOnly   bool   `json:"read_only"`
+	ClientMetadata
+	Cursor *Message `json:"cursor,omitempty"`
+}
+
+/*
+undoHistory - The versions of transforms submitted by a client that can be undone, and the versions
+of the transforms that undid them which can be redone.
+*/
+type undoHistory struct {
+	undo []int
+	redo []int
 }
 
 /*
@@ -161,14 +366,29 @@ type BinderError struct {
  */
 
 type usersRequestObj struct {
-	responseChan chan<- []string
+	responseChan chan<- []ClientInfo
 }
 
 /*
 GetUsers - Get a list of user id's connected to this binder.
 */
 func (b *Binder) GetUsers(timeout time.Duration) ([]string, error) {
-	resChan := make(chan []string)
+	clients, err := b.GetClients(timeout)
+	if err != nil {
+		return []string{}, err
+	}
+	users := make([]string, len(clients))
+	for i, client := range clients {
+		users[i] = client.UserID
+	}
+	return users, nil
+}
+
+/*
+GetClients - Get the records of the clients connected to this binder.
+*/
+func (b *Binder) GetClients(timeout time.Duration) ([]ClientInfo, error) {
+	resChan := make(chan []ClientInfo)
 	b.usersRequestChan <- usersRequestObj{resChan}
 
 	select {
@@ -176,23 +396,52 @@ func (b *Binder) GetUsers(timeout time.Duration) ([]string, error) {
 		return result, nil
 	case <-time.After(timeout):
 	}
-	return []string{}, ErrTimeout
+	return []ClientInfo{}, ErrTimeout
 }
 
+/*
+kickRequest - A request to remove either every client of a user or a single session from the binder,
+the reason is sent to the removed clients.
+*/
 type kickRequest struct {
-	userID string
-	result chan error
+	userID    string
+	sessionID string
+	reason    string
+	result    chan error
 }
 
 /*
-KickUser - Signals the binder to remove a particular user. Currently doesn't confirm removal, this
-ought to be a blocking call until the removal is validated.
+KickUser - Signals the binder to remove every client of a particular user. Returns
+ErrClientNotFound if the user is not connected.
 */
 func (b *Binder) KickUser(userID string, timeout time.Duration) error {
-	result := make(chan error)
+	return b.KickUserWithReason(userID, "", timeout)
+}
+
+/*
+KickUserWithReason - Signals the binder to remove every client of a particular user, each client is
+sent a final message with the reason of the kick. Returns ErrClientNotFound if the user is not
+connected.
+*/
+func (b *Binder) KickUserWithReason(userID, reason string, timeout time.Duration) error {
+	return b.kick(kickRequest{userID: userID, reason: reason}, timeout)
+}
+
+/*
+KickSession - Signals the binder to remove the client of a particular session, the client is sent a
+final message with the reason of the kick. Returns ErrClientNotFound if the session is not connected.
+*/
+func (b *Binder) KickSession(sessionID, reason string, timeout time.Duration) error {
+	return b.kick(kickRequest{sessionID: sessionID, reason: reason}, timeout)
+}
+
+func (b *Binder) kick(request kickRequest, timeout time.Duration) error {
+	// Buffered so that the binder never blocks on a result that is no longer awaited.
+	result := make(chan error, 1)
+	request.result = result
 	timer := time.After(timeout)
 	select {
-	case b.kickChan <- kickRequest{userID: userID, result: result}:
+	case b.kickChan <- request:
 	case <-timer:
 		return ErrTimeout
 	}
@@ -204,15 +453,55 @@ func (b *Binder) KickUser(userID string, timeout time.Duration) error {
 	}
 }
 
+/*
+ReplaceContent - Replaces the full content of the document on behalf of a service rather than a
+connected client, such as an integration that only has the new content of a document. The difference
+from the latest content is submitted as a normal transform, so that connected clients receive an
+incremental edit. Returns the version of the transform, or the current version if the content was
+unchanged. Locked regions of the document cannot be modified this way.
+*/
+func (b *Binder) ReplaceContent(content string, timeout time.Duration) (int, error) {
+	errChan := make(chan error, 1)
+	verChan := make(chan int, 1)
+
+	timer := time.After(timeout)
+	select {
+	case b.contentChan <- ContentSubmission{
+		Content:     content,
+		VersionChan: verChan,
+		ErrorChan:   errChan,
+	}:
+	case <-timer:
+		return 0, ErrTimeout
+	}
+	select {
+	case err := <-errChan:
+		return 0, err
+	case ver := <-verChan:
+		return ver, nil
+	case <-timer:
+	}
+	return 0, ErrTimeout
+}
+
 /*
 Subscribe - Returns a BinderPortal, which represents a contract between a client and the binder. If
 the subscription was unsuccessful the BinderPortal will contain an error.
 */
 func (b *Binder) Subscribe(userID string) BinderPortal {
+	return b.SubscribeWithAccess(userID, auth.EditAccess)
+}
+
+/*
+SubscribeWithAccess - Returns a BinderPortal in the same way as Subscribe, where the access level of
+the client determines which locked regions of the document it is permitted to edit.
+*/
+func (b *Binder) SubscribeWithAccess(userID string, access auth.AccessLevel) BinderPortal {
 	retChan := make(chan BinderPortal, 1)
 	bundle := BinderSubscribeBundle{
 		PortalRcvChan: retChan,
 		UserID:        userID,
+		Access:        access,
 	}
 	b.subscribeChan <- bundle
 
@@ -229,11 +518,14 @@ func (b *Binder) SubscribeReadOnly(userID string) BinderPortal {
 	bundle := BinderSubscribeBundle{
 		PortalRcvChan: retChan,
 		UserID:        userID,
+		Access:        auth.ReadAccess,
 	}
 	b.subscribeChan <- bundle
 
 	portal := <-retChan
 	portal.TransformSndChan = nil
+	portal.UndoSndChan = nil
+	portal.ContentSndChan = nil
 
 	return portal
 }
@@ -256,6 +548,16 @@ involves flushing the model in order to obtain a clean version of the document,
 we return false to flag the binder loop that we should shut down.
 */
 func (b *Binder) processSubscriber(request BinderSubscribeBundle) error {
+	if b.config.MaxClients > 0 && len(b.clients) >= b.config.MaxClients {
+		b.stats.Incr("binder.rejected_client.full", 1)
+		b.log.Infof("Rejected client request %v, binder is full\n", request.UserID)
+		select {
+		case request.PortalRcvChan <- BinderPortal{Error: ErrTooManyClients}:
+		default:
+		}
+		return nil
+	}
+
 	transformSndChan := make(chan OTransform, 1)
 	messageSndChan := make(chan MessageSubmission, 1)
 
@@ -264,28 +566,51 @@ func (b *Binder) processSubscriber(request BinderSubscribeBundle) error {
 	if err != nil {
 		return err
 	}
+	// Clients are always told which model the document is bound to.
+	if len(doc.Type) == 0 {
+		doc.Type = TextDocumentType
+	}
+	now := time.Now()
 	client := BinderClient{
-		UserID:        request.UserID,
-		SessionID:     util.GenerateStampedUUID(),
-		transformChan: transformSndChan,
-		messageChan:   messageSndChan,
+		UserID:    request.UserID,
+		SessionID: util.GenerateStampedUUID(),
+		access:    request.Access,
+		queue:     newClientQueue(b.config, b.stats),
+
+		joined:     now,
+		lastActive: now,
+
+		transformBucket: newTokenBucket(b.config.TransformRateLimit),
+		messageBucket:   newTokenBucket(b.config.MessageRateLimit),
 	}
 	portal := BinderPortal{
-		Client:           &client,
-		Version:          b.model.GetVersion(),
-		Document:         doc,
-		Error:            nil,
-		TransformRcvChan: transformSndChan,
-		MessageRcvChan:   messageSndChan,
-		TransformSndChan: b.transformChan,
-		MessageSndChan:   b.messageChan,
-		ExitChan:         b.exitChan,
+		Client:            &client,
+		Version:           b.model.GetVersion(),
+		PositionUnit:      b.config.ModelConfig.PositionUnit,
+		Document:          doc,
+		Cursors:           b.currentCursors(),
+		Error:             nil,
+		TransformRcvChan:  transformSndChan,
+		MessageRcvChan:    messageSndChan,
+		TransformSndChan:  b.transformChan,
+		UndoSndChan:       b.undoChan,
+		LockSndChan:       b.lockChan,
+		CommentSndChan:    b.commentChan,
+		SuggestionSndChan: b.suggestionChan,
+		ResyncSndChan:     b.resyncChan,
+		ContentSndChan:    b.contentChan,
+		MessageSndChan:    b.messageChan,
+		MetadataSndChan:   b.metadataChan,
+		PingSndChan:       b.pingChan,
+		ExitChan:          b.exitChan,
 	}
 	select {
 	case request.PortalRcvChan <- portal:
 		b.stats.Incr("binder.subscribed_clients", 1)
 		b.log.Debugf("Subscribed new client %v\n", request.UserID)
 		b.clients = append(b.clients, &client)
+		go client.queue.pump(transformSndChan, messageSndChan)
+		b.emit(b.clientEvent(EventClientJoined, &client))
 	case <-time.After(time.Duration(b.config.ClientKickPeriod) * time.Millisecond):
 		/* We're not bothered if you suck, you just don't get enrolled, and this isn't
 		 * considered an error. Deal with it.
@@ -297,6 +622,41 @@ func (b *Binder) processSubscriber(request BinderSubscribeBundle) error {
 	return nil
 }
 
+/*
+processResync - Processes a clients request for the authoritative content of the document. This
+involves flushing the model, if this fails we return the error to flag the binder loop that we
+should shut down.
+*/
+func (b *Binder) processResync(request ResyncSubmission) error {
+	doc, err := b.flush()
+	if err != nil {
+		b.sendClientError(request.ErrorChan, err)
+		return err
+	}
+	if len(doc.Type) == 0 {
+		doc.Type = TextDocumentType
+	}
+	snapshot := Snapshot{
+		Document: doc,
+		Version:  b.model.GetVersion(),
+	}
+	if b.head != nil {
+		snapshot.Checksum = b.head.Checksum()
+	}
+	if request.Client != nil {
+		// Transforms already queued for the client are included in the snapshot.
+		request.Client.queue.pushBarrier()
+	}
+	select {
+	case request.SnapshotChan <- snapshot:
+		b.stats.Incr("binder.resync.success", 1)
+	default:
+		b.log.Errorln("Send client snapshot was blocked")
+		b.stats.Incr("binder.send_client_snapshot.blocked", 1)
+	}
+	return nil
+}
+
 /*
 sendClientError - Sends an error to a channel, the channel should be non-blocking (buffered by at
 least one and kept empty). In the event where the channel is blocked a log entry is made.
@@ -311,12 +671,24 @@ func (b *Binder) sendClientError(errChan chan<- error, err error) {
 }
 
 /*
-processUsersRequest - Processes a request for the list of connected clients.
+processUsersRequest - Processes a request for the records of connected clients.
 */
 func (b *Binder) processUsersRequest(request usersRequestObj) {
-	var clients []string
+	clients := []ClientInfo{}
 	for _, client := range b.clients {
-		clients = append(clients, client.UserID)
+		info := ClientInfo{
+			UserID:         client.UserID,
+			SessionID:      client.SessionID,
+			Joined:         client.joined.Unix(),
+			LastActive:     client.lastActive.Unix(),
+			ReadOnly:       client.access < auth.EditAccess,
+			ClientMetadata: client.metadata,
+		}
+		if cursor, ok := b.cursors[client]; ok {
+			copied := copyCursor(*cursor)
+			info.Cursor = &copied
+		}
+		clients = append(clients, info)
 	}
 	select {
 	case request.responseChan <- clients:
@@ -329,16 +701,89 @@ func (b *Binder) processUsersRequest(request usersRequestObj) {
 	}
 }
 
+/*
+processMetadata - Sets the metadata of a client, when the profile of the client changes the other
+clients are told about it.
+*/
+func (b *Binder) processMetadata(request MetadataSubmission) {
+	if request.Client == nil {
+		return
+	}
+	changed := request.Client.metadata.ClientProfile != request.Metadata.ClientProfile
+	request.Client.metadata = request.Metadata
+	if changed {
+		b.processMessage(MessageSubmission{Client: request.Client, Message: Message{Active: true}})
+	}
+}
+
+/*
+clientProfile - Returns a copy of the profile of a client to attach to its messages, or nil if the
+client has not supplied one.
+*/
+func clientProfile(client *BinderClient) *ClientProfile {
+	if client == nil || client.metadata.ClientProfile == (ClientProfile{}) {
+		return nil
+	}
+	profile := client.metadata.ClientProfile
+	return &profile
+}
+
+/*
+touch - Records activity of a client, which is nil for submissions of the binder itself.
+*/
+func (b *Binder) touch(client *BinderClient) {
+	if client != nil {
+		client.lastActive = time.Now()
+	}
+}
+
+/*
+reapIdleClients - Evicts the clients that have not been active within the idle timeout, each client
+is sent a final kicked message and the remaining clients are told that it is no longer active.
+*/
+func (b *Binder) reapIdleClients(now time.Time) {
+	timeout := time.Duration(b.config.ClientIdleTimeout) * time.Millisecond
+
+	idle := []*BinderClient{}
+	for _, c := range b.clients {
+		if now.Sub(c.lastActive) > timeout {
+			idle = append(idle, c)
+		}
+	}
+	for _, c := range idle {
+		b.log.Infof("Evicting idle client for user: (%v)\n", c.UserID)
+		b.stats.Incr("binder.clients_evicted", 1)
+		b.kickClient(c, "inactive")
+	}
+}
+
 /*
 processTransform - Processes a clients transform submission, and broadcasts the transform out to
 other clients.
 */
 func (b *Binder) processTransform(request TransformSubmission) {
+	b.pushTransform(request, false)
+}
+
+/*
+pushTransform - Pushes a transform submission to the model and broadcasts the result out to clients,
+including the client the transform came from when echo is set.
+*/
+func (b *Binder) pushTransform(request TransformSubmission, echo bool) {
 	var dispatch OTransform
 	var err error
 	var version int
 
 	b.log.Debugf("Received transform: %q\n", fmt.Sprintf("%v", request.Transform))
+	if err = b.limitTransform(request.Client); err != nil {
+		b.sendClientError(request.ErrorChan, err)
+		return
+	}
+	if err = b.checkLocks(request.Client, request.Transform); err != nil {
+		b.stats.Incr("binder.process_job.locked", 1)
+		b.sendClientError(request.ErrorChan, err)
+		return
+	}
 	dispatch, version, err = b.model.PushTransform(request.Transform)
 
 	if err != nil {
@@ -346,6 +791,15 @@ func (b *Binder) processTransform(request TransformSubmission) {
 		b.sendClientError(request.ErrorChan, err)
 		return
 	}
+	b.trackTransform(request.Client, &dispatch)
+	if request.ChecksumChan != nil {
+		select {
+		case request.ChecksumChan <- dispatch.Checksum:
+		default:
+			b.log.Errorln("Send client checksum was blocked")
+			b.stats.Incr("binder.send_client_checksum.blocked", 1)
+		}
+	}
 	select {
 	case request.VersionChan <- version:
 	default:
@@ -354,92 +808,623 @@ func (b *Binder) processTransform(request TransformSubmission) {
 	}
 	b.stats.Incr("binder.process_job.success", 1)
 
-	clientKickPeriod := (time.Duration(b.config.ClientKickPeriod) * time.Millisecond)
-
-	for i, c := range b.clients {
-		// Skip sends for client from which the message came
-		if c == request.Client {
-			continue
+	if request.Client != nil {
+		history, ok := b.history[request.Client]
+		if !ok {
+			history = &undoHistory{}
+			b.history[request.Client] = history
 		}
+		history.undo = appendLimited(history.undo, version, b.config.UndoLimit)
+		history.redo = nil
+	}
+
+	if echo {
+		b.broadcastTransform(dispatch, nil)
+	} else {
+		b.broadcastTransform(dispatch, request.Client)
+	}
+	b.shiftCursors(dispatch)
+}
+
+/*
+processContent - Processes a submission of the full content of the document. The difference between
+the latest content and the submitted content is pushed as a transform, which is broadcast to all
+clients including the submitting client, as the client has not applied it locally.
+*/
+func (b *Binder) processContent(request ContentSubmission) {
+	if b.head == nil {
+		b.stats.Incr("binder.process_content.error", 1)
+		b.sendClientError(request.ErrorChan, ErrContentUnsupported)
+		return
+	}
+	version := b.model.GetVersion()
+	tform, changed := DiffTransform(
+		b.head.String(), request.Content, b.config.ModelConfig.PositionUnit, version+1,
+	)
+	if !changed {
+		b.stats.Incr("binder.process_content.unchanged", 1)
 		select {
-		case c.transformChan <- dispatch:
-		case <-time.After(clientKickPeriod):
-			/* The client may have stopped listening, or is just being slow.
-			 * Either way, we have a strict policy here of no time wasters.
-			 */
-			b.stats.Decr("binder.subscribed_clients", 1)
-			b.stats.Incr("binder.clients_kicked", 1)
+		case request.VersionChan <- version:
+		default:
+			b.log.Errorln("Send client version was blocked")
+			b.stats.Incr("binder.send_client_version.blocked", 1)
+		}
+		return
+	}
+	b.stats.Incr("binder.process_content.diff", 1)
+	b.pushTransform(TransformSubmission{
+		Client:      request.Client,
+		Transform:   tform,
+		VersionChan: request.VersionChan,
+		ErrorChan:   request.ErrorChan,
+	}, true)
+}
 
-			b.log.Debugf("Kicking client for user: (%v) for blocked transform send\n", c.UserID)
+/*
+trackTransform - Moves the locks, comment threads and suggestions of the document through a
+transform that was pushed to the model by a client, which is nil for transforms of the binder, sets
+the checksum of the transform, adds it to the pending entries of the journal and notifies observers.
+*/
+func (b *Binder) trackTransform(client *BinderClient, dispatch *OTransform) {
+	b.shiftTransformLocks(*dispatch)
+	b.shiftComments(*dispatch)
+	b.shiftSuggestions(*dispatch)
+	b.applyHead(dispatch)
 
-			b.clients = append(b.clients[:i], b.clients[i+1:]...)
-			close(c.transformChan)
-			close(c.messageChan)
+	if b.journal != nil {
+		b.journalPending = append(b.journalPending, *dispatch)
+	}
+	if b.wal != nil {
+		if err := b.wal.appendTransform(*dispatch); err != nil {
+			b.stats.Incr("binder.wal.error", 1)
+			b.log.Errorf("Failed to append to write-ahead log: %v\n", err)
 		}
 	}
+
+	tform := *dispatch
+	event := b.clientEvent(EventTransformApplied, client)
+	event.Transform = &tform
+	b.emit(event)
+}
+
+/*
+applyHead - Applies a transform to the content at the latest version and sets the checksum of the
+transform. If the transform cannot be applied then checksums are disabled until the next flush, and
+the problem is left for the flush to report.
+*/
+func (b *Binder) applyHead(dispatch *OTransform) {
+	if b.head == nil || dispatch.JSONOp != nil {
+		return
+	}
+	for _, op := range transformOperations(dispatch) {
+		if _, err := b.head.SpliceUnits(
+			b.config.ModelConfig.PositionUnit, op.Position, op.Delete, op.Insert,
+		); err != nil {
+			b.log.Errorf("Failed to apply transform for checksum: %v\n", err)
+			b.stats.Incr("binder.checksum.error", 1)
+			b.head = nil
+			return
+		}
+	}
+	dispatch.Checksum = b.head.Checksum()
+}
+
+/*
+appendLimited - Appends a version to a stack of versions, dropping the oldest once the limit is
+exceeded.
+*/
+func appendLimited(versions []int, version, limit int) []int {
+	versions = append(versions, version)
+	if len(versions) > limit {
+		versions = versions[len(versions)-limit:]
+	}
+	return versions
+}
+
+/*
+processUndo - Processes a clients request to undo or redo a transform. The inverse of the transform
+is obtained from the model and then pushed as a new transform, which is broadcast to all clients
+including the requesting client. When the live content is held in a rope, transforms yet to be
+flushed are inverted without flushing. The transform is only removed from the history of the client
+once the undo succeeds.
+*/
+func (b *Binder) processUndo(request UndoSubmission) {
+	history, ok := b.history[request.Client]
+	if !ok {
+		history = &undoHistory{}
+	}
+	stack, errEmpty := &history.undo, ErrNothingToUndo
+	if request.Redo {
+		stack, errEmpty = &history.redo, ErrNothingToRedo
+	}
+	if err := b.limitTransform(request.Client); err != nil {
+		b.sendClientError(request.ErrorChan, err)
+		return
+	}
+	if len(*stack) == 0 {
+		b.stats.Incr("binder.process_undo.empty", 1)
+		b.sendClientError(request.ErrorChan, errEmpty)
+		return
+	}
+	invertible, ok := b.model.(InvertibleModel)
+	if !ok {
+		b.stats.Incr("binder.process_undo.error", 1)
+		b.sendClientError(request.ErrorChan, ErrUndoUnsupported)
+		return
+	}
+
+	target := (*stack)[len(*stack)-1]
+
+	b.log.Debugf("Received undo request, redo: %v, target version: %v\n", request.Redo, target)
+
+	var inverse OTransform
+	var err error
+	if b.content != nil {
+		inverse, err = invertible.InvertUnappliedTransform(target, b.content)
+	} else {
+		inverse, err = invertible.InvertTransform(target)
+	}
+	if err != nil {
+		b.stats.Incr("binder.process_undo.error", 1)
+		b.sendClientError(request.ErrorChan, err)
+		return
+	}
+	if err = b.checkLocks(request.Client, inverse); err != nil {
+		b.stats.Incr("binder.process_undo.locked", 1)
+		b.sendClientError(request.ErrorChan, err)
+		return
+	}
+	dispatch, version, err := b.model.PushTransform(inverse)
+	if err != nil {
+		b.stats.Incr("binder.process_undo.error", 1)
+		b.sendClientError(request.ErrorChan, err)
+		return
+	}
+	b.trackTransform(request.Client, &dispatch)
+
+	*stack = (*stack)[:len(*stack)-1]
+	if request.Redo {
+		history.undo = appendLimited(history.undo, version, b.config.UndoLimit)
+	} else {
+		history.redo = appendLimited(history.redo, version, b.config.UndoLimit)
+	}
+	b.history[request.Client] = history
+
+	select {
+	case request.VersionChan <- version:
+	default:
+		b.log.Errorln("Send client version was blocked")
+		b.stats.Incr("binder.send_client_version.blocked", 1)
+	}
+	b.stats.Incr("binder.process_undo.success", 1)
+
+	// The requesting client has not applied the transform locally, so it must also receive it.
+	b.broadcastTransform(dispatch, nil)
+	b.shiftCursors(dispatch)
+}
+
+/*
+broadcastTransform - Queues a transform for all clients other than the client it came from, which
+can be nil in order to send to all clients.
+*/
+func (b *Binder) broadcastTransform(dispatch OTransform, from *BinderClient) {
+	overflowed := []*BinderClient{}
+	for _, c := range b.clients {
+		// Skip sends for client from which the message came
+		if c == from {
+			continue
+		}
+		if !c.queue.pushTransform(dispatch) {
+			overflowed = append(overflowed, c)
+		}
+	}
+	for _, c := range overflowed {
+		b.log.Debugf("Kicking client for user: (%v) for overflowed transform queue\n", c.UserID)
+		b.stats.Incr("binder.clients_kicked", 1)
+		b.removeClient(c, false)
+	}
 }
 
 /*
 processMessage - Sends a clients message out to other clients.
 */
 func (b *Binder) processMessage(request MessageSubmission) {
-	clientKickPeriod := (time.Duration(b.config.ClientKickPeriod) * time.Millisecond)
+	b.log.Tracef("Received message: %v %v\n", request.Client, request.Message)
 
-	b.log.Tracef("Received message: %v %v\n", *request.Client, request.Message)
+	request.Profile = clientProfile(request.Client)
+	if request.Client != nil {
+		msg := request.Message
+		if msg.Position != nil || msg.Selection != nil || msg.Presence != nil {
+			cursor := copyCursor(msg)
+			b.cursors[request.Client] = &cursor
+		}
+		if !request.Message.Active {
+			delete(b.cursors, request.Client)
+		}
+	}
 
-	for i, c := range b.clients {
+	overflowed := []*BinderClient{}
+	for _, c := range b.clients {
 		// Skip sends for client from which the message came
 		if c == request.Client {
 			continue
 		}
-		select {
-		case c.messageChan <- request:
-			b.stats.Incr("binder.sent_message", 1)
-		case <-time.After(clientKickPeriod):
-			/* The client may have stopped listening, or is just being slow.
-			 * Either way, we have a strict policy here of no time wasters.
-			 */
-			b.stats.Decr("binder.subscribed_clients", 1)
-			b.stats.Incr("binder.clients_kicked", 1)
+		if !c.queue.pushMessage(request) {
+			overflowed = append(overflowed, c)
+		}
+	}
+	for _, c := range overflowed {
+		b.log.Debugf("Kicking client for user: (%v) for overflowed message queue\n", c.UserID)
+		b.stats.Incr("binder.clients_kicked", 1)
+		b.removeClient(c, false)
+	}
+}
 
-			b.log.Debugf("Kicking client for user: (%v) for blocked transform send\n", c.UserID)
+/*
+processKick - Removes the clients matching a kick request, each client is sent a final message with
+the reason of the kick and is given the kick period to receive it.
+*/
+func (b *Binder) processKick(request kickRequest) {
+	b.log.Debugf("Received kick request for user: (%v) session: (%v)\n", request.userID, request.sessionID)
 
+	kicked := []*BinderClient{}
+	for _, c := range b.clients {
+		if len(request.sessionID) > 0 {
+			if c.SessionID == request.sessionID {
+				kicked = append(kicked, c)
+			}
+		} else if c.UserID == request.userID {
+			kicked = append(kicked, c)
+		}
+	}
+	for _, c := range kicked {
+		b.log.Infof("Kicking client for user: (%v) with reason: %v\n", c.UserID, request.reason)
+		b.stats.Incr("binder.clients_kicked", 1)
+		b.kickClient(c, request.reason)
+	}
+	if len(kicked) > 0 {
+		request.result <- nil
+	} else {
+		request.result <- ErrClientNotFound
+	}
+}
+
+/*
+kickClient - Removes a client from the binder after sending it a final kicked message with a reason,
+which the client is given the kick period to receive, and announces to the remaining clients that it
+is no longer active.
+*/
+func (b *Binder) kickClient(client *BinderClient, reason string) {
+	client.queue.pushMessage(MessageSubmission{
+		Client:  client,
+		Message: Message{Kicked: true, Reason: reason},
+	})
+	b.removeClient(client, true)
+	b.processMessage(MessageSubmission{Client: client, Message: Message{Active: false}})
+}
+
+/*
+removeClient - Removes a client from the binder and closes its queue, when drain is set the client is
+given the kick period to receive the items remaining in its queue.
+*/
+func (b *Binder) removeClient(client *BinderClient, drain bool) {
+	for i, c := range b.clients {
+		if c == client {
+			b.stats.Decr("binder.subscribed_clients", 1)
 			b.clients = append(b.clients[:i], b.clients[i+1:]...)
-			close(c.transformChan)
-			close(c.messageChan)
+			b.emit(b.clientEvent(EventClientLeft, client))
+			break
 		}
 	}
+	client.queue.close(drain, time.Duration(b.config.ClientKickPeriod)*time.Millisecond)
+	delete(b.history, client)
+	delete(b.cursors, client)
+}
+
+/*
+currentCursors - Returns the last known cursor, selection and presence of each client that has sent
+one.
+*/
+func (b *Binder) currentCursors() []MessageSubmission {
+	cursors := []MessageSubmission{}
+	for _, c := range b.clients {
+		if cursor, ok := b.cursors[c]; ok {
+			cursors = append(cursors, MessageSubmission{
+				Client:  c,
+				Message: copyCursor(*cursor),
+				Profile: clientProfile(c),
+			})
+		}
+	}
+	return cursors
+}
+
+/*
+shiftCursors - Moves the known cursors, selections and presence of clients through a transform, and
+sends any that moved to the other clients so that remote cursors do not drift.
+*/
+func (b *Binder) shiftCursors(ot OTransform) {
+	if ot.JSONOp != nil {
+		return
+	}
+	moved := []MessageSubmission{}
+	for _, c := range b.clients {
+		cursor, ok := b.cursors[c]
+		if !ok {
+			continue
+		}
+		changed := false
+		for _, op := range transformOperations(&ot) {
+			insertLength := int64(unitLength(b.config.ModelConfig.PositionUnit, op.Insert))
+			if cursor.Position != nil {
+				changed = shiftPosition(cursor.Position, &op, insertLength) || changed
+			}
+			if cursor.Selection != nil {
+				changed = shiftSelection(cursor.Selection, &op, insertLength) || changed
+			}
+			if cursor.Presence != nil {
+				for i := range cursor.Presence.Cursors {
+					changed = shiftPosition(&cursor.Presence.Cursors[i], &op, insertLength) || changed
+				}
+				for i := range cursor.Presence.Selections {
+					changed = shiftSelection(&cursor.Presence.Selections[i], &op, insertLength) || changed
+				}
+			}
+		}
+		if changed {
+			moved = append(moved, MessageSubmission{Client: c, Message: copyCursor(*cursor)})
+		}
+	}
+	for _, msg := range moved {
+		b.processMessage(msg)
+	}
+}
+
+/*
+shiftPosition - Moves a position through a single edit, positions within deleted content are moved to
+the start of the edit, and content inserted at a position is placed before it. Returns true if the
+position was moved.
+*/
+func shiftPosition(position *int64, op *OTransform, insertLength int64) bool {
+	pos, start, del := *position, int64(op.Position), int64(op.Delete)
+	switch {
+	case pos >= start+del:
+		*position = pos + insertLength - del
+	case pos > start:
+		*position = start
+	}
+	return *position != pos
+}
+
+/*
+shiftSelection - Moves both ends of a selection through a single edit, returns true if either moved.
+*/
+func shiftSelection(selection *Selection, op *OTransform, insertLength int64) bool {
+	anchorMoved := shiftPosition(&selection.Anchor, op, insertLength)
+	headMoved := shiftPosition(&selection.Head, op, insertLength)
+	return anchorMoved || headMoved
+}
+
+/*
+copyCursor - Returns a copy of the cursor position, selection and presence of a message, which does
+not share memory with the original.
+*/
+func copyCursor(msg Message) Message {
+	cursor := Message{Active: true}
+	if msg.Position != nil {
+		position := *msg.Position
+		cursor.Position = &position
+	}
+	if msg.Selection != nil {
+		selection := *msg.Selection
+		cursor.Selection = &selection
+	}
+	if msg.Presence != nil {
+		cursor.Presence = &Presence{
+			Cursors:    append([]int64(nil), msg.Presence.Cursors...),
+			Selections: append([]Selection(nil), msg.Presence.Selections...),
+		}
+	}
+	return cursor
 }
 
 /*
 flush - Obtain latest document content, flush current changes to document, and store the updated
-version.
+version. When the model supports it the live content is held in a rope, and modifications of the
+stored content made by something other than this binder are merged into it. A watched store is only
+read again once it signals a modification, otherwise the store is read on every flush in order to
+detect them. Either way the whole content is written to the store.
 */
 func (b *Binder) flush() (store.Document, error) {
 	var (
 		errStore, errFlush error
-		changed            bool
+		changed, stored    bool
 		doc                store.Document
 	)
-	doc, errStore = b.block.Read(b.ID)
-	if errStore != nil {
-		b.stats.Incr("binder.block_fetch.error", 1)
-		return doc, errStore
+	ropeModel, isRope := b.model.(RopeModel)
+	if isRope && b.content != nil && b.watchChan != nil && !b.storeModified {
+		doc = store.Document{ID: b.ID, Type: b.storedType, Content: b.stored}
+	} else {
+		if doc, errStore = b.block.Read(b.ID); errStore != nil {
+			b.stats.Incr("binder.block_fetch.error", 1)
+			return doc, errStore
+		}
+		b.storedType, b.storeModified = doc.Type, false
 	}
-	changed, errFlush = b.model.FlushTransforms(&doc.Content, b.config.RetentionPeriod)
-	if changed {
-		errStore = b.block.Update(doc)
+	if isRope {
+		if b.content == nil {
+			b.content = NewRope(doc.Content)
+			b.stored, b.storedVersion = doc.Content, b.model.GetVersion()
+			b.locks = copyLocks(doc.Locks)
+			b.comments = copyComments(doc.Comments)
+			b.suggestions = copySuggestions(doc.Suggestions)
+		} else if doc.Content != b.stored {
+			b.mergeExternal(doc.Content)
+		}
+		changed, errFlush = ropeModel.FlushTransformsToRope(b.content, b.config.RetentionPeriod)
+		if changed {
+			doc.Content = b.content.String()
+		}
+		// Ropes are never modified in place, so the head can share the content.
+		head := *b.content
+		b.head = &head
+		doc.Locks = copyLocks(b.locks)
+		doc.Comments = copyComments(b.comments)
+		doc.Suggestions = copySuggestions(b.suggestions)
+	} else {
+		changed, errFlush = b.model.FlushTransforms(&doc.Content, b.config.RetentionPeriod)
+	}
+	if changed || b.metaChanged {
+		if changed && b.wal != nil {
+			b.writeWAL(b.wal.mark, doc.Content)
+		}
+		if errStore = b.block.Update(doc); errStore == nil {
+			stored = true
+			b.stored, b.storedVersion = doc.Content, b.model.GetVersion()
+			b.metaChanged = false
+			if changed && b.wal != nil {
+				b.writeWAL(b.wal.reset, doc.Content)
+			}
+		}
 	}
 	if errStore != nil || errFlush != nil {
 		b.stats.Incr("binder.flush.error", 1)
-		return doc, fmt.Errorf("%v, %v", errFlush, errStore)
+		err := fmt.Errorf("%v, %v", errFlush, errStore)
+		event := b.clientEvent(EventFlushFailed, nil)
+		event.Error = err.Error()
+		b.emit(event)
+		return doc, err
 	}
 	if changed {
 		b.stats.Incr("binder.flush.success", 1)
 	}
+	if stored {
+		b.emit(b.clientEvent(EventFlushSucceeded, nil))
+	}
+	if b.journal != nil && len(b.journalPending) > 0 {
+		b.writeJournal()
+	}
 	return doc, nil
 }
 
+/*
+mergeExternal - Merges a modification of the stored content made by something other than this
+binder, such as another process or a person editing the file of the document. The difference between
+the content last stored by this binder and the modified content is submitted as a transform of the
+stored version, which the model rebases onto the transforms that are yet to be flushed, and the
+result is sent to all clients. If the modification cannot be merged then the live content is reset
+to the stored content and clients are resynced.
+*/
+func (b *Binder) mergeExternal(content string) {
+	b.log.Infof("Stored content of %v was modified externally, merging\n", b.ID)
+
+	tform, changed := DiffTransform(
+		b.stored, content, b.config.ModelConfig.PositionUnit, b.storedVersion+1,
+	)
+	if !changed {
+		return
+	}
+	dispatch, _, err := b.model.PushTransform(tform)
+	if err != nil {
+		b.stats.Incr("binder.external_change.error", 1)
+		b.log.Errorf("Failed to merge external modification: %v, resyncing clients\n", err)
+		b.resetContent(content)
+		return
+	}
+	b.stats.Incr("binder.external_change.merged", 1)
+	b.trackTransform(nil, &dispatch)
+	b.broadcastTransform(dispatch, nil)
+	b.shiftCursors(dispatch)
+	b.processMessage(MessageSubmission{Message: Message{ExternalChange: true}})
+}
+
+/*
+resetContent - Replaces the live content with the stored content after an external modification
+that could not be merged. Transforms yet to be flushed are dropped, as they refer to the replaced
+content, and all clients are sent the full content at the current version, which supersedes the
+transforms they have received.
+*/
+func (b *Binder) resetContent(content string) {
+	if ropeModel, ok := b.model.(RopeModel); ok && b.model.IsDirty() {
+		// The dropped transforms are flushed to a discarded copy so that versions remain intact.
+		discarded := *b.content
+		if _, err := ropeModel.FlushTransformsToRope(&discarded, b.config.RetentionPeriod); err != nil {
+			b.log.Debugf("Dropped transforms failed to apply: %v\n", err)
+		}
+	}
+	b.content = NewRope(content)
+	head := *b.content
+	b.head = &head
+	b.stored, b.storedVersion = content, b.model.GetVersion()
+	if b.wal != nil {
+		b.writeWAL(b.wal.reset, content)
+	}
+
+	snapshot := Snapshot{
+		Document: store.Document{ID: b.ID, Type: b.storedType, Content: content},
+		Version:  b.model.GetVersion(),
+		Checksum: head.Checksum(),
+	}
+	if len(snapshot.Document.Type) == 0 {
+		snapshot.Document.Type = TextDocumentType
+	}
+	for _, c := range b.clients {
+		c.queue.pushBarrier()
+	}
+	b.stats.Incr("binder.external_change.resync", 1)
+	b.processMessage(MessageSubmission{Message: Message{ExternalChange: true, Resync: &snapshot}})
+}
+
+/*
+needsFlush - Returns true if the model has unapplied transforms, or the locks, comment threads or
+suggestions of the document have changed since the last flush.
+*/
+func (b *Binder) needsFlush() bool {
+	return b.model.IsDirty() || b.metaChanged
+}
+
+/*
+writeWAL - Marks the write-ahead log with the checksum of content, a log that cannot be written is
+not fatal, although transforms may be lost in the event of a crash.
+*/
+func (b *Binder) writeWAL(write func(content string) error, content string) {
+	if err := write(content); err != nil {
+		b.stats.Incr("binder.wal.error", 1)
+		b.log.Errorf("Failed to write to write-ahead log: %v\n", err)
+	}
+}
+
+/*
+writeJournal - Append flushed transforms to the journal of the document, and trim transforms beyond
+the retention. A journal that cannot be written is not fatal, transforms that depend on the missing
+entries will simply be rejected as too old.
+*/
+func (b *Binder) writeJournal() {
+	pending := b.journalPending
+	b.journalPending = nil
+
+	if err := b.journal.appendTransforms(pending); err != nil {
+		b.stats.Incr("binder.journal.error", 1)
+		b.log.Errorf("Failed to append to journal: %v\n", err)
+		return
+	}
+	if b.journalStart == 0 {
+		b.journalStart = pending[0].Version
+	}
+
+	// Trimming rewrites the journal, so we wait until it holds double the retained versions.
+	last := pending[len(pending)-1].Version
+	if last-b.journalStart >= 2*b.config.JournalRetention {
+		before := last - b.config.JournalRetention + 1
+		if err := b.journal.store.TrimJournal(b.ID, before); err != nil {
+			b.stats.Incr("binder.journal.error", 1)
+			b.log.Errorf("Failed to trim journal: %v\n", err)
+			return
+		}
+		b.journalStart = before
+	}
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
 
@@ -451,6 +1436,7 @@ following:
 - Receiving messages and transforms from clients
 - Dispatching received messages and transforms to all other enrolled clients
 - Intermittently flushing changes to the document storage solution
+- Merging modifications of the stored document made externally, when the store can be watched
 - Intermittently checking for active clients, and shutting down when unused
 */
 func (b *Binder) loop() {
@@ -459,6 +1445,13 @@ func (b *Binder) loop() {
 
 	flushTimer := time.NewTimer(flushPeriod)
 	closeTimer := time.NewTimer(closePeriod)
+
+	var idleChan <-chan time.Time
+	if b.config.ClientIdleTimeout > 0 {
+		idleTicker := time.NewTicker(time.Duration(b.config.ClientIdleTimeout) * time.Millisecond / 2)
+		defer idleTicker.Stop()
+		idleChan = idleTicker.C
+	}
 	for {
 		running := true
 		select {
@@ -478,20 +1471,109 @@ func (b *Binder) loop() {
 			}
 		case tform, open := <-b.transformChan:
 			if running && open {
+				b.touch(tform.Client)
 				b.processTransform(tform)
 				closeTimer.Reset(closePeriod)
 			} else {
 				b.log.Infoln("Transforms channel closed, shutting down")
 				running = false
 			}
+		case undo, open := <-b.undoChan:
+			if running && open {
+				b.touch(undo.Client)
+				b.processUndo(undo)
+				closeTimer.Reset(closePeriod)
+			} else {
+				b.log.Infoln("Undo channel closed, shutting down")
+				running = false
+			}
+		case lock, open := <-b.lockChan:
+			if running && open {
+				b.touch(lock.Client)
+				if err := b.processLock(lock); err != nil {
+					b.log.Errorf("Flush error: %v, shutting down\n", err)
+					b.errorChan <- BinderError{ID: b.ID, Err: err}
+					running = false
+				}
+				closeTimer.Reset(closePeriod)
+			} else {
+				b.log.Infoln("Lock channel closed, shutting down")
+				running = false
+			}
+		case comment, open := <-b.commentChan:
+			if running && open {
+				b.touch(comment.Client)
+				if err := b.processComment(comment); err != nil {
+					b.log.Errorf("Flush error: %v, shutting down\n", err)
+					b.errorChan <- BinderError{ID: b.ID, Err: err}
+					running = false
+				}
+				closeTimer.Reset(closePeriod)
+			} else {
+				b.log.Infoln("Comment channel closed, shutting down")
+				running = false
+			}
+		case suggestion, open := <-b.suggestionChan:
+			if running && open {
+				b.touch(suggestion.Client)
+				if err := b.processSuggestion(suggestion); err != nil {
+					b.log.Errorf("Flush error: %v, shutting down\n", err)
+					b.errorChan <- BinderError{ID: b.ID, Err: err}
+					running = false
+				}
+				closeTimer.Reset(closePeriod)
+			} else {
+				b.log.Infoln("Suggestion channel closed, shutting down")
+				running = false
+			}
+		case resync, open := <-b.resyncChan:
+			if running && open {
+				if err := b.processResync(resync); err != nil {
+					b.log.Errorf("Flush error: %v, shutting down\n", err)
+					b.errorChan <- BinderError{ID: b.ID, Err: err}
+					running = false
+				}
+			} else {
+				b.log.Infoln("Resync channel closed, shutting down")
+				running = false
+			}
+		case content, open := <-b.contentChan:
+			if running && open {
+				b.touch(content.Client)
+				b.processContent(content)
+				closeTimer.Reset(closePeriod)
+			} else {
+				b.log.Infoln("Content channel closed, shutting down")
+				running = false
+			}
 		case message, open := <-b.messageChan:
 			if running && open {
-				b.processMessage(message)
+				b.touch(message.Client)
+				if b.limitMessage(message) {
+					b.processMessage(message)
+					event := b.clientEvent(EventMessageRelayed, message.Client)
+					event.Message = &message.Message
+					b.emit(event)
+				}
 				closeTimer.Reset(closePeriod)
 			} else {
 				b.log.Infoln("Messages channel closed, shutting down")
 				running = false
 			}
+		case metadata, open := <-b.metadataChan:
+			if running && open {
+				b.processMetadata(metadata)
+			} else {
+				b.log.Infoln("Metadata channel closed, shutting down")
+				running = false
+			}
+		case client, open := <-b.pingChan:
+			if running && open {
+				b.touch(client)
+			} else {
+				b.log.Infoln("Ping channel closed, shutting down")
+				running = false
+			}
 		case usersRequest, open := <-b.usersRequestChan:
 			if running && open {
 				b.processUsersRequest(usersRequest)
@@ -501,46 +1583,34 @@ func (b *Binder) loop() {
 			}
 		case kickRequest, open := <-b.kickChan:
 			if running && open {
-				b.log.Debugf("Received kick request for: %v\n", kickRequest.userID)
-
-				// TODO: Refactor and improve kick API
-				kicked := 0
-				for i, c := range b.clients {
-					if c.UserID == kickRequest.userID {
-						b.stats.Decr("binder.subscribed_clients", 1)
-						b.clients = append(b.clients[:i], b.clients[i+1:]...)
-						close(c.transformChan)
-						close(c.messageChan)
-						kicked++
-					}
-				}
-				if kicked > 0 {
-					close(kickRequest.result)
-				} else {
-					kickRequest.result <- fmt.Errorf("No such userID: %s", kickRequest.userID)
-				}
+				b.processKick(kickRequest)
 			} else {
-				b.log.Infoln("Exit channel closed, shutting down")
+				b.log.Infoln("Kick channel closed, shutting down")
 				running = false
-				close(kickRequest.result)
 			}
 		case client, open := <-b.exitChan:
 			if running && open {
 				b.log.Debugf("Received exit request for: %v\n", client.UserID)
-				for i, c := range b.clients {
+				for _, c := range b.clients {
 					if c == client {
-						b.stats.Decr("binder.subscribed_clients", 1)
-						b.clients = append(b.clients[:i], b.clients[i+1:]...)
-						close(c.transformChan)
-						close(c.messageChan)
+						b.removeClient(c, false)
+						break
 					}
 				}
 			} else {
 				b.log.Infoln("Exit channel closed, shutting down")
 				running = false
 			}
+		case <-b.watchChan:
+			// Flushing merges the modified content of the store.
+			b.storeModified = true
+			if _, err := b.flush(); err != nil {
+				b.log.Errorf("Flush error: %v, shutting down\n", err)
+				b.errorChan <- BinderError{ID: b.ID, Err: err}
+				running = false
+			}
 		case <-flushTimer.C:
-			if b.model.IsDirty() {
+			if b.needsFlush() {
 				if _, err := b.flush(); err != nil {
 					b.log.Errorf("Flush error: %v, shutting down\n", err)
 					b.errorChan <- BinderError{ID: b.ID, Err: err}
@@ -548,6 +1618,8 @@ func (b *Binder) loop() {
 				}
 			}
 			flushTimer.Reset(flushPeriod)
+		case now := <-idleChan:
+			b.reapIdleClients(now)
 		case <-closeTimer.C:
 			if 0 == len(b.clients) {
 				b.log.Infoln("Binder inactive, requesting shutdown")
@@ -559,21 +1631,36 @@ func (b *Binder) loop() {
 		if !running {
 			flushTimer.Stop()
 			closeTimer.Stop()
+			if b.stopWatch != nil {
+				b.stopWatch()
+			}
 
 			b.stats.Incr("binder.closing", 1)
 			b.log.Infoln("Closing, shutting down client channels")
 			oldClients := b.clients
 			b.clients = make([]*BinderClient, 0)
+			b.history = make(map[*BinderClient]*undoHistory)
+			b.cursors = make(map[*BinderClient]*Message)
+			drainPeriod := time.Duration(b.config.ClientKickPeriod) * time.Millisecond
 			for _, client := range oldClients {
-				close(client.transformChan)
-				close(client.messageChan)
+				client.queue.close(true, drainPeriod)
+				b.emit(b.clientEvent(EventClientLeft, client))
 			}
 			b.log.Infof("Attempting final flush of %v\n", b.ID)
-			if b.model.IsDirty() {
-				if _, err := b.flush(); err != nil {
+			var err error
+			if b.needsFlush() {
+				if _, err = b.flush(); err != nil {
 					b.errorChan <- BinderError{ID: b.ID, Err: err}
 				}
 			}
+			if b.wal != nil {
+				// The log is only needed when the final flush failed.
+				if walErr := b.wal.close(err == nil); walErr != nil {
+					b.log.Errorf("Failed to close write-ahead log: %v\n", walErr)
+				}
+			}
+			b.emit(b.clientEvent(EventBinderClosed, nil))
+			b.closeObservers()
 			close(b.closedChan)
 			return
 		}
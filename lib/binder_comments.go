@@ -0,0 +1,186 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/lib/util"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for comment threads of a document.
+var (
+	ErrCommentNotFound     = errors.New("comment thread was not found")
+	ErrCommentRange        = errors.New("comment range was outside of the document content")
+	ErrCommentEmpty        = errors.New("comment content was empty")
+	ErrCommentAction       = errors.New("comment action was not recognised")
+	ErrCommentsUnsupported = errors.New("the transform model of this document does not support comments")
+)
+
+/*
+processComment - Processes a clients request to add, reply to, resolve or list comment threads. Any
+changed thread is sent out to all other clients. Returns an error only if the binder failed to flush.
+*/
+func (b *Binder) processComment(request CommentSubmission) error {
+	if _, ok := b.model.(RopeModel); !ok {
+		b.stats.Incr("binder.process_comment.error", 1)
+		b.sendClientError(request.ErrorChan, ErrCommentsUnsupported)
+		return nil
+	}
+	if request.Action == CommentList {
+		b.sendComments(request.ResultChan, copyComments(b.comments))
+		return nil
+	}
+	if request.Client == nil || request.Client.access < auth.EditAccess {
+		b.stats.Incr("binder.process_comment.error", 1)
+		b.sendClientError(request.ErrorChan, ErrReadOnlyPortal)
+		return nil
+	}
+
+	var thread *store.CommentThread
+	comment := store.Comment{
+		Author:  request.Client.UserID,
+		Content: request.Content,
+		Created: time.Now().Unix(),
+	}
+
+	switch request.Action {
+	case CommentAdd:
+		// The range is checked against the latest content, which requires a flush.
+		if b.model.IsDirty() {
+			if _, err := b.flush(); err != nil {
+				b.sendClientError(request.ErrorChan, err)
+				return err
+			}
+		}
+		length := b.content.Length(b.config.ModelConfig.PositionUnit)
+		if request.Start < 0 || request.End < request.Start || request.End > length {
+			b.stats.Incr("binder.process_comment.error", 1)
+			b.sendClientError(request.ErrorChan, ErrCommentRange)
+			return nil
+		}
+		if len(request.Content) == 0 {
+			b.stats.Incr("binder.process_comment.error", 1)
+			b.sendClientError(request.ErrorChan, ErrCommentEmpty)
+			return nil
+		}
+		b.comments = append(b.comments, store.CommentThread{
+			ID:       util.GenerateStampedUUID(),
+			Start:    request.Start,
+			End:      request.End,
+			Comments: []store.Comment{comment},
+		})
+		thread = &b.comments[len(b.comments)-1]
+	case CommentReply, CommentResolve:
+		for i := range b.comments {
+			if b.comments[i].ID == request.ThreadID {
+				thread = &b.comments[i]
+				break
+			}
+		}
+		if thread == nil {
+			b.stats.Incr("binder.process_comment.error", 1)
+			b.sendClientError(request.ErrorChan, ErrCommentNotFound)
+			return nil
+		}
+		if request.Action == CommentResolve {
+			thread.Resolved = true
+		} else if len(request.Content) == 0 {
+			b.stats.Incr("binder.process_comment.error", 1)
+			b.sendClientError(request.ErrorChan, ErrCommentEmpty)
+			return nil
+		} else {
+			thread.Comments = append(thread.Comments, comment)
+		}
+	default:
+		b.stats.Incr("binder.process_comment.error", 1)
+		b.sendClientError(request.ErrorChan, ErrCommentAction)
+		return nil
+	}
+
+	b.metaChanged = true
+	b.stats.Incr("binder.process_comment.success", 1)
+
+	changed := copyComments([]store.CommentThread{*thread})
+	b.sendComments(request.ResultChan, changed)
+	b.processMessage(MessageSubmission{
+		Client:  request.Client,
+		Message: Message{Comment: &changed[0], Active: true},
+	})
+	return nil
+}
+
+/*
+sendComments - Sends comment threads to a channel, the channel should be non-blocking (buffered by at
+least one and kept empty). In the event where the channel is blocked a log entry is made.
+*/
+func (b *Binder) sendComments(resultChan chan<- []store.CommentThread, threads []store.CommentThread) {
+	select {
+	case resultChan <- threads:
+	default:
+		b.log.Errorln("Send client comments was blocked")
+		b.stats.Incr("binder.send_client_comments.blocked", 1)
+	}
+}
+
+/*
+shiftComments - Moves the anchors of the comment threads of the document through a transform.
+*/
+func (b *Binder) shiftComments(ot OTransform) {
+	if len(b.comments) == 0 || ot.JSONOp != nil {
+		return
+	}
+	for _, op := range transformOperations(&ot) {
+		insertLength := unitLength(b.config.ModelConfig.PositionUnit, op.Insert)
+		for i := range b.comments {
+			shiftRange(&b.comments[i].Start, &b.comments[i].End, &op, insertLength)
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+copyComments - Returns a deep copy of a list of comment threads.
+*/
+func copyComments(threads []store.CommentThread) []store.CommentThread {
+	if len(threads) == 0 {
+		return nil
+	}
+	copied := make([]store.CommentThread, len(threads))
+	for i, thread := range threads {
+		copied[i] = thread
+		copied[i].Comments = append([]store.Comment{}, thread.Comments...)
+	}
+	return copied
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
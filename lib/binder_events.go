@@ -0,0 +1,241 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeffail/util/metrics"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Types of binder events. Documents being created and binders being opened are announced by the
+// curator, and so are only observed by observers of the curator.
+const (
+	EventDocumentCreated  = "document_created"
+	EventBinderOpened     = "binder_opened"
+	EventClientJoined     = "client_joined"
+	EventClientLeft       = "client_left"
+	EventTransformApplied = "transform_applied"
+	EventMessageRelayed   = "message_relayed"
+	EventFlushSucceeded   = "flush_succeeded"
+	EventFlushFailed      = "flush_failed"
+	EventBinderClosed     = "binder_closed"
+)
+
+/*
+BinderEvent - Something that happened within a binder. The user and session IDs are set for events
+caused by a client, and are empty for transforms and messages that came from the binder itself, such
+as merged external changes. The user of a document created event is its creator. The version is the
+version of the document after the event, and is zero for the events of the curator. Timestamp is a
+unix timestamp, and Error is only set when a flush failed. A transform applied event carries the
+transform as it was sent to clients, and a message relayed event carries the message.
+*/
+type BinderEvent struct {
+	Type       string      `json:"type"`
+	DocumentID string      `json:"document_id"`
+	UserID     string      `json:"user_id,omitempty"`
+	SessionID  string      `json:"session_id,omitempty"`
+	Version    int         `json:"version"`
+	Timestamp  int64       `json:"timestamp"`
+	Error      string      `json:"error,omitempty"`
+	Transform  *OTransform `json:"transform,omitempty"`
+	Message    *Message    `json:"message,omitempty"`
+}
+
+/*
+BinderObserver - Implemented by types that wish to be notified of the events of binders, and
+registered with either a Curator, in order to observe all of its binders, or a single Binder. Events
+are delivered in order by a goroutine of each observer, so an observer may block without holding up
+the binder, although events are dropped when too many are waiting for an observer.
+*/
+type BinderObserver interface {
+	// OnBinderEvent - Called for each event of an observed binder.
+	OnBinderEvent(event BinderEvent)
+}
+
+/*
+BinderObserverFunc - A function that implements BinderObserver.
+*/
+type BinderObserverFunc func(event BinderEvent)
+
+/*
+OnBinderEvent - Calls the function with the event.
+*/
+func (f BinderObserverFunc) OnBinderEvent(event BinderEvent) {
+	f(event)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+eventQueue - A bounded queue of events waiting to be delivered to an observer by a goroutine of its
+own.
+*/
+type eventQueue struct {
+	observer BinderObserver
+	stats    metrics.Aggregator
+
+	mut    sync.RWMutex
+	closed bool
+	events chan BinderEvent
+	done   chan struct{}
+}
+
+/*
+newEventQueue - Creates an event queue and launches the goroutine that delivers its events.
+*/
+func newEventQueue(observer BinderObserver, size int, stats metrics.Aggregator) *eventQueue {
+	if size < 1 {
+		size = 1
+	}
+	q := &eventQueue{
+		observer: observer,
+		stats:    stats,
+		events:   make(chan BinderEvent, size),
+		done:     make(chan struct{}),
+	}
+	go q.loop()
+	return q
+}
+
+/*
+push - Adds an event to the queue without blocking, the event is dropped if the queue is full or
+closed.
+*/
+func (q *eventQueue) push(event BinderEvent) {
+	q.mut.RLock()
+	defer q.mut.RUnlock()
+
+	if q.closed {
+		return
+	}
+	select {
+	case q.events <- event:
+	default:
+		q.stats.Incr("binder.events.dropped", 1)
+	}
+}
+
+/*
+close - Closes the queue, the events remaining in the queue are still delivered. Blocks until the
+events are delivered or the timeout elapses.
+*/
+func (q *eventQueue) close(timeout time.Duration) {
+	q.mut.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+	q.mut.Unlock()
+
+	select {
+	case <-q.done:
+	case <-time.After(timeout):
+	}
+}
+
+/*
+loop - Delivers events to the observer until the queue is closed.
+*/
+func (q *eventQueue) loop() {
+	defer close(q.done)
+	for event := range q.events {
+		q.observer.OnBinderEvent(event)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AddObserver - Registers an observer of the events of this binder. Observers may be added at any time,
+and only receive events that happen after they were added.
+*/
+func (b *Binder) AddObserver(observer BinderObserver) {
+	b.addEventQueue(newEventQueue(observer, b.config.EventQueueSize, b.stats), true)
+}
+
+/*
+addEventQueue - Adds a queue of an observer to the binder, when owned is set the binder closes the
+queue when it closes, otherwise the queue is shared and closed by its owner.
+*/
+func (b *Binder) addEventQueue(queue *eventQueue, owned bool) {
+	b.observerMut.Lock()
+	defer b.observerMut.Unlock()
+
+	b.observers = append(b.observers, queue)
+	if owned {
+		b.ownedObservers = append(b.ownedObservers, queue)
+	}
+}
+
+/*
+emit - Sends an event to each observer of the binder without blocking.
+*/
+func (b *Binder) emit(event BinderEvent) {
+	b.observerMut.RLock()
+	defer b.observerMut.RUnlock()
+
+	if len(b.observers) == 0 {
+		return
+	}
+	event.DocumentID = b.ID
+	event.Timestamp = time.Now().Unix()
+	for _, q := range b.observers {
+		q.push(event)
+	}
+}
+
+/*
+clientEvent - Returns an event of the current version caused by a client, which may be nil.
+*/
+func (b *Binder) clientEvent(eventType string, client *BinderClient) BinderEvent {
+	event := BinderEvent{Type: eventType, Version: b.model.GetVersion()}
+	if client != nil {
+		event.UserID, event.SessionID = client.UserID, client.SessionID
+	}
+	return event
+}
+
+/*
+closeObservers - Closes the event queues owned by the binder, giving each the kick period to deliver
+its remaining events.
+*/
+func (b *Binder) closeObservers() {
+	b.observerMut.Lock()
+	owned := b.ownedObservers
+	b.observers, b.ownedObservers = nil, nil
+	b.observerMut.Unlock()
+
+	for _, q := range owned {
+		q.close(time.Duration(b.config.ClientKickPeriod) * time.Millisecond)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
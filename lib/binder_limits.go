@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// The reason given to clients kicked for exceeding their rate limits.
+const rateLimitKickReason = "exceeded rate limits"
+
+/*
+RateLimitConfig - A token bucket rate limit, which allows a client to submit a burst of submissions
+at once and then replenishes at the rate per second. The limit is disabled when the rate is zero.
+*/
+type RateLimitConfig struct {
+	Rate  float64 `json:"rate_per_s" yaml:"rate_per_s"`
+	Burst int     `json:"burst" yaml:"burst"`
+}
+
+/*
+DefaultRateLimitConfig - Returns a disabled rate limit configuration, which allows bursts of twenty
+submissions once a rate is set.
+*/
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Rate:  0,
+		Burst: 20,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+tokenBucket - Limits the rate of submissions of a client, a nil bucket has no limit.
+*/
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+/*
+newTokenBucket - Creates a full token bucket, or returns nil if the rate limit is disabled.
+*/
+func newTokenBucket(config RateLimitConfig) *tokenBucket {
+	if config.Rate <= 0 {
+		return nil
+	}
+	burst := float64(config.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   config.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+/*
+take - Takes a token from the bucket, returns false if there were none left.
+*/
+func (t *tokenBucket) take(now time.Time) bool {
+	if t == nil {
+		return true
+	}
+	if elapsed := now.Sub(t.last).Seconds(); elapsed > 0 {
+		t.tokens += elapsed * t.rate
+		if t.tokens > t.burst {
+			t.tokens = t.burst
+		}
+	}
+	t.last = now
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+limitTransform - Returns ErrRateLimited if a client has exceeded its rate of transforms. Transforms
+that come from the binder itself are never limited.
+*/
+func (b *Binder) limitTransform(client *BinderClient) error {
+	if client == nil || client.transformBucket.take(time.Now()) {
+		return nil
+	}
+	b.stats.Incr("binder.rate_limit.transform", 1)
+	b.offend(client)
+	return ErrRateLimited
+}
+
+/*
+limitMessage - Returns false if the client of a message has exceeded its rate of messages, in which
+case the message is dropped. Messages announcing that a client has left are never limited.
+*/
+func (b *Binder) limitMessage(request MessageSubmission) bool {
+	if request.Client == nil || !request.Message.Active ||
+		request.Client.messageBucket.take(time.Now()) {
+		return true
+	}
+	b.stats.Incr("binder.rate_limit.message", 1)
+	b.offend(request.Client)
+	return false
+}
+
+/*
+offend - Counts a submission of a client that exceeded its rate limit, and kicks the client when it
+reaches the configured number of offences. The kicked client is told the reason, and the remaining
+clients that it has left.
+*/
+func (b *Binder) offend(client *BinderClient) {
+	client.offences++
+	if b.config.RateLimitKickAfter <= 0 || client.offences != b.config.RateLimitKickAfter {
+		return
+	}
+	b.log.Infof("Kicking client for user: (%v) for exceeding rate limits\n", client.UserID)
+	b.stats.Incr("binder.rate_limit.kicked", 1)
+	b.stats.Incr("binder.clients_kicked", 1)
+	b.kickClient(client, rateLimitKickReason)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,272 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/lib/util"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for locked regions of a document.
+var (
+	ErrTransformLocked  = errors.New("transform modifies a locked region of the document")
+	ErrLockNotFound     = errors.New("lock was not found")
+	ErrLockRange        = errors.New("lock range was outside of the document content")
+	ErrLockAccess       = errors.New("user is not permitted to remove the lock")
+	ErrLockAction       = errors.New("lock action was not recognised")
+	ErrLocksUnsupported = errors.New("the transform model of this document does not support locks")
+)
+
+/*
+processLock - Processes a clients request to add, remove or list locks. A new lock belongs to the
+requesting client, and a lock can only be removed by clients permitted to edit within it. Any added
+or removed lock is sent out to all other clients. Returns an error only if the binder failed to
+flush.
+*/
+func (b *Binder) processLock(request LockSubmission) error {
+	if _, ok := b.model.(RopeModel); !ok {
+		b.stats.Incr("binder.process_lock.error", 1)
+		b.sendClientError(request.ErrorChan, ErrLocksUnsupported)
+		return nil
+	}
+	if request.Action == LockList {
+		b.sendLocks(request.ResultChan, copyLocks(b.locks))
+		return nil
+	}
+	if request.Client == nil || request.Client.access < auth.EditAccess {
+		b.stats.Incr("binder.process_lock.error", 1)
+		b.sendClientError(request.ErrorChan, ErrReadOnlyPortal)
+		return nil
+	}
+
+	var lock store.Lock
+	switch request.Action {
+	case LockAdd:
+		// The range is checked against the latest content, which requires a flush.
+		if b.model.IsDirty() {
+			if _, err := b.flush(); err != nil {
+				b.sendClientError(request.ErrorChan, err)
+				return err
+			}
+		}
+		length := b.content.Length(b.config.ModelConfig.PositionUnit)
+		if request.Start < 0 || request.End <= request.Start || request.End > length {
+			b.stats.Incr("binder.process_lock.error", 1)
+			b.sendClientError(request.ErrorChan, ErrLockRange)
+			return nil
+		}
+		lock = store.Lock{
+			ID:          util.GenerateStampedUUID(),
+			Start:       request.Start,
+			End:         request.End,
+			Owner:       request.Client.UserID,
+			AccessLevel: request.AccessLevel,
+		}
+		b.locks = append(b.locks, lock)
+	case LockRemove:
+		index := -1
+		for i := range b.locks {
+			if b.locks[i].ID == request.LockID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			b.stats.Incr("binder.process_lock.error", 1)
+			b.sendClientError(request.ErrorChan, ErrLockNotFound)
+			return nil
+		}
+		lock = b.locks[index]
+		if !canEditLock(request.Client, lock) {
+			b.stats.Incr("binder.process_lock.error", 1)
+			b.sendClientError(request.ErrorChan, ErrLockAccess)
+			return nil
+		}
+		b.locks = append(b.locks[:index], b.locks[index+1:]...)
+		lock.Released = true
+	default:
+		b.stats.Incr("binder.process_lock.error", 1)
+		b.sendClientError(request.ErrorChan, ErrLockAction)
+		return nil
+	}
+
+	b.metaChanged = true
+	b.stats.Incr("binder.process_lock.success", 1)
+
+	b.sendLocks(request.ResultChan, []store.Lock{lock})
+	b.processMessage(MessageSubmission{
+		Client:  request.Client,
+		Message: Message{Lock: &lock, Active: true},
+	})
+	return nil
+}
+
+/*
+sendLocks - Sends locks to a channel, the channel should be non-blocking (buffered by at least one
+and kept empty). In the event where the channel is blocked a log entry is made.
+*/
+func (b *Binder) sendLocks(resultChan chan<- []store.Lock, locks []store.Lock) {
+	select {
+	case resultChan <- locks:
+	default:
+		b.log.Errorln("Send client locks was blocked")
+		b.stats.Incr("binder.send_client_locks.blocked", 1)
+	}
+}
+
+/*
+checkLocks - Returns ErrTransformLocked if a transform, once fixed in relation to the current
+version of the document, modifies a locked region that the client is not permitted to edit.
+*/
+func (b *Binder) checkLocks(client *BinderClient, ot OTransform) error {
+	if len(b.locks) == 0 || ot.JSONOp != nil {
+		return nil
+	}
+	rebaseable, ok := b.model.(RebaseableModel)
+	if !ok {
+		return nil
+	}
+	rebased, err := rebaseable.RebaseTransform(ot)
+	if err != nil {
+		return err
+	}
+	// Operations are checked against the locks as they stand once the preceding operations apply.
+	locks := copyLocks(b.locks)
+	for _, op := range transformOperations(&rebased) {
+		for _, lock := range locks {
+			if lockTouched(lock, &op) && !canEditLock(client, lock) {
+				b.log.Debugf("Transform %v rejected by lock %v\n", op, lock.ID)
+				return ErrTransformLocked
+			}
+		}
+		locks = shiftLocks(locks, &op, b.config.ModelConfig.PositionUnit)
+	}
+	return nil
+}
+
+/*
+shiftTransformLocks - Moves the locked regions of the document through a transform, regions that are
+deleted entirely are removed.
+*/
+func (b *Binder) shiftTransformLocks(ot OTransform) {
+	if len(b.locks) == 0 || ot.JSONOp != nil {
+		return
+	}
+	for _, op := range transformOperations(&ot) {
+		b.locks = shiftLocks(b.locks, &op, b.config.ModelConfig.PositionUnit)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+lockTouched - Returns true if an edit deletes content within a lock, or inserts content strictly
+inside it. Content can be inserted at either end of a lock without touching it.
+*/
+func lockTouched(lock store.Lock, op *OTransform) bool {
+	if op.Delete > 0 {
+		return op.Position < lock.End && op.Position+op.Delete > lock.Start
+	}
+	return op.Position > lock.Start && op.Position < lock.End
+}
+
+/*
+canEditLock - Returns true if a client owns a lock or has its required access level.
+*/
+func canEditLock(client *BinderClient, lock store.Lock) bool {
+	if client == nil {
+		return false
+	}
+	if len(lock.Owner) > 0 && client.UserID == lock.Owner {
+		return true
+	}
+	return lock.AccessLevel > auth.NoAccess && client.access >= lock.AccessLevel
+}
+
+/*
+shiftRange - Moves a range of content through a single edit. Content inserted at either end of the
+range remains outside of it, whereas content replacing part of the range is brought within it. An
+empty range is moved as a single point, and content inserted at that point is placed before it.
+*/
+func shiftRange(rangeStart, rangeEnd *int, op *OTransform, insertLength int) {
+	start, end := op.Position, op.Position+op.Delete
+	shift := insertLength - op.Delete
+
+	if *rangeStart == *rangeEnd {
+		if *rangeStart >= end {
+			*rangeStart += shift
+		} else if *rangeStart > start {
+			*rangeStart = start
+		}
+		*rangeEnd = *rangeStart
+		return
+	}
+	if *rangeStart >= end {
+		*rangeStart += shift
+	} else if *rangeStart > start {
+		*rangeStart = start
+	}
+	if *rangeEnd >= end && *rangeEnd > start {
+		*rangeEnd += shift
+	} else if *rangeEnd > start {
+		*rangeEnd = start + insertLength
+	}
+}
+
+/*
+shiftLocks - Moves a list of locks through a single edit, the list is modified in place. Locks that
+are deleted entirely are removed.
+*/
+func shiftLocks(locks []store.Lock, op *OTransform, unit string) []store.Lock {
+	insertLength := unitLength(unit, op.Insert)
+
+	shifted := locks[:0]
+	for _, lock := range locks {
+		if op.Delete > 0 && lock.Start >= op.Position && lock.End <= op.Position+op.Delete {
+			continue
+		}
+		shiftRange(&lock.Start, &lock.End, op, insertLength)
+		shifted = append(shifted, lock)
+	}
+	return shifted
+}
+
+/*
+copyLocks - Returns a copy of a list of locks.
+*/
+func copyLocks(locks []store.Lock) []store.Lock {
+	if len(locks) == 0 {
+		return nil
+	}
+	return append([]store.Lock{}, locks...)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
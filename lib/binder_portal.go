@@ -26,6 +26,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/jeffail/leaps/lib/auth"
 	"github.com/jeffail/leaps/lib/store"
 )
 
@@ -35,23 +36,174 @@ import (
 /*
 TransformSubmission - A struct used to submit a transform to a binder. The submission must contain
 the client, as well as two channels for returning either the corrected version of the
-transform if successful, or an error if the submit was unsuccessful.
+transform if successful, or an error if the submit was unsuccessful. The checksum channel is optional
+and is sent the checksum of the document content once the transform is applied.
 */
 type TransformSubmission struct {
+	Client       *BinderClient
+	Transform    OTransform
+	VersionChan  chan<- int
+	ChecksumChan chan<- uint32
+	ErrorChan    chan<- error
+}
+
+/*
+ContentSubmission - A struct used to submit the full content of a document, which the binder turns
+into a transform by comparing it with the latest content. The client is optional. The submission
+must contain two channels for returning either the version of the resulting transform, or an error.
+*/
+type ContentSubmission struct {
+	Client      *BinderClient
+	Content     string
+	VersionChan chan<- int
+	ErrorChan   chan<- error
+}
+
+/*
+ResyncSubmission - A struct used to request the authoritative content of a document, for clients
+that have detected that their content has diverged. The submission must contain two channels for
+returning either the snapshot of the document, or an error.
+*/
+type ResyncSubmission struct {
+	Client       *BinderClient
+	SnapshotChan chan<- Snapshot
+	ErrorChan    chan<- error
+}
+
+/*
+Snapshot - The content of a document at a version, along with the checksum of the content.
+*/
+type Snapshot struct {
+	Document store.Document
+	Version  int
+	Checksum uint32
+}
+
+/*
+UndoSubmission - A struct used to request that a binder undoes (or redoes when Redo is set) the most
+recent transform of a client. The submission must contain the client, as well as two channels for
+returning either the version of the resulting transform, or an error.
+*/
+type UndoSubmission struct {
 	Client      *BinderClient
-	Transform   OTransform
+	Redo        bool
 	VersionChan chan<- int
 	ErrorChan   chan<- error
 }
 
+// Actions of a comment submission.
+const (
+	CommentAdd     = "add"
+	CommentReply   = "reply"
+	CommentResolve = "resolve"
+	CommentList    = "list"
+)
+
 /*
-Message - Can contain text content, a cursor position, or a boolean indicator as to whether this
-client is active (connected).
+CommentSubmission - A struct used to add a comment thread, reply to or resolve an existing thread, or
+list the comment threads of a binder. The Start and End of the range of a new thread are counted in
+the position unit of the transform model, and are relative to the latest version of the document.
+The submission must contain two channels for returning either the resulting comment threads, or an
+error.
+*/
+type CommentSubmission struct {
+	Client     *BinderClient
+	Action     string
+	ThreadID   string
+	Start      int
+	End        int
+	Content    string
+	ResultChan chan<- []store.CommentThread
+	ErrorChan  chan<- error
+}
+
+// Actions of a lock submission.
+const (
+	LockAdd    = "add"
+	LockRemove = "remove"
+	LockList   = "list"
+)
+
+/*
+LockSubmission - A struct used to lock a range of a document, to remove an existing lock, or to list
+the locks of a binder. The Start and End of the range of a new lock are counted in the position unit
+of the transform model, and are relative to the latest version of the document. The submission must
+contain two channels for returning either the resulting locks, or an error.
+*/
+type LockSubmission struct {
+	Client      *BinderClient
+	Action      string
+	LockID      string
+	Start       int
+	End         int
+	AccessLevel auth.AccessLevel
+	ResultChan  chan<- []store.Lock
+	ErrorChan   chan<- error
+}
+
+// Actions of a suggestion submission.
+const (
+	SuggestionPropose = "propose"
+	SuggestionAccept  = "accept"
+	SuggestionReject  = "reject"
+	SuggestionList    = "list"
+)
+
+/*
+SuggestionSubmission - A struct used to propose a transform as a suggestion rather than applying it,
+to accept or reject an existing suggestion, or to list the pending suggestions of a binder. The
+submission must contain two channels for returning either the resulting suggestions, or an error.
+*/
+type SuggestionSubmission struct {
+	Client       *BinderClient
+	Action       string
+	SuggestionID string
+	Transform    OTransform
+	ResultChan   chan<- []store.Suggestion
+	ErrorChan    chan<- error
+}
+
+/*
+Selection - A range of selected content, the anchor is where the selection began and the head is
+where it ends, which can be before the anchor.
+*/
+type Selection struct {
+	Anchor int64 `json:"anchor"`
+	Head   int64 `json:"head"`
+}
+
+/*
+Presence - The full set of cursors and selections of a client, for editors that support multiple
+cursors.
+*/
+type Presence struct {
+	Cursors    []int64     `json:"cursors,omitempty"`
+	Selections []Selection `json:"selections,omitempty"`
+}
+
+/*
+Message - Can contain text content, a cursor position, a selection, the presence of a client with
+multiple cursors, or a boolean indicator as to whether this client is active (connected). The binder
+keeps the last cursor position, selection and presence of each client and shifts them with each
+transform. Messages sent by the binder may also carry a lock, comment thread or suggestion that the
+client has changed, or, without a client, announce that the stored content was modified externally
+and the modification was merged into the document. When the modification could not be merged the
+notice carries the full content of the document, which replaces the content of every client. The
+final message sent to a kicked client is marked as kicked and carries the reason of the kick.
 */
 type Message struct {
-	Content  string `json:"content,omitempty"`
-	Position *int64 `json:"position,omitempty"`
-	Active   bool   `json:"active"`
+	Content        string               `json:"content,omitempty"`
+	Position       *int64               `json:"position,omitempty"`
+	Selection      *Selection           `json:"selection,omitempty"`
+	Presence       *Presence            `json:"presence,omitempty"`
+	Lock           *store.Lock          `json:"lock,omitempty"`
+	Comment        *store.CommentThread `json:"comment,omitempty"`
+	Suggestion     *store.Suggestion    `json:"suggestion,omitempty"`
+	ExternalChange bool                 `json:"external_change,omitempty"`
+	Resync         *Snapshot            `json:"resync,omitempty"`
+	Kicked         bool                 `json:"kicked,omitempty"`
+	Reason         string               `json:"reason,omitempty"`
+	Active         bool                 `json:"active"`
 }
 
 /*
@@ -59,17 +211,46 @@ MessageSubmission - A struct used to submit a message to a binder. The submissio
 token of the client in order to avoid the message being sent back to the same client.
 */
 type MessageSubmission struct {
-	Client  *BinderClient `json:"client"`
-	Message Message       `json:"message"`
+	Client  *BinderClient  `json:"client"`
+	Message Message        `json:"message"`
+	Profile *ClientProfile `json:"profile,omitempty"`
+}
+
+/*
+ClientProfile - The display name and colour supplied by a client, which are attached by the binder
+to each message of the client sent out to other clients.
+*/
+type ClientProfile struct {
+	DisplayName string `json:"display_name,omitempty"`
+	Colour      string `json:"colour,omitempty"`
+}
+
+/*
+ClientMetadata - Describes a client to other clients and administrators. The remote address is set by
+the server the client connected through and is only visible to administrators, the profile is
+supplied by the client.
+*/
+type ClientMetadata struct {
+	RemoteAddress string `json:"remote_address,omitempty"`
+	ClientProfile
+}
+
+/*
+MetadataSubmission - A struct used to set the metadata of a client.
+*/
+type MetadataSubmission struct {
+	Client   *BinderClient
+	Metadata ClientMetadata
 }
 
 /*
 BinderSubscribeBundle - A container that holds all data necessary to provide a binder that you
-wish to subscribe to. Contains a user userID for identifying the client and a channel for
-receiving the resultant BinderPortal.
+wish to subscribe to. Contains a user userID for identifying the client, the access level of the
+client and a channel for receiving the resultant BinderPortal.
 */
 type BinderSubscribeBundle struct {
 	UserID        string
+	Access        auth.AccessLevel
 	PortalRcvChan chan<- BinderPortal
 }
 
@@ -87,18 +268,29 @@ var (
 /*
 BinderPortal - A container that holds all data necessary to begin an open portal with the binder,
 allowing fresh transforms to be submitted and returned as they come. Also carries the BinderClient
-of the client.
+of the client, the current cursors of the other clients of the binder, and the unit in which the
+positions of transforms are counted.
 */
 type BinderPortal struct {
-	Client           *BinderClient
-	Document         store.Document
-	Version          int
-	Error            error
-	TransformRcvChan <-chan OTransform
-	MessageRcvChan   <-chan MessageSubmission
-	TransformSndChan chan<- TransformSubmission
-	MessageSndChan   chan<- MessageSubmission
-	ExitChan         chan<- *BinderClient
+	Client            *BinderClient
+	Document          store.Document
+	Version           int
+	PositionUnit      string
+	Cursors           []MessageSubmission
+	Error             error
+	TransformRcvChan  <-chan OTransform
+	MessageRcvChan    <-chan MessageSubmission
+	TransformSndChan  chan<- TransformSubmission
+	UndoSndChan       chan<- UndoSubmission
+	LockSndChan       chan<- LockSubmission
+	CommentSndChan    chan<- CommentSubmission
+	SuggestionSndChan chan<- SuggestionSubmission
+	ResyncSndChan     chan<- ResyncSubmission
+	ContentSndChan    chan<- ContentSubmission
+	MessageSndChan    chan<- MessageSubmission
+	MetadataSndChan   chan<- MetadataSubmission
+	PingSndChan       chan<- *BinderClient
+	ExitChan          chan<- *BinderClient
 }
 
 /*
@@ -106,16 +298,141 @@ SendTransform - Submits a transform to the binder. The binder responds with eith
 corrected version number for the transform. This is safe to call from any goroutine.
 */
 func (p *BinderPortal) SendTransform(ot OTransform, timeout time.Duration) (int, error) {
+	ver, _, err := p.SendTransformChecked(ot, timeout)
+	return ver, err
+}
+
+/*
+SendTransformChecked - Submits a transform to the binder in the same way as SendTransform, and also
+returns the checksum of the document content once the transform is applied. The checksum is zero
+when the transform model of the document does not support checksums.
+*/
+func (p *BinderPortal) SendTransformChecked(ot OTransform, timeout time.Duration) (int, uint32, error) {
 	// Check if we are READ ONLY
 	if nil == p.TransformSndChan {
-		return 0, ErrReadOnlyPortal
+		return 0, 0, ErrReadOnlyPortal
 	}
 	// Buffered channels because the server skips blocked sends
 	errChan := make(chan error, 1)
 	verChan := make(chan int, 1)
+	sumChan := make(chan uint32, 1)
 	p.TransformSndChan <- TransformSubmission{
+		Client:       p.Client,
+		Transform:    ot,
+		VersionChan:  verChan,
+		ChecksumChan: sumChan,
+		ErrorChan:    errChan,
+	}
+	select {
+	case err := <-errChan:
+		return 0, 0, err
+	case ver := <-verChan:
+		// The checksum is always sent before the version.
+		select {
+		case sum := <-sumChan:
+			return ver, sum, nil
+		default:
+		}
+		return ver, 0, nil
+	case <-time.After(timeout):
+	}
+	return 0, 0, ErrTimeout
+}
+
+/*
+SendContent - Submits the full content of the document, which the binder compares with the latest
+content in order to submit the difference as a transform. The transform is sent to all clients,
+including this one, through TransformRcvChan. Returns the version of the transform, or the current
+version if the content was unchanged. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) SendContent(content string, timeout time.Duration) (int, error) {
+	// Check if we are READ ONLY
+	if nil == p.ContentSndChan {
+		return 0, ErrReadOnlyPortal
+	}
+	// Buffered channels because the server skips blocked sends
+	errChan := make(chan error, 1)
+	verChan := make(chan int, 1)
+
+	timer := time.After(timeout)
+	select {
+	case p.ContentSndChan <- ContentSubmission{
+		Client:      p.Client,
+		Content:     content,
+		VersionChan: verChan,
+		ErrorChan:   errChan,
+	}:
+	case <-timer:
+		return 0, ErrTimeout
+	}
+	select {
+	case err := <-errChan:
+		return 0, err
+	case ver := <-verChan:
+		return ver, nil
+	case <-timer:
+	}
+	return 0, ErrTimeout
+}
+
+/*
+Resync - Requests the authoritative content and version of the document, along with its checksum.
+Transforms that were already sent to this portal may still arrive after the snapshot, and any with a
+version up to that of the snapshot should be ignored. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) Resync(timeout time.Duration) (Snapshot, error) {
+	// Buffered channels because the server skips blocked sends
+	errChan := make(chan error, 1)
+	snapChan := make(chan Snapshot, 1)
+
+	select {
+	case p.ResyncSndChan <- ResyncSubmission{
+		Client:       p.Client,
+		SnapshotChan: snapChan,
+		ErrorChan:    errChan,
+	}:
+	case <-time.After(timeout):
+		return Snapshot{}, ErrTimeout
+	}
+	select {
+	case err := <-errChan:
+		return Snapshot{}, err
+	case snapshot := <-snapChan:
+		return snapshot, nil
+	case <-time.After(timeout):
+	}
+	return Snapshot{}, ErrTimeout
+}
+
+/*
+Undo - Requests that the binder reverts the most recent transform submitted through this portal that
+has not already been undone. The reverting transform is rebased over any later transforms and is
+then sent to all clients, including this one, through TransformRcvChan. Returns the version of the
+reverting transform. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) Undo(timeout time.Duration) (int, error) {
+	return p.sendUndo(false, timeout)
+}
+
+/*
+Redo - Requests that the binder reapplies the most recently undone transform of this portal. Works
+the same way as Undo.
+*/
+func (p *BinderPortal) Redo(timeout time.Duration) (int, error) {
+	return p.sendUndo(true, timeout)
+}
+
+func (p *BinderPortal) sendUndo(redo bool, timeout time.Duration) (int, error) {
+	// Check if we are READ ONLY
+	if nil == p.UndoSndChan {
+		return 0, ErrReadOnlyPortal
+	}
+	// Buffered channels because the server skips blocked sends
+	errChan := make(chan error, 1)
+	verChan := make(chan int, 1)
+	p.UndoSndChan <- UndoSubmission{
 		Client:      p.Client,
-		Transform:   ot,
+		Redo:        redo,
 		VersionChan: verChan,
 		ErrorChan:   errChan,
 	}
@@ -130,9 +447,245 @@ func (p *BinderPortal) SendTransform(ot OTransform, timeout time.Duration) (int,
 }
 
 /*
-SendMessage - Sends a message to the binder, which is subsequently sent out to all other clients.
+AddLock - Locks a range of the document, so that only the client, or users with at least the given
+access level, may edit content within it. The lock is sent to all other clients as a message. This
+is safe to call from any goroutine.
+*/
+func (p *BinderPortal) AddLock(
+	start, end int, access auth.AccessLevel, timeout time.Duration,
+) (store.Lock, error) {
+	return p.sendLockChange(LockSubmission{
+		Action:      LockAdd,
+		Start:       start,
+		End:         end,
+		AccessLevel: access,
+	}, timeout)
+}
+
+/*
+RemoveLock - Removes a lock that the client is permitted to edit, the released lock is sent to all
+other clients as a message. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) RemoveLock(lockID string, timeout time.Duration) (store.Lock, error) {
+	return p.sendLockChange(LockSubmission{
+		Action: LockRemove,
+		LockID: lockID,
+	}, timeout)
+}
+
+/*
+ListLocks - Returns all locks of the document, with ranges relative to the latest version. This is
+safe to call from any goroutine.
+*/
+func (p *BinderPortal) ListLocks(timeout time.Duration) ([]store.Lock, error) {
+	return p.sendLock(LockSubmission{Action: LockList}, timeout)
+}
+
+func (p *BinderPortal) sendLockChange(submission LockSubmission, timeout time.Duration) (store.Lock, error) {
+	locks, err := p.sendLock(submission, timeout)
+	if err != nil {
+		return store.Lock{}, err
+	}
+	if len(locks) != 1 {
+		return store.Lock{}, ErrLockNotFound
+	}
+	return locks[0], nil
+}
+
+func (p *BinderPortal) sendLock(submission LockSubmission, timeout time.Duration) ([]store.Lock, error) {
+	// Buffered channels because the server skips blocked sends
+	errChan := make(chan error, 1)
+	resChan := make(chan []store.Lock, 1)
+
+	submission.Client = p.Client
+	submission.ResultChan = resChan
+	submission.ErrorChan = errChan
+
+	select {
+	case p.LockSndChan <- submission:
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	}
+	select {
+	case err := <-errChan:
+		return nil, err
+	case locks := <-resChan:
+		return locks, nil
+	case <-time.After(timeout):
+	}
+	return nil, ErrTimeout
+}
+
+/*
+AddComment - Creates a comment thread anchored to a range of the document, which is sent to all other
+clients as a message. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) AddComment(start, end int, content string, timeout time.Duration) (store.CommentThread, error) {
+	return p.sendCommentChange(CommentSubmission{
+		Action:  CommentAdd,
+		Start:   start,
+		End:     end,
+		Content: content,
+	}, timeout)
+}
+
+/*
+ReplyComment - Adds a comment to an existing comment thread, which is sent to all other clients as a
+message. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) ReplyComment(threadID, content string, timeout time.Duration) (store.CommentThread, error) {
+	return p.sendCommentChange(CommentSubmission{
+		Action:   CommentReply,
+		ThreadID: threadID,
+		Content:  content,
+	}, timeout)
+}
+
+/*
+ResolveComment - Marks a comment thread as resolved, which is sent to all other clients as a message.
 This is safe to call from any goroutine.
 */
+func (p *BinderPortal) ResolveComment(threadID string, timeout time.Duration) (store.CommentThread, error) {
+	return p.sendCommentChange(CommentSubmission{
+		Action:   CommentResolve,
+		ThreadID: threadID,
+	}, timeout)
+}
+
+/*
+ListComments - Returns all comment threads of the document, with anchors relative to the latest
+version. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) ListComments(timeout time.Duration) ([]store.CommentThread, error) {
+	return p.sendComment(CommentSubmission{Action: CommentList}, timeout)
+}
+
+func (p *BinderPortal) sendCommentChange(
+	submission CommentSubmission, timeout time.Duration,
+) (store.CommentThread, error) {
+	threads, err := p.sendComment(submission, timeout)
+	if err != nil {
+		return store.CommentThread{}, err
+	}
+	if len(threads) != 1 {
+		return store.CommentThread{}, ErrCommentNotFound
+	}
+	return threads[0], nil
+}
+
+func (p *BinderPortal) sendComment(
+	submission CommentSubmission, timeout time.Duration,
+) ([]store.CommentThread, error) {
+	// Buffered channels because the server skips blocked sends
+	errChan := make(chan error, 1)
+	resChan := make(chan []store.CommentThread, 1)
+
+	submission.Client = p.Client
+	submission.ResultChan = resChan
+	submission.ErrorChan = errChan
+
+	select {
+	case p.CommentSndChan <- submission:
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	}
+	select {
+	case err := <-errChan:
+		return nil, err
+	case threads := <-resChan:
+		return threads, nil
+	case <-time.After(timeout):
+	}
+	return nil, ErrTimeout
+}
+
+/*
+Suggest - Submits a transform as a suggestion, which is recorded against the document without being
+applied and is sent to all other clients as a message. The transform is corrected in the same way as
+a submitted transform. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) Suggest(ot OTransform, timeout time.Duration) (store.Suggestion, error) {
+	return p.sendSuggestionChange(SuggestionSubmission{
+		Action:    SuggestionPropose,
+		Transform: ot,
+	}, timeout)
+}
+
+/*
+AcceptSuggestion - Applies a pending suggestion as a transform, which is sent to all clients
+including this one through TransformRcvChan. Requires the review access level of the binder. This is
+safe to call from any goroutine.
+*/
+func (p *BinderPortal) AcceptSuggestion(id string, timeout time.Duration) (store.Suggestion, error) {
+	return p.sendSuggestionChange(SuggestionSubmission{
+		Action:       SuggestionAccept,
+		SuggestionID: id,
+	}, timeout)
+}
+
+/*
+RejectSuggestion - Discards a pending suggestion. Requires the review access level of the binder
+unless the suggestion was made by the same user. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) RejectSuggestion(id string, timeout time.Duration) (store.Suggestion, error) {
+	return p.sendSuggestionChange(SuggestionSubmission{
+		Action:       SuggestionReject,
+		SuggestionID: id,
+	}, timeout)
+}
+
+/*
+ListSuggestions - Returns all pending suggestions of the document, with edits relative to the latest
+version. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) ListSuggestions(timeout time.Duration) ([]store.Suggestion, error) {
+	return p.sendSuggestion(SuggestionSubmission{Action: SuggestionList}, timeout)
+}
+
+func (p *BinderPortal) sendSuggestionChange(
+	submission SuggestionSubmission, timeout time.Duration,
+) (store.Suggestion, error) {
+	suggestions, err := p.sendSuggestion(submission, timeout)
+	if err != nil {
+		return store.Suggestion{}, err
+	}
+	if len(suggestions) != 1 {
+		return store.Suggestion{}, ErrSuggestionNotFound
+	}
+	return suggestions[0], nil
+}
+
+func (p *BinderPortal) sendSuggestion(
+	submission SuggestionSubmission, timeout time.Duration,
+) ([]store.Suggestion, error) {
+	// Buffered channels because the server skips blocked sends
+	errChan := make(chan error, 1)
+	resChan := make(chan []store.Suggestion, 1)
+
+	submission.Client = p.Client
+	submission.ResultChan = resChan
+	submission.ErrorChan = errChan
+
+	select {
+	case p.SuggestionSndChan <- submission:
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	}
+	select {
+	case err := <-errChan:
+		return nil, err
+	case suggestions := <-resChan:
+		return suggestions, nil
+	case <-time.After(timeout):
+	}
+	return nil, ErrTimeout
+}
+
+/*
+SendMessage - Sends a message to the binder, which is subsequently sent out to all other clients.
+Messages beyond the rate limit of messages of the client are dropped without notice, although they
+count towards the client being kicked. This is safe to call from any goroutine.
+*/
 func (p *BinderPortal) SendMessage(message Message) {
 	p.MessageSndChan <- MessageSubmission{
 		Client:  p.Client,
@@ -140,6 +693,35 @@ func (p *BinderPortal) SendMessage(message Message) {
 	}
 }
 
+/*
+SetMetadata - Sets the metadata of this client, which is shown in the records of the clients of the
+binder. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) SetMetadata(metadata ClientMetadata, timeout time.Duration) error {
+	select {
+	case p.MetadataSndChan <- MetadataSubmission{
+		Client:   p.Client,
+		Metadata: metadata,
+	}:
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+	return nil
+}
+
+/*
+Ping - Informs the binder that this client is still active, which prevents it from being evicted as
+idle. This is safe to call from any goroutine.
+*/
+func (p *BinderPortal) Ping(timeout time.Duration) error {
+	select {
+	case p.PingSndChan <- p.Client:
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+	return nil
+}
+
 /*
 Exit - Inform the binder that this client is shutting down.
 */
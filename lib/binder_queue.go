@@ -0,0 +1,317 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeffail/util/metrics"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Overflow policies of the outbound queues of clients.
+const (
+	// OverflowKick - Kick a client when its queue is full.
+	OverflowKick = "kick"
+
+	// OverflowDropPresence - Drop the oldest queued presence update of a client when its queue is
+	// full, clients are kicked when there are none left to drop.
+	OverflowDropPresence = "drop_presence"
+
+	// OverflowCoalesce - Coalesce adjacent queued transforms of a client into single transforms, and
+	// drop queued presence updates that are superseded by later updates from the same client, when
+	// its queue is full. Clients are kicked when there is nothing left to coalesce.
+	OverflowCoalesce = "coalesce"
+)
+
+/*
+queuedItem - An item queued for sending to a client, which is either a transform, a message, or a
+barrier that is never sent and prevents transforms either side of it from being coalesced.
+*/
+type queuedItem struct {
+	transform *OTransform
+	message   *MessageSubmission
+}
+
+/*
+isPresence - Returns true if the item is a message that only updates the cursors of a client, which
+is a message of an active client that carries nothing other than a cursor position, selection or
+presence. Any other field, such as a lock, makes the message one that must not be dropped.
+*/
+func (i queuedItem) isPresence() bool {
+	if i.message == nil {
+		return false
+	}
+	cursor := i.message.Message
+	cursor.Position, cursor.Selection, cursor.Presence = nil, nil, nil
+	return cursor == Message{Active: true}
+}
+
+/*
+clientQueue - A bounded queue of transforms and messages waiting to be sent to a client. The binder
+pushes to the queue without blocking, and the queue is drained into the channels of the client by a
+goroutine of its own, so that a slow client cannot hold up the binder or the other clients.
+*/
+type clientQueue struct {
+	size   int
+	policy string
+	unit   string
+	stats  metrics.Aggregator
+
+	mut      sync.Mutex
+	items    []queuedItem
+	closed   bool
+	drain    bool
+	signal   chan struct{}
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+/*
+newClientQueue - Creates a queue for a client with the size and overflow policy of a binder config.
+*/
+func newClientQueue(config BinderConfig, stats metrics.Aggregator) *clientQueue {
+	size := config.ClientQueueSize
+	if size <= 0 {
+		size = 1
+	}
+	return &clientQueue{
+		size:     size,
+		policy:   config.ClientOverflowPolicy,
+		unit:     config.ModelConfig.PositionUnit,
+		stats:    stats,
+		signal:   make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+	}
+}
+
+/*
+pushTransform - Queues a transform, returns false if the queue overflowed and the client should be
+kicked.
+*/
+func (q *clientQueue) pushTransform(ot OTransform) bool {
+	return q.push(queuedItem{transform: &ot})
+}
+
+/*
+pushMessage - Queues a message, returns false if the queue overflowed and the client should be
+kicked.
+*/
+func (q *clientQueue) pushMessage(msg MessageSubmission) bool {
+	return q.push(queuedItem{message: &msg})
+}
+
+/*
+pushBarrier - Prevents the transforms currently queued from being coalesced with those queued after
+them, this is used when a client is sent a snapshot of the document at the current version.
+*/
+func (q *clientQueue) pushBarrier() {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if !q.closed && len(q.items) > 0 {
+		q.items = append(q.items, queuedItem{})
+	}
+}
+
+/*
+push - Queues an item and applies the overflow policy when the queue is full.
+*/
+func (q *clientQueue) push(item queuedItem) bool {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if q.closed {
+		return true
+	}
+	q.items = append(q.items, item)
+	if len(q.items) > q.size {
+		switch q.policy {
+		case OverflowDropPresence:
+			q.dropPresence()
+		case OverflowCoalesce:
+			q.coalesce()
+		}
+		if len(q.items) > q.size {
+			q.stats.Incr("binder.client_queue.overflow", 1)
+			return false
+		}
+	}
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+/*
+dropPresence - Removes the oldest queued presence update.
+*/
+func (q *clientQueue) dropPresence() {
+	for i, item := range q.items {
+		if item.isPresence() {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			q.stats.Incr("binder.client_queue.dropped_presence", 1)
+			return
+		}
+	}
+}
+
+/*
+coalesce - Removes queued presence updates that are superseded by a later update from the same client,
+then composes each run of adjacent queued text transforms into a single transform, and removes
+barriers that are no longer between transforms.
+*/
+func (q *clientQueue) coalesce() {
+	superseded := map[int]bool{}
+	latest := map[*BinderClient]bool{}
+	for i := len(q.items) - 1; i >= 0; i-- {
+		if item := q.items[i]; item.isPresence() {
+			if latest[item.message.Client] {
+				superseded[i] = true
+				q.stats.Incr("binder.client_queue.dropped_presence", 1)
+			}
+			latest[item.message.Client] = true
+		}
+	}
+
+	items := make([]queuedItem, 0, len(q.items))
+	for i, item := range q.items {
+		if superseded[i] {
+			continue
+		}
+		if item.transform == nil && item.message == nil {
+			if n := len(items); n == 0 || items[n-1].transform == nil {
+				continue
+			}
+		}
+		if item.transform != nil && item.transform.JSONOp == nil && len(items) > 0 {
+			if last := items[len(items)-1].transform; last != nil && last.JSONOp == nil {
+				composed := composeTransforms(*last, *item.transform, q.unit)
+				items[len(items)-1].transform = &composed
+				q.stats.Incr("binder.client_queue.coalesced", 1)
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	q.items = items
+}
+
+/*
+pop - Removes the next item to send from the queue, returns false if there are none, in which case
+the queue may have been closed.
+*/
+func (q *clientQueue) pop() (queuedItem, bool, bool) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if q.closed && !q.drain {
+		return queuedItem{}, false, true
+	}
+	for len(q.items) > 0 {
+		item := q.items[0]
+		q.items = q.items[1:]
+		if item.transform != nil || item.message != nil {
+			return item, true, q.closed
+		}
+	}
+	return queuedItem{}, false, q.closed
+}
+
+/*
+close - Closes the queue, when drain is set the items still queued are sent to the client unless it
+fails to receive them within a timeout, otherwise they are discarded.
+*/
+func (q *clientQueue) close(drain bool, timeout time.Duration) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed, q.drain = true, drain
+	if drain {
+		time.AfterFunc(timeout, q.stop)
+	} else {
+		q.items = nil
+		q.stop()
+	}
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+/*
+stop - Aborts any send to the client that is in progress.
+*/
+func (q *clientQueue) stop() {
+	q.stopOnce.Do(func() {
+		close(q.stopChan)
+	})
+}
+
+/*
+pump - Sends queued items to the channels of a client until the queue is closed, at which point the
+channels are closed. This is run by a goroutine for each client.
+*/
+func (q *clientQueue) pump(transformChan chan<- OTransform, messageChan chan<- MessageSubmission) {
+	defer close(messageChan)
+	defer close(transformChan)
+
+	for {
+		item, ok, closed := q.pop()
+		if !ok {
+			if closed {
+				return
+			}
+			select {
+			case <-q.signal:
+			case <-q.stopChan:
+				return
+			}
+			continue
+		}
+		if item.transform != nil {
+			select {
+			case transformChan <- *item.transform:
+			case <-q.stopChan:
+				return
+			}
+		} else {
+			select {
+			case messageChan <- *item.message:
+				q.stats.Incr("binder.sent_message", 1)
+			case <-q.stopChan:
+				return
+			}
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,236 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/lib/util"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for suggestions of a document.
+var (
+	ErrSuggestionNotFound     = errors.New("suggestion was not found")
+	ErrSuggestionRange        = errors.New("suggestion edits were outside of the document content")
+	ErrSuggestionAccess       = errors.New("user does not have the access level to review suggestions")
+	ErrSuggestionAction       = errors.New("suggestion action was not recognised")
+	ErrSuggestionsUnsupported = errors.New("the transform model of this document does not support suggestions")
+)
+
+/*
+processSuggestion - Processes a clients request to propose, accept, reject or list suggestions. A
+proposed transform is rebased to the latest version and recorded without being applied, an accepted
+suggestion is pushed to the model and broadcast to all clients including the requesting client. Any
+suggestion that is proposed, accepted or rejected is sent out to all other clients as a message.
+Returns an error only if the binder failed to flush.
+*/
+func (b *Binder) processSuggestion(request SuggestionSubmission) error {
+	rebaseable, ok := b.model.(RebaseableModel)
+	if _, isRope := b.model.(RopeModel); !ok || !isRope {
+		b.stats.Incr("binder.process_suggestion.error", 1)
+		b.sendClientError(request.ErrorChan, ErrSuggestionsUnsupported)
+		return nil
+	}
+
+	var suggestion store.Suggestion
+	switch request.Action {
+	case SuggestionList:
+		b.sendSuggestions(request.ResultChan, copySuggestions(b.suggestions))
+		return nil
+	case SuggestionPropose:
+		if request.Client == nil || request.Client.access < auth.ReadAccess {
+			b.stats.Incr("binder.process_suggestion.error", 1)
+			b.sendClientError(request.ErrorChan, ErrReadOnlyPortal)
+			return nil
+		}
+		rebased, err := rebaseable.RebaseTransform(request.Transform)
+		if err != nil {
+			b.stats.Incr("binder.process_suggestion.error", 1)
+			b.sendClientError(request.ErrorChan, err)
+			return nil
+		}
+		// The edits are checked against the latest content, which requires a flush.
+		if b.model.IsDirty() {
+			if _, err = b.flush(); err != nil {
+				b.sendClientError(request.ErrorChan, err)
+				return err
+			}
+		}
+		length := b.content.Length(b.config.ModelConfig.PositionUnit)
+
+		suggestion = store.Suggestion{
+			ID:      util.GenerateStampedUUID(),
+			Author:  request.Client.UserID,
+			Created: time.Now().Unix(),
+		}
+		for _, op := range transformOperations(&rebased) {
+			if op.Position < 0 || op.Delete < 0 || op.Position+op.Delete > length {
+				b.stats.Incr("binder.process_suggestion.error", 1)
+				b.sendClientError(request.ErrorChan, ErrSuggestionRange)
+				return nil
+			}
+			suggestion.Edits = append(suggestion.Edits, store.SuggestionEdit{
+				Start:  op.Position,
+				End:    op.Position + op.Delete,
+				Insert: op.Insert,
+			})
+		}
+		b.suggestions = append(b.suggestions, suggestion)
+	case SuggestionAccept, SuggestionReject:
+		index := -1
+		for i := range b.suggestions {
+			if b.suggestions[i].ID == request.SuggestionID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			b.stats.Incr("binder.process_suggestion.error", 1)
+			b.sendClientError(request.ErrorChan, ErrSuggestionNotFound)
+			return nil
+		}
+		suggestion = b.suggestions[index]
+
+		// Users may always withdraw their own suggestions.
+		withdrawn := request.Action == SuggestionReject &&
+			request.Client != nil && request.Client.UserID == suggestion.Author
+		if !withdrawn && (request.Client == nil || request.Client.access < b.config.ReviewAccess) {
+			b.stats.Incr("binder.process_suggestion.error", 1)
+			b.sendClientError(request.ErrorChan, ErrSuggestionAccess)
+			return nil
+		}
+
+		if request.Action == SuggestionAccept {
+			tform := suggestionTransform(suggestion, b.model.GetVersion()+1)
+			if err := b.checkLocks(request.Client, tform); err != nil {
+				b.stats.Incr("binder.process_suggestion.locked", 1)
+				b.sendClientError(request.ErrorChan, err)
+				return nil
+			}
+			dispatch, _, err := b.model.PushTransform(tform)
+			if err != nil {
+				b.stats.Incr("binder.process_suggestion.error", 1)
+				b.sendClientError(request.ErrorChan, err)
+				return nil
+			}
+			b.suggestions = append(b.suggestions[:index], b.suggestions[index+1:]...)
+			b.trackTransform(request.Client, &dispatch)
+
+			// The requesting client has not applied the transform locally, so it must also receive it.
+			b.broadcastTransform(dispatch, nil)
+			b.shiftCursors(dispatch)
+			suggestion.Status = store.SuggestionAccepted
+		} else {
+			b.suggestions = append(b.suggestions[:index], b.suggestions[index+1:]...)
+			suggestion.Status = store.SuggestionRejected
+		}
+	default:
+		b.stats.Incr("binder.process_suggestion.error", 1)
+		b.sendClientError(request.ErrorChan, ErrSuggestionAction)
+		return nil
+	}
+
+	b.metaChanged = true
+	b.stats.Incr("binder.process_suggestion.success", 1)
+
+	changed := copySuggestions([]store.Suggestion{suggestion})
+	b.sendSuggestions(request.ResultChan, changed)
+	b.processMessage(MessageSubmission{
+		Client:  request.Client,
+		Message: Message{Suggestion: &changed[0], Active: true},
+	})
+	return nil
+}
+
+/*
+sendSuggestions - Sends suggestions to a channel, the channel should be non-blocking (buffered by at
+least one and kept empty). In the event where the channel is blocked a log entry is made.
+*/
+func (b *Binder) sendSuggestions(resultChan chan<- []store.Suggestion, suggestions []store.Suggestion) {
+	select {
+	case resultChan <- suggestions:
+	default:
+		b.log.Errorln("Send client suggestions was blocked")
+		b.stats.Incr("binder.send_client_suggestions.blocked", 1)
+	}
+}
+
+/*
+shiftSuggestions - Moves the edits of pending suggestions through a transform.
+*/
+func (b *Binder) shiftSuggestions(ot OTransform) {
+	if len(b.suggestions) == 0 || ot.JSONOp != nil {
+		return
+	}
+	for _, op := range transformOperations(&ot) {
+		insertLength := unitLength(b.config.ModelConfig.PositionUnit, op.Insert)
+		for i := range b.suggestions {
+			for j := range b.suggestions[i].Edits {
+				edit := &b.suggestions[i].Edits[j]
+				shiftRange(&edit.Start, &edit.End, &op, insertLength)
+			}
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+suggestionTransform - Returns the transform of a suggestion for a particular version.
+*/
+func suggestionTransform(suggestion store.Suggestion, version int) OTransform {
+	ops := make([]OTransform, len(suggestion.Edits))
+	for i, edit := range suggestion.Edits {
+		ops[i] = OTransform{Position: edit.Start, Delete: edit.End - edit.Start, Insert: edit.Insert}
+	}
+	if len(ops) == 1 {
+		ops[0].Version = version
+		return ops[0]
+	}
+	return OTransform{Version: version, Operations: ops}
+}
+
+/*
+copySuggestions - Returns a deep copy of a list of suggestions.
+*/
+func copySuggestions(suggestions []store.Suggestion) []store.Suggestion {
+	if len(suggestions) == 0 {
+		return nil
+	}
+	copied := make([]store.Suggestion, len(suggestions))
+	for i, suggestion := range suggestions {
+		copied[i] = suggestion
+		copied[i].Edits = append([]store.SuggestionEdit{}, suggestion.Edits...)
+	}
+	return copied
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
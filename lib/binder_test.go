@@ -26,10 +26,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/jeffail/leaps/lib/auth"
 	"github.com/jeffail/leaps/lib/store"
 	"github.com/jeffail/leaps/lib/util"
 )
@@ -608,3 +612,1893 @@ func TestBinderStories(t *testing.T) {
 		binder.Close()
 	}
 }
+
+func TestBinderDocumentTypes(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{
+		"json": {ID: "json", Type: JSONDocumentType, Content: `{"list":[1,2]}`},
+		"bad":  {ID: "bad", Type: "not a type", Content: "hello world"},
+	}}
+
+	if _, err := NewBinder("bad", docStore, DefaultBinderConfig(), errChan, logger, stats); err == nil {
+		t.Errorf("Expected error from unknown document type")
+	}
+
+	binder, err := NewBinder("json", docStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	portal := binder.Subscribe("")
+	if portal.Document.Type != JSONDocumentType {
+		t.Errorf("Wrong document type: %v", portal.Document.Type)
+	}
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "text", Version: 2}, time.Second); err != ErrJSONMissingOperation {
+		t.Errorf("Unexpected error from text transform: %v", err)
+	}
+	if _, err = portal.SendTransform(
+		OTransform{
+			Version: 2,
+			JSONOp:  &JSONOperation{Type: JSONInsert, Path: []interface{}{"list", 0}, Value: "zero"},
+		},
+		time.Second,
+	); err != nil {
+		t.Errorf("Send Transform error: %v", err)
+	}
+
+	if exp, rec := `{"list":["zero",1,2]}`, binder.Subscribe("").Document.Content; exp != rec {
+		t.Errorf("Wrong content, expected %v, received %v", exp, rec)
+	}
+
+	binder.Close()
+}
+
+func TestBinderUndoRedo(t *testing.T) {
+	errChan := make(chan BinderError)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	binder, err := NewBinder(
+		doc.ID,
+		&testStore{documents: map[string]store.Document{doc.ID: *doc}},
+		DefaultBinderConfig(),
+		errChan,
+		logger,
+		stats,
+	)
+	if err != nil {
+		t.Errorf("error: %v", err)
+		return
+	}
+
+	go func() {
+		for err := range errChan {
+			t.Errorf("From error channel: %v", err.Err)
+		}
+	}()
+
+	portal1, portal2 := binder.Subscribe(""), binder.Subscribe("")
+	go func() {
+		for _ = range portal2.TransformRcvChan {
+		}
+	}()
+
+	if _, err = portal1.Undo(time.Second); err != ErrNothingToUndo {
+		t.Errorf("Unexpected undo error: %v", err)
+	}
+	if _, err = portal1.Redo(time.Second); err != ErrNothingToRedo {
+		t.Errorf("Unexpected redo error: %v", err)
+	}
+
+	if _, err = portal1.SendTransform(
+		OTransform{Position: 6, Version: 2, Insert: "big "}, time.Second,
+	); err != nil {
+		t.Errorf("Send Transform error: %v", err)
+	}
+	if _, err = portal2.SendTransform(
+		OTransform{Position: 11, Version: 2, Insert: "!"}, time.Second,
+	); err != nil {
+		t.Errorf("Send Transform error: %v", err)
+	}
+	<-portal1.TransformRcvChan
+
+	checkContent := func(exp string) {
+		portal := binder.Subscribe("")
+		if rec := portal.Document.Content; exp != rec {
+			t.Errorf("Wrong content, expected %v, received %v", exp, rec)
+		}
+		portal.Exit(time.Second)
+	}
+
+	checkContent("hello big world!")
+
+	if v, err := portal1.Undo(time.Second); v != 4 || err != nil {
+		t.Errorf("Undo error, v: %v, err: %v", v, err)
+	}
+	if tform := <-portal1.TransformRcvChan; tform.Version != 4 || tform.Delete != 4 || tform.Position != 6 {
+		t.Errorf("Wrong undo transform received: %v", tform)
+	}
+	checkContent("hello world!")
+
+	if v, err := portal1.Redo(time.Second); v != 5 || err != nil {
+		t.Errorf("Redo error, v: %v, err: %v", v, err)
+	}
+	<-portal1.TransformRcvChan
+	checkContent("hello big world!")
+
+	if _, err = portal2.Undo(time.Second); err != nil {
+		t.Errorf("Undo error: %v", err)
+	}
+	<-portal1.TransformRcvChan
+	checkContent("hello big world")
+
+	readOnly := binder.SubscribeReadOnly("")
+	if _, err = readOnly.Undo(time.Second); err != ErrReadOnlyPortal {
+		t.Errorf("Unexpected read only undo error: %v", err)
+	}
+
+	binder.Close()
+}
+
+func TestBinderUndoUnflushed(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "UNDO", Content: "hello world"})
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 3600000
+
+	binder, err := NewBinder("UNDO", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	portalA, portalB := binder.Subscribe("a"), binder.Subscribe("b")
+	go func() {
+		// Notices of locks are queued ahead of transforms.
+		for _ = range portalA.MessageRcvChan {
+		}
+	}()
+	if _, err = portalA.SendTransform(OTransform{
+		Position: 6, Delete: 5, Insert: "friend", Version: portalA.Version + 1,
+	}, time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if _, err = portalB.SendTransform(OTransform{
+		Position: 0, Insert: "oh, ", Version: portalB.Version + 1,
+	}, time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	<-portalA.TransformRcvChan
+
+	if _, err = portalA.Undo(time.Second); err != nil {
+		t.Fatalf("Undo error: %v", err)
+	}
+	if tform := <-portalA.TransformRcvChan; tform.Position != 10 || tform.Delete != 6 || tform.Insert != "world" {
+		t.Errorf("Wrong undo transform received: %v", tform)
+	}
+	if doc, _ := memStore.Read("UNDO"); doc.Content != "hello world" {
+		t.Errorf("Undo flushed the document: %v", doc.Content)
+	}
+
+	// An undo rejected by a lock remains in the history.
+	if _, err = portalA.Redo(time.Second); err != nil {
+		t.Fatalf("Redo error: %v", err)
+	}
+	<-portalA.TransformRcvChan
+	lock, err := portalB.AddLock(4, 15, auth.NoAccess, time.Second)
+	if err != nil {
+		t.Fatalf("Lock error: %v", err)
+	}
+	if _, err = portalA.Undo(time.Second); err != ErrTransformLocked {
+		t.Errorf("Unexpected undo error: %v", err)
+	}
+	if _, err = portalB.RemoveLock(lock.ID, time.Second); err != nil {
+		t.Fatalf("Unlock error: %v", err)
+	}
+	if _, err = portalA.Undo(time.Second); err != nil {
+		t.Errorf("Undo error after unlock: %v", err)
+	}
+	<-portalA.TransformRcvChan
+
+	snapshot, err := portalA.Resync(time.Second)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if exp := "oh, hello world"; snapshot.Document.Content != exp {
+		t.Errorf("Wrong content: %v != %v", snapshot.Document.Content, exp)
+	}
+}
+
+func TestBinderJournal(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "JOURNAL", Content: "hello world"})
+
+	config := DefaultBinderConfig()
+	config.RetentionPeriod = -1
+	config.JournalRetention = 2
+
+	binder, err := NewBinder("JOURNAL", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	portal := binder.Subscribe("")
+	for i := 0; i < 5; i++ {
+		if _, err = portal.SendTransform(OTransform{
+			Version:  portal.Version + 1 + i,
+			Position: 0,
+			Insert:   "a",
+		}, time.Second); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+	}
+	binder.Close()
+
+	// A fresh binder continues from the journal and rebases stale transforms against it.
+	binder, err = NewBinder("JOURNAL", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	portal = binder.Subscribe("")
+	if portal.Version != 6 {
+		t.Errorf("Wrong version of fresh binder: %v != %v", portal.Version, 6)
+	}
+	version, err := portal.SendTransform(OTransform{Version: 5, Position: 14, Insert: "!"}, time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if version != 7 {
+		t.Errorf("Wrong version: %v != %v", version, 7)
+	}
+	if _, err = portal.SendTransform(OTransform{Version: 3, Position: 0, Insert: "b"}, time.Second); err != ErrTransformTooOld {
+		t.Errorf("Expected transform too old error beyond retention, received: %v", err)
+	}
+	binder.Close()
+
+	doc, err := memStore.Read("JOURNAL")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if exp := "aaaaahello world!"; doc.Content != exp {
+		t.Errorf("Wrong content: %v != %v", exp, doc.Content)
+	}
+}
+
+func TestBinderCursors(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+	doc, _ := store.NewDocument("hello world")
+
+	store := testStore{documents: map[string]store.Document{
+		"CURSORS": *doc,
+	}}
+
+	binder, err := NewBinder("CURSORS", &store, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	defer binder.Close()
+
+	receiveCursor := func(portal BinderPortal) (Message, bool) {
+		select {
+		case msg := <-portal.MessageRcvChan:
+			return msg.Message, true
+		case <-time.After(time.Second):
+		}
+		return Message{}, false
+	}
+
+	portalA, portalB := binder.Subscribe("a"), binder.Subscribe("b")
+
+	position := int64(6)
+	portalA.SendMessage(Message{
+		Position:  &position,
+		Selection: &Selection{Anchor: 0, Head: 5},
+		Active:    true,
+	})
+	if _, ok := receiveCursor(portalB); !ok {
+		t.Errorf("Did not receive cursor")
+		return
+	}
+
+	// Modifying the sent message must not affect the cursor held by the binder.
+	position = 100
+
+	type cursorTest struct {
+		tform     OTransform
+		position  int64
+		selection Selection
+	}
+	tests := []cursorTest{
+		{OTransform{Version: 2, Position: 0, Insert: "abc"}, 9, Selection{Anchor: 3, Head: 8}},
+		{OTransform{Version: 3, Position: 2, Delete: 2}, 7, Selection{Anchor: 2, Head: 6}},
+		{OTransform{Version: 4, Position: 7, Insert: "!"}, 8, Selection{Anchor: 2, Head: 6}},
+	}
+	for _, test := range tests {
+		if _, err = portalB.SendTransform(test.tform, time.Second); err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+		<-portalA.TransformRcvChan
+
+		cursor, ok := receiveCursor(portalB)
+		if !ok {
+			t.Errorf("Did not receive rebased cursor")
+			return
+		}
+		if cursor.Position == nil || *cursor.Position != test.position {
+			t.Errorf("Wrong rebased position: %v != %v", cursor.Position, test.position)
+		}
+		if cursor.Selection == nil || *cursor.Selection != test.selection {
+			t.Errorf("Wrong rebased selection: %v != %v", cursor.Selection, test.selection)
+		}
+	}
+
+	select {
+	case msg := <-portalA.MessageRcvChan:
+		t.Errorf("Client received its own cursor: %v", msg)
+	default:
+	}
+
+	portalC := binder.Subscribe("c")
+	if len(portalC.Cursors) != 1 {
+		t.Errorf("Wrong number of cursors for new subscriber: %v", len(portalC.Cursors))
+		return
+	}
+	if cursor := portalC.Cursors[0]; cursor.Client != portalA.Client || *cursor.Message.Position != 8 {
+		t.Errorf("Wrong cursor for new subscriber: %v", cursor)
+	}
+
+	portalA.SendMessage(Message{Active: false})
+	receiveCursor(portalB)
+	receiveCursor(portalC)
+
+	if portalD := binder.Subscribe("d"); len(portalD.Cursors) != 0 {
+		t.Errorf("Inactive client cursor was given to new subscriber: %v", portalD.Cursors)
+	}
+}
+
+func TestBinderPresence(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+	doc, _ := store.NewDocument("hello world")
+
+	store := testStore{documents: map[string]store.Document{
+		"PRESENCE": *doc,
+	}}
+
+	binder, err := NewBinder("PRESENCE", &store, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	defer binder.Close()
+
+	portalA, portalB := binder.Subscribe("a"), binder.Subscribe("b")
+
+	portalA.SendMessage(Message{
+		Presence: &Presence{
+			Cursors:    []int64{1, 6},
+			Selections: []Selection{{Anchor: 8, Head: 6}, {Anchor: 0, Head: 2}},
+		},
+		Active: true,
+	})
+	<-portalB.MessageRcvChan
+
+	// A compound transform, each operation moves a different cursor.
+	if _, err = portalB.SendTransform(OTransform{
+		Version: 2,
+		Operations: []OTransform{
+			{Position: 0, Insert: "ab"},
+			{Position: 5, Delete: 2},
+		},
+	}, time.Second); err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	<-portalA.TransformRcvChan
+
+	var msg MessageSubmission
+	select {
+	case msg = <-portalB.MessageRcvChan:
+	case <-time.After(time.Second):
+		t.Errorf("Did not receive rebased presence")
+		return
+	}
+	if msg.Client != portalA.Client || msg.Message.Presence == nil {
+		t.Errorf("Wrong presence update: %v", msg)
+		return
+	}
+	presence := msg.Message.Presence
+
+	expCursors := []int64{3, 7}
+	expSelections := []Selection{{Anchor: 8, Head: 7}, {Anchor: 2, Head: 4}}
+	if len(presence.Cursors) != len(expCursors) || len(presence.Selections) != len(expSelections) {
+		t.Errorf("Wrong presence: %v", *presence)
+		return
+	}
+	for i, exp := range expCursors {
+		if presence.Cursors[i] != exp {
+			t.Errorf("Wrong cursor %v: %v != %v", i, presence.Cursors[i], exp)
+		}
+	}
+	for i, exp := range expSelections {
+		if presence.Selections[i] != exp {
+			t.Errorf("Wrong selection %v: %v != %v", i, presence.Selections[i], exp)
+		}
+	}
+
+	if portalC := binder.Subscribe("c"); len(portalC.Cursors) != 1 ||
+		portalC.Cursors[0].Message.Presence.Cursors[1] != 7 {
+		t.Errorf("Wrong presence for new subscriber: %v", portalC.Cursors)
+	}
+}
+
+func TestBinderLocks(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{
+		ID:      "LOCKS",
+		Content: "header: do not edit\nbody",
+		Locks: []store.Lock{
+			{ID: "header", Start: 0, End: 19, Owner: "boss", AccessLevel: auth.CreateAccess},
+		},
+	})
+
+	binder, err := NewBinder("LOCKS", memStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	editor := binder.SubscribeWithAccess("editor", auth.EditAccess)
+	owner := binder.SubscribeWithAccess("boss", auth.EditAccess)
+	admin := binder.SubscribeWithAccess("admin", auth.CreateAccess)
+	for _, portal := range []BinderPortal{editor, owner, admin} {
+		go func(c <-chan OTransform) {
+			for range c {
+			}
+		}(portal.TransformRcvChan)
+	}
+
+	version := editor.Version
+	stories := []struct {
+		portal BinderPortal
+		tform  OTransform
+		err    error
+	}{
+		{editor, OTransform{Position: 0, Insert: "x"}, nil},
+		{editor, OTransform{Position: 5, Insert: "y"}, ErrTransformLocked},
+		{editor, OTransform{Position: 3, Delete: 18}, ErrTransformLocked},
+		{editor, OTransform{Position: 20, Delete: 1}, nil},
+		{owner, OTransform{Position: 8, Insert: "!"}, nil},
+		{admin, OTransform{Position: 1, Delete: 7}, nil},
+	}
+	for i, story := range stories {
+		story.tform.Version = version + 1
+		if _, err = story.portal.SendTransform(story.tform, time.Second); err != story.err {
+			t.Errorf("Wrong result from story %v: %v != %v", i, err, story.err)
+		}
+		if err == nil {
+			version++
+		}
+	}
+
+	// A stale transform is checked once it has been fixed against the transforms it missed.
+	if _, err = editor.SendTransform(OTransform{
+		Version: version - 1, Position: 10, Insert: "z",
+	}, time.Second); err != ErrTransformLocked {
+		t.Errorf("Expected stale transform to be locked, received: %v", err)
+	}
+	binder.Close()
+
+	doc, err := memStore.Read("LOCKS")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if exp := "x! do not editbody"; doc.Content != exp {
+		t.Errorf("Wrong content: %v != %v", exp, doc.Content)
+	}
+	if len(doc.Locks) != 1 {
+		t.Errorf("Wrong number of locks: %v", len(doc.Locks))
+		return
+	}
+	if doc.Locks[0].Start != 1 || doc.Locks[0].End != 14 {
+		t.Errorf("Wrong lock range: %v to %v", doc.Locks[0].Start, doc.Locks[0].End)
+	}
+}
+
+func TestShiftLocks(t *testing.T) {
+	locks := []store.Lock{
+		{ID: "a", Start: 2, End: 5},
+		{ID: "b", Start: 6, End: 8},
+		{ID: "c", Start: 10, End: 12},
+	}
+
+	// Replaces the end of a and the whole of b.
+	locks = shiftLocks(locks, &OTransform{Position: 4, Delete: 5, Insert: "😀"}, PositionUTF16)
+	if len(locks) != 2 {
+		t.Errorf("Wrong number of locks: %v", locks)
+		return
+	}
+	if locks[0].Start != 2 || locks[0].End != 6 {
+		t.Errorf("Wrong range of a: %v", locks[0])
+	}
+	if locks[1].Start != 7 || locks[1].End != 9 {
+		t.Errorf("Wrong range of c: %v", locks[1])
+	}
+
+	// Inserts at either end remain outside of a lock.
+	locks = shiftLocks(locks, &OTransform{Position: 7, Insert: "abc"}, PositionCodePoints)
+	locks = shiftLocks(locks, &OTransform{Position: 13, Insert: "abc"}, PositionCodePoints)
+	if locks[1].Start != 10 || locks[1].End != 12 {
+		t.Errorf("Wrong range of c: %v", locks[1])
+	}
+}
+
+func TestBinderLockOperations(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "LOCK_OPS", Content: "hello world"})
+
+	binder, err := NewBinder("LOCK_OPS", memStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	owner := binder.SubscribeWithAccess("owner", auth.EditAccess)
+	editor := binder.SubscribeWithAccess("editor", auth.EditAccess)
+	reader := binder.SubscribeReadOnly("reader")
+
+	lock, err := owner.AddLock(0, 5, auth.NoAccess, time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if lock.Owner != "owner" || lock.Start != 0 || lock.End != 5 {
+		t.Errorf("Wrong lock: %v", lock)
+	}
+	select {
+	case msg := <-editor.MessageRcvChan:
+		if msg.Message.Lock == nil || msg.Message.Lock.ID != lock.ID || msg.Message.Lock.Released {
+			t.Errorf("Wrong lock broadcast: %v", msg.Message)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Did not receive lock broadcast")
+	}
+
+	for _, portal := range []BinderPortal{owner, editor, reader} {
+		go func(c <-chan OTransform) {
+			for range c {
+			}
+		}(portal.TransformRcvChan)
+		go func(c <-chan MessageSubmission) {
+			for range c {
+			}
+		}(portal.MessageRcvChan)
+	}
+
+	if _, err = editor.SendTransform(OTransform{
+		Version: editor.Version + 1, Position: 2, Insert: "x",
+	}, time.Second); err != ErrTransformLocked {
+		t.Errorf("Expected transform to be locked, received: %v", err)
+	}
+	if _, err = reader.AddLock(0, 1, auth.NoAccess, time.Second); err != ErrReadOnlyPortal {
+		t.Errorf("Expected read only error, received: %v", err)
+	}
+	for _, r := range [][2]int{{-1, 2}, {3, 3}, {6, 12}} {
+		if _, err = editor.AddLock(r[0], r[1], auth.NoAccess, time.Second); err != ErrLockRange {
+			t.Errorf("Expected range error for %v, received: %v", r, err)
+		}
+	}
+	if _, err = editor.RemoveLock(lock.ID, time.Second); err != ErrLockAccess {
+		t.Errorf("Expected access error, received: %v", err)
+	}
+	if _, err = editor.RemoveLock("nope", time.Second); err != ErrLockNotFound {
+		t.Errorf("Expected not found error, received: %v", err)
+	}
+
+	other, err := editor.AddLock(6, 11, auth.EditAccess, time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	released, err := owner.RemoveLock(lock.ID, time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if !released.Released {
+		t.Errorf("Removed lock was not released: %v", released)
+	}
+
+	locks, err := reader.ListLocks(time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if len(locks) != 1 || locks[0].ID != other.ID {
+		t.Errorf("Wrong locks: %v", locks)
+	}
+	binder.Close()
+
+	doc, err := memStore.Read("LOCK_OPS")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if len(doc.Locks) != 1 || doc.Locks[0].ID != other.ID || doc.Locks[0].Owner != "editor" {
+		t.Errorf("Wrong stored locks: %v", doc.Locks)
+	}
+}
+
+func TestBinderComments(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "COMMENTS", Content: "hello world"})
+
+	binder, err := NewBinder("COMMENTS", memStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	author, editor, reader := binder.Subscribe("author"), binder.Subscribe("editor"), binder.SubscribeReadOnly("reader")
+
+	thread, err := author.AddComment(6, 11, "which world?", time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	select {
+	case msg := <-editor.MessageRcvChan:
+		if msg.Message.Comment == nil || msg.Message.Comment.ID != thread.ID {
+			t.Errorf("Wrong comment broadcast: %v", msg.Message)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Did not receive comment broadcast")
+	}
+
+	for _, portal := range []BinderPortal{author, editor, reader} {
+		go func(c <-chan OTransform) {
+			for range c {
+			}
+		}(portal.TransformRcvChan)
+		go func(c <-chan MessageSubmission) {
+			for range c {
+			}
+		}(portal.MessageRcvChan)
+	}
+
+	for i, tform := range []OTransform{
+		{Position: 6, Insert: "big "},
+		{Position: 0, Delete: 6},
+	} {
+		tform.Version = editor.Version + 1 + i
+		if _, err = editor.SendTransform(tform, time.Second); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+	}
+
+	if _, err = reader.AddComment(0, 1, "hi", time.Second); err != ErrReadOnlyPortal {
+		t.Errorf("Expected read only error, received: %v", err)
+	}
+	if _, err = author.AddComment(0, 20, "hi", time.Second); err != ErrCommentRange {
+		t.Errorf("Expected range error, received: %v", err)
+	}
+	if _, err = author.ReplyComment("nope", "hi", time.Second); err != ErrCommentNotFound {
+		t.Errorf("Expected not found error, received: %v", err)
+	}
+	if _, err = editor.ReplyComment(thread.ID, "the big one", time.Second); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if _, err = author.ResolveComment(thread.ID, time.Second); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	threads, err := reader.ListComments(time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if len(threads) != 1 {
+		t.Errorf("Wrong number of threads: %v", threads)
+		return
+	}
+	if threads[0].Start != 4 || threads[0].End != 9 {
+		t.Errorf("Wrong thread range: %v to %v", threads[0].Start, threads[0].End)
+	}
+	binder.Close()
+
+	doc, err := memStore.Read("COMMENTS")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if len(doc.Comments) != 1 {
+		t.Errorf("Wrong number of stored threads: %v", doc.Comments)
+		return
+	}
+	stored := doc.Comments[0]
+	if !stored.Resolved || len(stored.Comments) != 2 || stored.Comments[1].Author != "editor" {
+		t.Errorf("Wrong stored thread: %v", stored)
+	}
+}
+
+func TestBinderSuggestions(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "SUGGESTIONS", Content: "hello world"})
+
+	binder, err := NewBinder("SUGGESTIONS", memStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	owner, reviewer := binder.Subscribe("owner"), binder.SubscribeReadOnly("reviewer")
+	version := owner.Version
+
+	first, err := reviewer.Suggest(OTransform{Version: version + 1, Position: 0, Delete: 5, Insert: "goodbye"}, time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	select {
+	case msg := <-owner.MessageRcvChan:
+		if msg.Message.Suggestion == nil || msg.Message.Suggestion.ID != first.ID {
+			t.Errorf("Wrong suggestion broadcast: %v", msg.Message)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Did not receive suggestion broadcast")
+	}
+
+	go func() {
+		for range reviewer.TransformRcvChan {
+		}
+	}()
+	go func() {
+		for range reviewer.MessageRcvChan {
+		}
+	}()
+	go func() {
+		for range owner.MessageRcvChan {
+		}
+	}()
+
+	for _, tform := range []OTransform{
+		{Version: version + 1, Position: -1, Insert: "x"},
+		{Version: version + 1, Position: 10, Delete: 5},
+	} {
+		if _, err = reviewer.Suggest(tform, time.Second); err != ErrSuggestionRange {
+			t.Errorf("Expected range error for %v, received: %v", tform, err)
+		}
+	}
+
+	second, err := reviewer.Suggest(OTransform{Version: version + 1, Position: 11, Insert: "!"}, time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	// Suggestions are not applied to the document, and move with the transforms that are.
+	if _, err = owner.SendTransform(OTransform{Version: version + 1, Position: 0, Insert: "oh "}, time.Second); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	suggestions, err := owner.ListSuggestions(time.Second)
+	if err != nil || len(suggestions) != 2 {
+		t.Errorf("Wrong suggestions: %v, %v", suggestions, err)
+		return
+	}
+	if edit := suggestions[0].Edits[0]; edit.Start != 3 || edit.End != 8 {
+		t.Errorf("Wrong suggestion edit: %v", edit)
+	}
+	if edit := suggestions[1].Edits[0]; edit.Start != 14 || edit.End != 14 {
+		t.Errorf("Wrong suggestion edit: %v", edit)
+	}
+
+	if _, err = reviewer.AcceptSuggestion(first.ID, time.Second); err != ErrSuggestionAccess {
+		t.Errorf("Expected access error, received: %v", err)
+	}
+	if _, err = reviewer.RejectSuggestion(second.ID, time.Second); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	accepted, err := owner.AcceptSuggestion(first.ID, time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if accepted.Status != store.SuggestionAccepted {
+		t.Errorf("Wrong status: %v", accepted.Status)
+	}
+	select {
+	case tform := <-owner.TransformRcvChan:
+		if tform.Version != version+2 || tform.Position != 3 || tform.Insert != "goodbye" {
+			t.Errorf("Wrong accepted transform: %v", tform)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Did not receive accepted transform")
+	}
+	if _, err = owner.AcceptSuggestion(first.ID, time.Second); err != ErrSuggestionNotFound {
+		t.Errorf("Expected not found error, received: %v", err)
+	}
+
+	if _, err = reviewer.Suggest(OTransform{Version: version + 3, Position: 16, Insert: "?"}, time.Second); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	binder.Close()
+
+	doc, err := memStore.Read("SUGGESTIONS")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if exp := "oh goodbye world"; doc.Content != exp {
+		t.Errorf("Wrong content: %v != %v", exp, doc.Content)
+	}
+	if len(doc.Suggestions) != 1 || doc.Suggestions[0].Edits[0].Insert != "?" {
+		t.Errorf("Wrong stored suggestions: %v", doc.Suggestions)
+	}
+}
+
+func TestBinderChecksums(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "CHECKSUMS", Content: "hello 😀 world"})
+
+	config := DefaultBinderConfig()
+	config.ModelConfig.PositionUnit = PositionUTF16
+
+	binder, err := NewBinder("CHECKSUMS", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	defer binder.Close()
+
+	portalA, portalB := binder.Subscribe("a"), binder.Subscribe("b")
+
+	stories := []struct {
+		tform   OTransform
+		content string
+	}{
+		{OTransform{Position: 5, Insert: ","}, "hello, 😀 world"},
+		{OTransform{Operations: []OTransform{
+			{Position: 0, Delete: 1, Insert: "H"},
+			{Position: 7, Delete: 2},
+		}}, "Hello,  world"},
+		{OTransform{Position: 13, Insert: "!"}, "Hello,  world!"},
+	}
+	for i, story := range stories {
+		story.tform.Version = portalA.Version + 1 + i
+		_, sum, err := portalA.SendTransformChecked(story.tform, time.Second)
+		if err != nil {
+			t.Errorf("Error %v: %v", i, err)
+			return
+		}
+		if exp := Checksum(story.content); sum != exp {
+			t.Errorf("Wrong checksum of correction %v: %v != %v", i, sum, exp)
+		}
+		select {
+		case tform := <-portalB.TransformRcvChan:
+			if tform.Checksum != sum {
+				t.Errorf("Wrong checksum of broadcast %v: %v != %v", i, tform.Checksum, sum)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Did not receive transform %v", i)
+			return
+		}
+	}
+
+	snapshot, err := portalB.Resync(time.Second)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if exp := "Hello,  world!"; snapshot.Document.Content != exp {
+		t.Errorf("Wrong resync content: %v != %v", snapshot.Document.Content, exp)
+	}
+	if snapshot.Version != portalA.Version+len(stories) {
+		t.Errorf("Wrong resync version: %v", snapshot.Version)
+	}
+	if exp := Checksum(snapshot.Document.Content); snapshot.Checksum != exp {
+		t.Errorf("Wrong resync checksum: %v != %v", snapshot.Checksum, exp)
+	}
+}
+
+func TestBinderReplaceContent(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "REPLACE", Content: "the quick brown fox"})
+
+	binder, err := NewBinder("REPLACE", memStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	defer binder.Close()
+
+	portalA, portalB := binder.Subscribe("a"), binder.Subscribe("b")
+
+	stories := []struct {
+		content string
+		version int
+	}{
+		{"the quick red fox", 2},
+		{"the quick red fox", 2},
+		{"a quick red fox jumps", 3},
+	}
+	for i, story := range stories {
+		var version int
+		if i%2 == 0 {
+			version, err = portalA.SendContent(story.content, time.Second)
+		} else {
+			version, err = binder.ReplaceContent(story.content, time.Second)
+		}
+		if err != nil {
+			t.Errorf("Error %v: %v", i, err)
+			return
+		}
+		if version != story.version {
+			t.Errorf("Wrong version %v: %v != %v", i, version, story.version)
+		}
+	}
+
+	for _, portal := range []BinderPortal{portalA, portalB} {
+		content := "the quick brown fox"
+		for i := 0; i < 2; i++ {
+			select {
+			case tform := <-portal.TransformRcvChan:
+				edits := tform.Operations
+				if len(edits) == 0 {
+					edits = []OTransform{tform}
+				}
+				for _, edit := range edits {
+					if edit.Delete >= len(content) {
+						t.Errorf("Received a reload rather than an incremental edit: %v", edit)
+					}
+				}
+				content = applyDiff(t, content, edits, PositionCodePoints)
+			case <-time.After(time.Second):
+				t.Errorf("Did not receive transform %v", i)
+				return
+			}
+		}
+		if exp := "a quick red fox jumps"; content != exp {
+			t.Errorf("Wrong content from transforms: %v != %v", content, exp)
+		}
+	}
+
+	readOnly := binder.SubscribeReadOnly("c")
+	if _, err = readOnly.SendContent("nope", time.Second); err != ErrReadOnlyPortal {
+		t.Errorf("Unexpected error from read only portal: %v", err)
+	}
+}
+
+func TestBinderClientQueues(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	for _, policy := range []string{OverflowKick, OverflowDropPresence, OverflowCoalesce} {
+		memStore, _ := store.GetMemoryStore(store.NewConfig())
+		memStore.Create(store.Document{ID: "QUEUES", Content: ""})
+
+		config := DefaultBinderConfig()
+		config.ClientQueueSize = 4
+		config.ClientOverflowPolicy = policy
+
+		binder, err := NewBinder("QUEUES", memStore, config, errChan, logger, stats)
+		if err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+
+		sender, fast, slow := binder.Subscribe("sender"), binder.Subscribe("fast"), binder.Subscribe("slow")
+
+		position := int64(0)
+		nTransforms := 20
+		started := time.Now()
+		for i := 0; i < nTransforms; i++ {
+			sender.SendMessage(Message{Position: &position, Active: true})
+			if _, err = sender.SendTransform(OTransform{
+				Position: i,
+				Insert:   "a",
+				Version:  sender.Version + 1 + i,
+			}, time.Second); err != nil {
+				t.Errorf("Error %v: %v", policy, err)
+			}
+			for received := false; !received; {
+				select {
+				case <-fast.TransformRcvChan:
+					received = true
+				case <-fast.MessageRcvChan:
+				case <-time.After(time.Second):
+					t.Errorf("Fast client did not receive transform %v: %v", policy, i)
+					return
+				}
+			}
+		}
+		// The slow client must not hold up the others, each send would previously wait for the kick
+		// period of the slow client.
+		if elapsed := time.Since(started); elapsed > time.Duration(config.ClientKickPeriod)*time.Millisecond {
+			t.Errorf("Sending was held up by slow client %v: %v", policy, elapsed)
+		}
+
+		content, versions, closed := "", 0, false
+		for !closed {
+			select {
+			case tform, open := <-slow.TransformRcvChan:
+				if !open {
+					closed = true
+					break
+				}
+				versions += transformSpan(&tform)
+				content = applyDiff(t, content, transformOperations(&tform), PositionCodePoints)
+			case <-slow.MessageRcvChan:
+			case <-time.After(100 * time.Millisecond):
+				closed = true
+			}
+		}
+
+		switch policy {
+		case OverflowKick:
+			if versions >= nTransforms {
+				t.Errorf("Slow client was not kicked: %v", versions)
+			}
+		case OverflowDropPresence:
+			// Only the presence updates were dropped, so the slow client is kicked once its queue
+			// is filled with transforms.
+			if versions >= nTransforms {
+				t.Errorf("Slow client was not kicked: %v", versions)
+			}
+		case OverflowCoalesce:
+			if versions != nTransforms {
+				t.Errorf("Wrong count of versions received: %v != %v", versions, nTransforms)
+			}
+			if exp := strings.Repeat("a", nTransforms); content != exp {
+				t.Errorf("Wrong content from coalesced transforms: %v != %v", content, exp)
+			}
+		}
+		binder.Close()
+	}
+
+	config := DefaultBinderConfig()
+	config.ClientOverflowPolicy = "nope"
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "QUEUES", Content: ""})
+	if _, err := NewBinder("QUEUES", memStore, config, errChan, logger, stats); err != ErrOverflowPolicy {
+		t.Errorf("Unexpected error from unknown overflow policy: %v", err)
+	}
+}
+
+func TestClientQueueDropPresence(t *testing.T) {
+	_, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.ClientQueueSize = 3
+	config.ClientOverflowPolicy = OverflowDropPresence
+
+	queue := newClientQueue(config, stats)
+	position := int64(0)
+	for i := 0; i < 10; i++ {
+		if !queue.pushMessage(MessageSubmission{Message: Message{Position: &position, Active: true}}) {
+			t.Errorf("Queue of presence updates overflowed: %v", i)
+		}
+	}
+	if !queue.pushTransform(OTransform{Version: 2}) || !queue.pushTransform(OTransform{Version: 3}) {
+		t.Errorf("Queue overflowed while presence updates remained")
+	}
+	if !queue.pushMessage(MessageSubmission{Message: Message{Content: "hello", Active: true}}) {
+		t.Errorf("Queue overflowed while presence updates remained")
+	}
+	if queue.pushTransform(OTransform{Version: 4}) {
+		t.Errorf("Queue did not overflow without presence updates")
+	}
+}
+
+func TestClientQueueKeepsLocks(t *testing.T) {
+	_, stats := loggerAndStats()
+
+	for _, policy := range []string{OverflowDropPresence, OverflowCoalesce} {
+		config := DefaultBinderConfig()
+		config.ClientQueueSize = 2
+		config.ClientOverflowPolicy = policy
+
+		queue := newClientQueue(config, stats)
+		client := &BinderClient{UserID: "a"}
+		lock := store.Lock{ID: "lock", Start: 0, End: 5}
+		position := int64(0)
+
+		if !queue.pushMessage(MessageSubmission{Client: client, Message: Message{Lock: &lock, Active: true}}) {
+			t.Errorf("Queue overflowed: %v", policy)
+		}
+		for i := 0; i < 5; i++ {
+			if !queue.pushMessage(MessageSubmission{Client: client, Message: Message{Position: &position, Active: true}}) {
+				t.Errorf("Queue overflowed while presence updates remained: %v", policy)
+			}
+		}
+		if len(queue.items) == 0 || queue.items[0].message == nil || queue.items[0].message.Message.Lock == nil {
+			t.Errorf("Queue dropped a lock notice: %v", policy)
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Number of clients subscribed to a document for broadcast benchmarks.
+const benchClients = 500
+
+func benchBroadcast(b *testing.B, policy string, stalled int) {
+	logger, stats := loggerAndStats()
+	errChan := make(chan BinderError, 10)
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "BENCH", Content: ""})
+
+	config := DefaultBinderConfig()
+	config.ClientOverflowPolicy = policy
+
+	binder, err := NewBinder("BENCH", memStore, config, errChan, logger, stats)
+	if err != nil {
+		b.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	sender := binder.Subscribe("sender")
+	for i := 0; i < benchClients; i++ {
+		portal := binder.Subscribe(fmt.Sprintf("client%v", i))
+		if i < stalled {
+			continue
+		}
+		go func() {
+			for {
+				select {
+				case _, open := <-portal.TransformRcvChan:
+					if !open {
+						return
+					}
+				case <-portal.MessageRcvChan:
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sender.SendTransform(OTransform{
+			Position: 0,
+			Insert:   "a",
+			Version:  sender.Version + 1 + i,
+		}, time.Second); err != nil {
+			b.Fatalf("Error: %v", err)
+		}
+	}
+}
+
+func BenchmarkBinderBroadcast500Clients(b *testing.B) {
+	benchBroadcast(b, OverflowKick, 0)
+}
+
+func BenchmarkBinderBroadcast500ClientsStalled(b *testing.B) {
+	benchBroadcast(b, OverflowCoalesce, benchClients/10)
+}
+
+func TestBinderExternalChanges(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "EXTERNAL", Content: "hello world"})
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 3600000
+
+	binder, err := NewBinder("EXTERNAL", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	portalA, portalB := binder.Subscribe("a"), binder.Subscribe("b")
+	if _, err = portalA.SendTransform(OTransform{
+		Position: 0, Insert: "A: ", Version: portalA.Version + 1,
+	}, time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	// Modified while the transform of A is yet to be flushed.
+	memStore.Update(store.Document{ID: "EXTERNAL", Content: "hello big world!"})
+
+	snapshot, err := portalA.Resync(time.Second)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	exp := "A: hello big world!"
+	if snapshot.Document.Content != exp {
+		t.Errorf("Wrong content after merge: %v != %v", snapshot.Document.Content, exp)
+	}
+	if doc, _ := memStore.Read("EXTERNAL"); doc.Content != exp {
+		t.Errorf("Wrong stored content after merge: %v != %v", doc.Content, exp)
+	}
+
+	for _, story := range []struct {
+		portal  BinderPortal
+		content string
+		count   int
+	}{
+		{portalA, "A: hello world", 1},
+		{portalB, "hello world", 2},
+	} {
+		content := story.content
+		for i := 0; i < story.count; i++ {
+			select {
+			case tform := <-story.portal.TransformRcvChan:
+				content = applyDiff(t, content, transformOperations(&tform), PositionCodePoints)
+			case <-time.After(time.Second):
+				t.Fatalf("Did not receive transform %v", i)
+			}
+		}
+		if content != exp {
+			t.Errorf("Wrong content of client: %v != %v", content, exp)
+		}
+	}
+}
+
+func TestBinderExternalChangeResync(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "EXTERNAL", Content: "hello world"})
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 3600000
+	config.ModelConfig.MaxTransformLength = 10
+
+	binder, err := NewBinder("EXTERNAL", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	portalA, portalB := binder.Subscribe("a"), binder.Subscribe("b")
+	if _, err = portalA.SendTransform(OTransform{
+		Position: 0, Insert: "A: ", Version: portalA.Version + 1,
+	}, time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	<-portalB.TransformRcvChan
+
+	// Too large to be merged as a single transform.
+	exp := "hello world, and hello to everyone else"
+	memStore.Update(store.Document{ID: "EXTERNAL", Content: exp})
+
+	snapshot, err := portalA.Resync(time.Second)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if snapshot.Document.Content != exp || snapshot.Version != 2 {
+		t.Errorf("Wrong snapshot after reset: %v, %v", snapshot.Document.Content, snapshot.Version)
+	}
+	if doc, _ := memStore.Read("EXTERNAL"); doc.Content != exp {
+		t.Errorf("Dropped transform was stored: %v", doc.Content)
+	}
+
+	for _, portal := range []BinderPortal{portalA, portalB} {
+		select {
+		case msg := <-portal.MessageRcvChan:
+			resync := msg.Message.Resync
+			if !msg.Message.ExternalChange || resync == nil {
+				t.Fatalf("Wrong notice of external change: %v", msg)
+			}
+			if resync.Document.Content != exp || resync.Version != 2 || resync.Checksum != snapshot.Checksum {
+				t.Errorf("Wrong resync: %v", resync)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Did not receive resync")
+		}
+	}
+
+	if _, err = portalB.SendTransform(OTransform{
+		Position: 0, Insert: "B: ", Version: 3,
+	}, time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if snapshot, err = portalB.Resync(time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if exp = "B: " + exp; snapshot.Document.Content != exp {
+		t.Errorf("Wrong content after resync: %v != %v", snapshot.Document.Content, exp)
+	}
+}
+
+func TestBinderWatchedStore(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	dir, err := ioutil.TempDir("", "leaps_binder_watch")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	storeConfig := store.NewConfig()
+	storeConfig.StoreDirectory = dir
+	storeConfig.FileWatcher.PollPeriod = 10
+
+	fileStore, err := store.GetFileStore(storeConfig)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	fileStore.Create(store.Document{ID: "watched.txt", Content: "hello world"})
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 3600000
+
+	binder, err := NewBinder("watched.txt", fileStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	portal := binder.Subscribe("a")
+	if err = ioutil.WriteFile(filepath.Join(dir, "watched.txt"), []byte("hello big world"), 0666); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	content := "hello world"
+	select {
+	case tform := <-portal.TransformRcvChan:
+		content = applyDiff(t, content, transformOperations(&tform), PositionCodePoints)
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive transform of external change")
+	}
+	if exp := "hello big world"; content != exp {
+		t.Errorf("Wrong content of client: %v != %v", content, exp)
+	}
+	select {
+	case msg := <-portal.MessageRcvChan:
+		if !msg.Message.ExternalChange || msg.Client != nil {
+			t.Errorf("Wrong notice of external change: %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive notice of external change")
+	}
+}
+
+/*
+watchedTestStore - Counts the reads of a test store, and signals modifications on demand.
+*/
+type watchedTestStore struct {
+	*testStore
+	reads     int
+	watchChan chan struct{}
+}
+
+/*
+Read - Fetch document from memory, counting the read.
+*/
+func (s *watchedTestStore) Read(id string) (store.Document, error) {
+	s.mutex.Lock()
+	s.reads++
+	s.mutex.Unlock()
+	return s.testStore.Read(id)
+}
+
+/*
+Reads - Returns the number of reads so far.
+*/
+func (s *watchedTestStore) Reads() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.reads
+}
+
+/*
+Watch - Returns the channel of modification signals.
+*/
+func (s *watchedTestStore) Watch(id string) (<-chan struct{}, func(), error) {
+	return s.watchChan, func() {}, nil
+}
+
+func TestBinderWatchedStoreReads(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	watched := &watchedTestStore{
+		testStore: &testStore{documents: map[string]store.Document{
+			"READS": {ID: "READS", Content: "hello world"},
+		}},
+		watchChan: make(chan struct{}),
+	}
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 3600000
+
+	binder, err := NewBinder("READS", watched, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	initialReads := watched.Reads()
+	portal := binder.Subscribe("a")
+	if _, err = portal.SendTransform(OTransform{
+		Position: 0, Insert: "A: ", Version: portal.Version + 1,
+	}, time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if _, err = portal.Resync(time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if doc, _ := watched.testStore.Read("READS"); doc.Content != "A: hello world" {
+		t.Errorf("Wrong stored content: %v", doc.Content)
+	}
+	if reads := watched.Reads() - initialReads; reads != 0 {
+		t.Errorf("Watched store read without a signal: %v", reads)
+	}
+
+	watched.Update(store.Document{ID: "READS", Content: "A: hello big world"})
+	watched.watchChan <- struct{}{}
+
+	select {
+	case <-portal.MessageRcvChan:
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive notice of external change")
+	}
+	if reads := watched.Reads() - initialReads; reads != 1 {
+		t.Errorf("Wrong count of reads after a signal: %v", reads)
+	}
+}
+
+/*
+testObserver - Records the events of binders.
+*/
+type testObserver struct {
+	events []BinderEvent
+	mutex  sync.Mutex
+}
+
+func (o *testObserver) OnBinderEvent(event BinderEvent) {
+	o.mutex.Lock()
+	o.events = append(o.events, event)
+	o.mutex.Unlock()
+}
+
+func (o *testObserver) Events() []BinderEvent {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return append([]BinderEvent{}, o.events...)
+}
+
+func TestBinderObserver(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "OBSERVED", Content: "hello world"})
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 3600000
+
+	binder, err := NewBinder("OBSERVED", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	observer := &testObserver{}
+	binder.AddObserver(observer)
+
+	// An observer that blocks must not hold up the binder.
+	blockChan := make(chan struct{})
+	defer close(blockChan)
+	binder.AddObserver(BinderObserverFunc(func(event BinderEvent) {
+		<-blockChan
+	}))
+
+	portal := binder.Subscribe("a")
+	version, err := portal.SendTransform(OTransform{
+		Position: 0, Insert: "A: ", Version: portal.Version + 1,
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	portal.SendMessage(Message{Content: "hello", Active: true})
+	if _, err = portal.Resync(time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	portal.Exit(time.Second)
+	binder.Close()
+
+	expected := []BinderEvent{
+		{Type: EventClientJoined, UserID: "a", Version: portal.Version},
+		{Type: EventTransformApplied, UserID: "a", Version: version},
+		{Type: EventMessageRelayed, UserID: "a", Version: version},
+		{Type: EventFlushSucceeded, Version: version},
+		{Type: EventClientLeft, UserID: "a", Version: version},
+		{Type: EventBinderClosed, Version: version},
+	}
+	events := observer.Events()
+	if len(events) != len(expected) {
+		t.Fatalf("Wrong count of events: %v != %v: %v", len(events), len(expected), events)
+	}
+	for i, exp := range expected {
+		event := events[i]
+		if event.Type != exp.Type || event.UserID != exp.UserID || event.Version != exp.Version {
+			t.Errorf("Wrong event %v: %+v != %+v", i, event, exp)
+		}
+		if event.DocumentID != "OBSERVED" {
+			t.Errorf("Wrong document of event %v: %v", i, event.DocumentID)
+		}
+		if len(exp.UserID) > 0 && event.SessionID != portal.Client.SessionID {
+			t.Errorf("Wrong session of event %v: %v", i, event.SessionID)
+		}
+	}
+	if tform := events[1].Transform; tform == nil || tform.Insert != "A: " {
+		t.Errorf("Wrong transform of event: %v", tform)
+	}
+	if msg := events[2].Message; msg == nil || msg.Content != "hello" {
+		t.Errorf("Wrong message of event: %v", msg)
+	}
+}
+
+func TestBinderMaxClients(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "FULL", Content: "hello world"})
+
+	config := DefaultBinderConfig()
+	config.MaxClients = 2
+
+	binder, err := NewBinder("FULL", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	portalA := binder.Subscribe("a")
+	portalB := binder.SubscribeReadOnly("b")
+	if portalA.Error != nil || portalB.Error != nil {
+		t.Fatalf("Unexpected errors: %v, %v", portalA.Error, portalB.Error)
+	}
+	if portal := binder.Subscribe("c"); portal.Error != ErrTooManyClients {
+		t.Errorf("Expected too many clients error: %v", portal.Error)
+	}
+
+	// Clients can join once another leaves.
+	portalA.Exit(time.Second)
+	if portal := binder.Subscribe("c"); portal.Error != nil {
+		t.Errorf("Unexpected error: %v", portal.Error)
+	}
+}
+
+func TestBinderRateLimits(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "LIMITED", Content: "hello world"})
+
+	config := DefaultBinderConfig()
+	config.TransformRateLimit = RateLimitConfig{Rate: 0.001, Burst: 2}
+	config.MessageRateLimit = RateLimitConfig{Rate: 0.001, Burst: 1}
+	config.RateLimitKickAfter = 3
+
+	binder, err := NewBinder("LIMITED", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	portalA, portalB := binder.Subscribe("a"), binder.Subscribe("b")
+
+	version := portalA.Version
+	for i := 0; i < 3; i++ {
+		version++
+		_, err = portalA.SendTransform(OTransform{Position: 0, Insert: "a", Version: version}, time.Second)
+		if i < 2 && err != nil {
+			t.Errorf("Error: %v", err)
+		}
+		if i == 2 && err != ErrRateLimited {
+			t.Errorf("Expected rate limited error: %v", err)
+		}
+	}
+
+	// Each client has its own limits.
+	if _, err = portalB.SendTransform(OTransform{Position: 0, Insert: "b", Version: version}, time.Second); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	portalA.SendMessage(Message{Content: "first", Active: true})
+	portalA.SendMessage(Message{Content: "second", Active: true})
+	if _, err = portalB.Resync(time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	received := []string{}
+	for done := false; !done; {
+		select {
+		case <-portalB.TransformRcvChan:
+		case msg := <-portalB.MessageRcvChan:
+			received = append(received, msg.Message.Content)
+		case <-time.After(time.Millisecond * 100):
+			done = true
+		}
+	}
+	if exp := []string{"first"}; fmt.Sprintf("%v", received) != fmt.Sprintf("%v", exp) {
+		t.Errorf("Wrong messages received: %v != %v", received, exp)
+	}
+
+	// The third offence kicks the client.
+	if _, err = portalA.SendTransform(OTransform{Position: 0, Insert: "a", Version: version + 1}, time.Second); err != ErrRateLimited {
+		t.Errorf("Expected rate limited error: %v", err)
+	}
+	select {
+	case _, open := <-portalA.TransformRcvChan:
+		for open {
+			_, open = <-portalA.TransformRcvChan
+		}
+	case <-time.After(time.Second):
+		t.Error("Client was not kicked")
+	}
+	if users, err := binder.GetUsers(time.Second); err != nil || len(users) != 1 || users[0] != "b" {
+		t.Errorf("Wrong users after kick: %v, %v", users, err)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	if bucket := newTokenBucket(RateLimitConfig{Rate: 0, Burst: 1}); bucket != nil || !bucket.take(time.Now()) {
+		t.Error("Disabled bucket should not limit")
+	}
+
+	now := time.Now()
+	bucket := newTokenBucket(RateLimitConfig{Rate: 10, Burst: 2})
+	bucket.last = now
+	for i, exp := range []bool{true, true, false} {
+		if bucket.take(now) != exp {
+			t.Errorf("Wrong result from take %v: %v", i, !exp)
+		}
+	}
+	if !bucket.take(now.Add(time.Millisecond * 100)) {
+		t.Error("Bucket was not replenished")
+	}
+	if bucket.take(now.Add(time.Millisecond * 150)) {
+		t.Error("Bucket replenished too quickly")
+	}
+	// Replenishing stops at the burst.
+	now = now.Add(time.Hour)
+	for i, exp := range []bool{true, true, false} {
+		if bucket.take(now) != exp {
+			t.Errorf("Wrong result from take %v after an hour: %v", i, !exp)
+		}
+	}
+}
+
+func TestBinderClientInfo(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "INFO", Content: "hello world"})
+
+	binder, err := NewBinder("INFO", memStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	before := time.Now().Unix()
+
+	portalA := binder.Subscribe("a")
+	portalB := binder.SubscribeReadOnly("b")
+
+	profile := ClientProfile{DisplayName: "Alice", Colour: "#ff0000"}
+	metadata := ClientMetadata{RemoteAddress: "127.0.0.1:80", ClientProfile: profile}
+	if err = portalA.SetMetadata(metadata, time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	position := int64(6)
+	portalA.SendMessage(Message{Position: &position, Active: true})
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-portalB.MessageRcvChan:
+			if msg.Profile == nil || *msg.Profile != profile {
+				t.Errorf("Wrong profile in message %v: %v", i, msg.Profile)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for message")
+		}
+	}
+
+	users, err := binder.GetClients(time.Second)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("Wrong count of users: %v", len(users))
+	}
+
+	for _, info := range users {
+		if info.Joined < before || info.LastActive < info.Joined {
+			t.Errorf("Wrong timestamps: %v, %v", info.Joined, info.LastActive)
+		}
+		switch info.SessionID {
+		case portalA.Client.SessionID:
+			if info.UserID != "a" || info.ReadOnly {
+				t.Errorf("Wrong record of editor: %v", info)
+			}
+			if info.ClientMetadata != metadata {
+				t.Errorf("Wrong metadata: %v != %v", info.ClientMetadata, metadata)
+			}
+			if info.Cursor == nil || info.Cursor.Position == nil || *info.Cursor.Position != 6 {
+				t.Errorf("Wrong cursor: %v", info.Cursor)
+			}
+		case portalB.Client.SessionID:
+			if info.UserID != "b" || !info.ReadOnly {
+				t.Errorf("Wrong record of reader: %v", info)
+			}
+			if info.Cursor != nil {
+				t.Errorf("Unexpected cursor: %v", info.Cursor)
+			}
+		default:
+			t.Errorf("Unexpected session: %v", info.SessionID)
+		}
+	}
+}
+
+func TestBinderKickSession(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "KICK", Content: "hello world"})
+
+	binder, err := NewBinder("KICK", memStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	portalA, portalB := binder.Subscribe("a"), binder.Subscribe("a")
+
+	if err = binder.KickSession("nope", "", time.Second); err != ErrClientNotFound {
+		t.Errorf("Expected client not found error: %v", err)
+	}
+
+	// A result that is never received, as after a timeout, must not block the binder.
+	binder.kickChan <- kickRequest{sessionID: "nope", result: make(chan error, 1)}
+	if _, err = binder.GetUsers(time.Second); err != nil {
+		t.Fatalf("Binder blocked after abandoned kick: %v", err)
+	}
+	if err = binder.KickSession(portalA.Client.SessionID, "behave", time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	select {
+	case msg := <-portalA.MessageRcvChan:
+		if !msg.Message.Kicked || msg.Message.Reason != "behave" {
+			t.Errorf("Wrong kick message: %v", msg.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for kick message")
+	}
+	select {
+	case _, open := <-portalA.MessageRcvChan:
+		if open {
+			t.Error("Kicked client received another message")
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for kicked client to close")
+	}
+
+	select {
+	case msg := <-portalB.MessageRcvChan:
+		if msg.Client != portalA.Client || msg.Message.Active {
+			t.Errorf("Wrong announcement of kicked client: %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for announcement of kicked client")
+	}
+
+	users, err := binder.GetClients(time.Second)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(users) != 1 || users[0].SessionID != portalB.Client.SessionID {
+		t.Errorf("Wrong remaining users: %v", users)
+	}
+
+	if err = binder.KickUser("a", time.Second); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if err = binder.KickUser("a", time.Second); err != ErrClientNotFound {
+		t.Errorf("Expected client not found error: %v", err)
+	}
+}
+
+func TestBinderIdleClients(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "IDLE", Content: "hello world"})
+
+	config := DefaultBinderConfig()
+	config.ClientIdleTimeout = 100
+
+	binder, err := NewBinder("IDLE", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	active, idle := binder.Subscribe("active"), binder.SubscribeReadOnly("idle")
+
+	stopPings := make(chan struct{})
+	defer close(stopPings)
+	go func() {
+		for {
+			select {
+			case <-time.After(20 * time.Millisecond):
+				active.Ping(time.Second)
+			case <-stopPings:
+				return
+			}
+		}
+	}()
+
+	select {
+	case msg := <-idle.MessageRcvChan:
+		if !msg.Message.Kicked || msg.Message.Reason != "inactive" {
+			t.Errorf("Wrong eviction message: %v", msg.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for eviction of idle client")
+	}
+
+	select {
+	case msg := <-active.MessageRcvChan:
+		if msg.Client != idle.Client || msg.Message.Active {
+			t.Errorf("Wrong announcement of idle client: %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for announcement of idle client")
+	}
+
+	users, err := binder.GetClients(time.Second)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(users) != 1 || users[0].UserID != "active" {
+		t.Errorf("Wrong remaining users: %v", users)
+	}
+}
@@ -61,13 +61,17 @@ func DefaultCuratorConfig() CuratorConfig {
 // Errors for the Curator type.
 var (
 	ErrBinderNotFound = errors.New("binder was not found")
+	ErrInvalidLock    = errors.New("document contained a lock with an invalid range")
+	ErrBanned         = errors.New("user is banned from the document")
 )
 
 /*
 Curator - A structure designed to keep track of a live collection of Binders. Assists prospective
 clients in locating their target Binders, and when necessary creates new Binders.
 
-The curator is fully in control of the binders, and manages their life cycles internally.
+The curator is fully in control of the binders, and manages their life cycles internally. Users can
+be banned from a document, or from all documents, for a period of time, during which the curator
+refuses to subscribe them.
 */
 type Curator struct {
 	config        CuratorConfig
@@ -76,10 +80,15 @@ type Curator struct {
 	stats         metrics.Aggregator
 	authenticator auth.Authenticator
 
-	// Binders
+	// Binders, and the queues of observers of the events of all binders
 	openBinders map[string]*Binder
+	observers   []*eventQueue
 	binderMutex sync.RWMutex
 
+	// Bans of users by document ID, bans of all documents have an empty document ID
+	bans     map[string]map[string]userBan
+	banMutex sync.Mutex
+
 	// Control channels
 	errorChan  chan BinderError
 	closeChan  chan struct{}
@@ -87,7 +96,8 @@ type Curator struct {
 }
 
 /*
-NewCurator - Creates and returns a fresh curator, and launches its internal loop.
+NewCurator - Creates and returns a fresh curator, and launches its internal loop. Write-ahead logs
+left behind by a previous run are replayed into the store first.
 */
 func NewCurator(
 	config CuratorConfig,
@@ -97,6 +107,17 @@ func NewCurator(
 	store store.Store,
 ) (*Curator, error) {
 
+	if config.BinderConfig.JournalRetention > 0 && !journalSupported(store) {
+		log.Warnf("Journal retention of %v is ignored, the store cannot keep journals\n",
+			config.BinderConfig.JournalRetention)
+	}
+	if len(config.BinderConfig.WALDirectory) > 0 {
+		err := ReplayWAL(config.BinderConfig.WALDirectory, store, config.BinderConfig.ModelConfig, log, stats)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	curator := Curator{
 		config:        config,
 		store:         store,
@@ -104,6 +125,7 @@ func NewCurator(
 		stats:         stats,
 		authenticator: auth,
 		openBinders:   make(map[string]*Binder),
+		bans:          make(map[string]map[string]userBan),
 		errorChan:     make(chan BinderError, 10),
 		closeChan:     make(chan struct{}),
 		closedChan:    make(chan struct{}),
@@ -162,6 +184,10 @@ func (c *Curator) loop() {
 				b.Close()
 				c.stats.Decr("curator.open_binders", 1)
 			}
+			kickPeriod := time.Duration(c.config.BinderConfig.ClientKickPeriod) * time.Millisecond
+			for _, q := range c.observers {
+				q.close(kickPeriod)
+			}
 			c.binderMutex.Unlock()
 			close(c.closedChan)
 			return
@@ -172,12 +198,63 @@ func (c *Curator) loop() {
 /*--------------------------------------------------------------------------------------------------
  */
 
+/*
+AddObserver - Registers an observer of the events of all binders of the curator, including binders
+that are already open.
+*/
+func (c *Curator) AddObserver(observer BinderObserver) {
+	queue := newEventQueue(observer, c.config.BinderConfig.EventQueueSize, c.stats)
+
+	c.binderMutex.Lock()
+	defer c.binderMutex.Unlock()
+
+	c.observers = append(c.observers, queue)
+	for _, binder := range c.openBinders {
+		binder.addEventQueue(queue, false)
+	}
+}
+
+/*
+observeBinder - Adds the observers of the curator to a new binder and announces that it was opened,
+the binder mutex must be held.
+*/
+func (c *Curator) observeBinder(binder *Binder) {
+	for _, queue := range c.observers {
+		binder.addEventQueue(queue, false)
+	}
+	binder.emit(BinderEvent{Type: EventBinderOpened})
+}
+
 /*
 KickUser - Remove a particular user from a document, requires the respective user and document IDs.
 */
 func (c *Curator) KickUser(documentID, userID string, timeout time.Duration) error {
-	c.log.Debugf("attempting to kick user %v from document %v\n", documentID, userID)
+	return c.KickUserWithReason(documentID, userID, "", timeout)
+}
+
+/*
+KickUserWithReason - Remove every client of a particular user from a document, requires the
+respective user and document IDs. The reason is sent to each removed client.
+*/
+func (c *Curator) KickUserWithReason(documentID, userID, reason string, timeout time.Duration) error {
+	c.log.Debugf("attempting to kick user %v from document %v\n", userID, documentID)
+	return c.kick(documentID, func(binder *Binder) error {
+		return binder.KickUserWithReason(userID, reason, timeout)
+	})
+}
 
+/*
+KickSession - Remove the client of a particular session from a document, requires the respective
+session and document IDs. The reason is sent to the removed client.
+*/
+func (c *Curator) KickSession(documentID, sessionID, reason string, timeout time.Duration) error {
+	c.log.Debugf("attempting to kick session %v from document %v\n", sessionID, documentID)
+	return c.kick(documentID, func(binder *Binder) error {
+		return binder.KickSession(sessionID, reason, timeout)
+	})
+}
+
+func (c *Curator) kick(documentID string, kickFn func(binder *Binder) error) error {
 	c.binderMutex.Lock()
 
 	// Check for existing binder
@@ -187,11 +264,11 @@ func (c *Curator) KickUser(documentID, userID string, timeout time.Duration) err
 
 	if !ok {
 		c.stats.Incr("curator.kick_user.error", 1)
-		c.log.Errorf("Failed to kick user %v from %v: Document was not open\n", userID, documentID)
+		c.log.Errorf("Failed to kick from %v: Document was not open\n", documentID)
 		return ErrBinderNotFound
 	}
 
-	if err := binder.KickUser(userID, timeout); err != nil {
+	if err := kickFn(binder); err != nil {
 		c.stats.Incr("curator.kick_user.error", 1)
 		return err
 	}
@@ -200,10 +277,130 @@ func (c *Curator) KickUser(documentID, userID string, timeout time.Duration) err
 	return nil
 }
 
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+userBan - A ban of a user, which lasts until it expires, or indefinitely when the expiry is zero.
+*/
+type userBan struct {
+	expires time.Time
+	reason  string
+}
+
+/*
+BanUser - Ban a user from a document for a period of time, or from all documents when the document ID
+is empty. A ban with a period of zero lasts until it is lifted. Any clients of the user that are
+connected to the banned documents are kicked with the reason of the ban.
+*/
+func (c *Curator) BanUser(documentID, userID, reason string, period, timeout time.Duration) error {
+	c.log.Infof("Banning user %v from document %v for %v\n", userID, documentID, period)
+
+	ban := userBan{reason: reason}
+	if period > 0 {
+		ban.expires = time.Now().Add(period)
+	}
+
+	c.banMutex.Lock()
+	if _, ok := c.bans[documentID]; !ok {
+		c.bans[documentID] = map[string]userBan{}
+	}
+	c.bans[documentID][userID] = ban
+	c.banMutex.Unlock()
+
+	c.stats.Incr("curator.ban_user.success", 1)
+
+	binders := []*Binder{}
+
+	c.binderMutex.Lock()
+	for id, binder := range c.openBinders {
+		if len(documentID) == 0 || id == documentID {
+			binders = append(binders, binder)
+		}
+	}
+	c.binderMutex.Unlock()
+
+	started := time.Now()
+	for _, binder := range binders {
+		err := binder.KickUserWithReason(userID, reason, timeout-time.Since(started))
+		if err != nil && err != ErrClientNotFound {
+			c.stats.Incr("curator.kick_user.error", 1)
+			c.log.Errorf("Failed to kick banned user %v from %v: %v\n", userID, binder.ID, err)
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+UnbanUser - Lift the ban of a user from a document, or the ban from all documents when the document ID
+is empty. Returns false if the user was not banned.
+*/
+func (c *Curator) UnbanUser(documentID, userID string) bool {
+	c.banMutex.Lock()
+	defer c.banMutex.Unlock()
+
+	if _, ok := c.bans[documentID][userID]; !ok {
+		return false
+	}
+	delete(c.bans[documentID], userID)
+	if len(c.bans[documentID]) == 0 {
+		delete(c.bans, documentID)
+	}
+	c.stats.Incr("curator.unban_user.success", 1)
+	return true
+}
+
+/*
+checkBan - Returns ErrBanned if a user is currently banned from a document, either directly or by a
+ban from all documents. Expired bans are removed.
+*/
+func (c *Curator) checkBan(userID, documentID string) error {
+	c.banMutex.Lock()
+	defer c.banMutex.Unlock()
+
+	now := time.Now()
+	for _, id := range []string{"", documentID} {
+		ban, ok := c.bans[id][userID]
+		if !ok {
+			continue
+		}
+		if !ban.expires.IsZero() && !now.Before(ban.expires) {
+			delete(c.bans[id], userID)
+			if len(c.bans[id]) == 0 {
+				delete(c.bans, id)
+			}
+			continue
+		}
+		c.log.Debugf("Rejected banned user %v from document %v: %v\n", userID, documentID, ban.reason)
+		return ErrBanned
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
 /*
 GetUsers - Return a full list of all connected users of all open documents.
 */
 func (c *Curator) GetUsers(timeout time.Duration) (map[string][]string, error) {
+	clients, err := c.GetClients(timeout)
+	list := map[string][]string{}
+	for id, docClients := range clients {
+		users := make([]string, len(docClients))
+		for i, client := range docClients {
+			users[i] = client.UserID
+		}
+		list[id] = users
+	}
+	return list, err
+}
+
+/*
+GetClients - Return the records of all connected clients of all open documents, by document ID.
+*/
+func (c *Curator) GetClients(timeout time.Duration) (map[string][]ClientInfo, error) {
 	openBinders := []*Binder{}
 
 	c.binderMutex.Lock()
@@ -215,9 +412,9 @@ func (c *Curator) GetUsers(timeout time.Duration) (map[string][]string, error) {
 	started := time.Now()
 
 	// TODO: make these calls asynchronous
-	list := map[string][]string{}
+	list := map[string][]ClientInfo{}
 	for _, binder := range openBinders {
-		users, err := binder.GetUsers(timeout - time.Since(started))
+		users, err := binder.GetClients(timeout - time.Since(started))
 		if err != nil {
 			c.stats.Incr("curator.get_users.error", 1)
 			c.log.Errorf("Failed to get users list from %v\n", binder.ID)
@@ -232,6 +429,17 @@ func (c *Curator) GetUsers(timeout time.Duration) (map[string][]string, error) {
 	return list, nil
 }
 
+/*
+subscribed - Returns the error of a portal if the subscription to its binder was rejected.
+*/
+func (c *Curator) subscribed(portal BinderPortal) (BinderPortal, error) {
+	if portal.Error != nil {
+		c.stats.Incr("curator.subscribe.rejected", 1)
+		return BinderPortal{}, portal.Error
+	}
+	return portal, nil
+}
+
 /*
 EditDocument - Locates or creates a Binder for an existing document and returns that Binder for
 subscribing to. Returns an error if there was a problem locating the document.
@@ -239,11 +447,16 @@ subscribing to. Returns an error if there was a problem locating the document.
 func (c *Curator) EditDocument(userID, token, documentID string) (BinderPortal, error) {
 	c.log.Debugf("finding document %v, with userID %v token %v\n", documentID, userID, token)
 
-	if c.authenticator.Authenticate(userID, token, documentID) < auth.EditAccess {
+	access := c.authenticator.Authenticate(userID, token, documentID)
+	if access < auth.EditAccess {
 		c.stats.Incr("curator.edit.rejected_client", 1)
 		return BinderPortal{},
 			fmt.Errorf("failed to authorise join of document id: %v with token: %v\n", documentID, token)
 	}
+	if err := c.checkBan(userID, documentID); err != nil {
+		c.stats.Incr("curator.edit.banned_client", 1)
+		return BinderPortal{}, err
+	}
 	c.stats.Incr("curator.edit.accepted_client", 1)
 
 	c.binderMutex.Lock()
@@ -252,7 +465,7 @@ func (c *Curator) EditDocument(userID, token, documentID string) (BinderPortal,
 	if binder, ok := c.openBinders[documentID]; ok {
 		c.binderMutex.Unlock()
 
-		return binder.Subscribe(userID), nil
+		return c.subscribed(binder.SubscribeWithAccess(userID, access))
 	}
 	binder, err := NewBinder(documentID, c.store, c.config.BinderConfig, c.errorChan, c.log, c.stats)
 	if err != nil {
@@ -263,10 +476,11 @@ func (c *Curator) EditDocument(userID, token, documentID string) (BinderPortal,
 		return BinderPortal{}, err
 	}
 	c.openBinders[documentID] = binder
+	c.observeBinder(binder)
 	c.binderMutex.Unlock()
 
 	c.stats.Incr("curator.open_binders", 1)
-	return binder.Subscribe(userID), nil
+	return c.subscribed(binder.SubscribeWithAccess(userID, access))
 }
 
 /*
@@ -282,6 +496,10 @@ func (c *Curator) ReadDocument(userID, token, documentID string) (BinderPortal,
 		return BinderPortal{},
 			fmt.Errorf("failed to authorise read only join of document id: %v with token: %v\n", documentID, token)
 	}
+	if err := c.checkBan(userID, documentID); err != nil {
+		c.stats.Incr("curator.read.banned_client", 1)
+		return BinderPortal{}, err
+	}
 	c.stats.Incr("curator.read.accepted_client", 1)
 
 	c.binderMutex.Lock()
@@ -290,7 +508,7 @@ func (c *Curator) ReadDocument(userID, token, documentID string) (BinderPortal,
 	if binder, ok := c.openBinders[documentID]; ok {
 		c.binderMutex.Unlock()
 
-		return binder.SubscribeReadOnly(userID), nil
+		return c.subscribed(binder.SubscribeReadOnly(userID))
 	}
 	binder, err := NewBinder(documentID, c.store, c.config.BinderConfig, c.errorChan, c.log, c.stats)
 	if err != nil {
@@ -301,10 +519,11 @@ func (c *Curator) ReadDocument(userID, token, documentID string) (BinderPortal,
 		return BinderPortal{}, err
 	}
 	c.openBinders[documentID] = binder
+	c.observeBinder(binder)
 	c.binderMutex.Unlock()
 
 	c.stats.Incr("curator.open_binders", 1)
-	return binder.SubscribeReadOnly(userID), nil
+	return c.subscribed(binder.SubscribeReadOnly(userID))
 }
 
 /*
@@ -319,8 +538,37 @@ func (c *Curator) CreateDocument(userID, token string, doc store.Document) (Bind
 		c.stats.Incr("curator.create.rejected_client", 1)
 		return BinderPortal{}, fmt.Errorf("failed to gain permission to create with token: %v\n", token)
 	}
+	if err := c.checkBan(userID, ""); err != nil {
+		c.stats.Incr("curator.create.banned_client", 1)
+		return BinderPortal{}, err
+	}
 	c.stats.Incr("curator.create.accepted_client", 1)
 
+	if !ModelRegistered(doc.Type) {
+		c.stats.Incr("curator.create_new.failed", 1)
+		c.log.Errorf("Failed to create new document of type '%v': %v\n", doc.Type, ErrUnknownDocumentType)
+		return BinderPortal{}, ErrUnknownDocumentType
+	}
+
+	// Locks without an owner belong to their creator, and must lie within the content.
+	length := unitLength(c.config.BinderConfig.ModelConfig.PositionUnit, doc.Content)
+	doc.Locks = append([]store.Lock{}, doc.Locks...)
+	for i := range doc.Locks {
+		if doc.Locks[i].Start < 0 || doc.Locks[i].End <= doc.Locks[i].Start || doc.Locks[i].End > length {
+			c.stats.Incr("curator.create_new.failed", 1)
+			c.log.Errorf("Failed to create new document: %v\n", ErrInvalidLock)
+			return BinderPortal{}, ErrInvalidLock
+		}
+		if len(doc.Locks[i].Owner) == 0 {
+			doc.Locks[i].Owner = userID
+		}
+		// Locks are removed by their ID.
+		if len(doc.Locks[i].ID) == 0 {
+			doc.Locks[i].ID = util.GenerateStampedUUID()
+		}
+		doc.Locks[i].Released = false
+	}
+
 	// Always generate a fresh ID
 	doc.ID = util.GenerateStampedUUID()
 
@@ -337,10 +585,19 @@ func (c *Curator) CreateDocument(userID, token string, doc store.Document) (Bind
 	}
 	c.binderMutex.Lock()
 	c.openBinders[doc.ID] = binder
+	for _, queue := range c.observers {
+		queue.push(BinderEvent{
+			Type:       EventDocumentCreated,
+			DocumentID: doc.ID,
+			UserID:     userID,
+			Timestamp:  time.Now().Unix(),
+		})
+	}
+	c.observeBinder(binder)
 	c.binderMutex.Unlock()
 	c.stats.Incr("curator.open_binders", 1)
 
-	return binder.Subscribe(userID), nil
+	return binder.SubscribeWithAccess(userID, auth.CreateAccess), nil
 }
 
 /*--------------------------------------------------------------------------------------------------
@@ -205,3 +205,169 @@ func TestCuratorClients(t *testing.T) {
 		t.Errorf("Timeout occured waiting for test finish.")
 	}
 }
+
+func TestCuratorObserver(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	doc, err := store.NewDocument("hello world")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	portalOne, err := curator.CreateDocument("one", "", *doc)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	// Added after the first binder was opened.
+	observer := &testObserver{}
+	curator.AddObserver(observer)
+
+	doc, err = store.NewDocument("hello world")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	portalTwo, err := curator.CreateDocument("two", "", *doc)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if _, err = curator.EditDocument("three", "", portalOne.Document.ID); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	curator.Close()
+
+	joined := map[string]string{}
+	closed := map[string]bool{}
+	for _, event := range observer.Events() {
+		switch event.Type {
+		case EventClientJoined:
+			joined[event.UserID] = event.DocumentID
+		case EventBinderClosed:
+			closed[event.DocumentID] = true
+		}
+	}
+	exp := map[string]string{
+		"two":   portalTwo.Document.ID,
+		"three": portalOne.Document.ID,
+	}
+	if fmt.Sprintf("%v", joined) != fmt.Sprintf("%v", exp) {
+		t.Errorf("Wrong joined clients: %v != %v", joined, exp)
+	}
+	if !closed[portalOne.Document.ID] || !closed[portalTwo.Document.ID] {
+		t.Errorf("Binders were not observed closing: %v", closed)
+	}
+}
+
+func TestCuratorBans(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer curator.Close()
+
+	doc, err := store.NewDocument("hello world")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	portal, err := curator.CreateDocument("creator", "", *doc)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	docID := portal.Document.ID
+
+	banned, err := curator.EditDocument("banned", "", docID)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+
+	if err = curator.BanUser(docID, "banned", "spam", time.Hour, time.Second); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	select {
+	case msg, open := <-banned.MessageRcvChan:
+		if !open || !msg.Message.Kicked || msg.Message.Reason != "spam" {
+			t.Errorf("Wrong kick message: %v, %v", msg.Message, open)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for kick message")
+	}
+
+	if _, err = curator.EditDocument("banned", "", docID); err != ErrBanned {
+		t.Errorf("Expected banned error: %v", err)
+	}
+	if _, err = curator.ReadDocument("banned", "", docID); err != ErrBanned {
+		t.Errorf("Expected banned error: %v", err)
+	}
+	if _, err = curator.EditDocument("other", "", docID); err != nil {
+		t.Errorf("error: %v", err)
+	}
+
+	if !curator.UnbanUser(docID, "banned") {
+		t.Error("Ban was not lifted")
+	}
+	if curator.UnbanUser(docID, "banned") {
+		t.Error("Ban was lifted twice")
+	}
+	if _, err = curator.ReadDocument("banned", "", docID); err != nil {
+		t.Errorf("error: %v", err)
+	}
+
+	// Bans from all documents also prevent creating documents, and expire.
+	if err = curator.BanUser("", "global", "", 50*time.Millisecond, time.Second); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if _, err = curator.EditDocument("global", "", docID); err != ErrBanned {
+		t.Errorf("Expected banned error: %v", err)
+	}
+	if _, err = curator.CreateDocument("global", "", *doc); err != ErrBanned {
+		t.Errorf("Expected banned error: %v", err)
+	}
+	<-time.After(100 * time.Millisecond)
+	if _, err = curator.EditDocument("global", "", docID); err != nil {
+		t.Errorf("error: %v", err)
+	}
+
+	if err = curator.KickUser("missing", "global", time.Second); err != ErrBinderNotFound {
+		t.Errorf("Expected binder not found error: %v", err)
+	}
+}
+
+func TestCuratorCreateLocks(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer curator.Close()
+
+	for _, lock := range []store.Lock{
+		{Start: -1, End: 2},
+		{Start: 2, End: 2},
+		{Start: 6, End: 12},
+	} {
+		doc := store.Document{Content: "hello world", Locks: []store.Lock{lock}}
+		if _, err = curator.CreateDocument("creator", "", doc); err != ErrInvalidLock {
+			t.Errorf("Expected invalid lock error for %v: %v", lock, err)
+		}
+	}
+
+	doc := store.Document{Content: "hello world", Locks: []store.Lock{{Start: 6, End: 11}}}
+	portal, err := curator.CreateDocument("creator", "", doc)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	locks := portal.Document.Locks
+	if len(locks) != 1 || locks[0].Owner != "creator" || len(locks[0].ID) == 0 {
+		t.Errorf("Wrong locks: %v", locks)
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+The maximum number of differing runes that a diff will search for before giving up on a minimal
+result, which keeps the cost of diffing two unrelated documents bounded. Beyond this the content
+between the common prefix and suffix is replaced as a single edit.
+*/
+const diffMaxCost = 1024
+
+/*
+DiffContent - Computes a minimal set of edits that turn one version of content into another, using
+the Myers diff algorithm over runes. The edits are relative to the original content, do not overlap,
+and are ordered by descending position, which makes them suitable as the operations of a compound
+transform. Positions and deletions are counted in a position unit, an empty unit counts runes.
+Returns nil when the content is unchanged.
+*/
+func DiffContent(from, to string, unit string) []OTransform {
+	a, b := []rune(from), []rune(to)
+
+	// Common prefixes and suffixes are trimmed first, as most edits are small and local.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	offset := 0
+	for _, r := range a[:prefix] {
+		offset += unitRuneLength(unit, r)
+	}
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+
+	script := diffScript(a, b)
+	if script == nil {
+		return []OTransform{{
+			Position: offset,
+			Delete:   unitLength(unit, string(a)),
+			Insert:   string(b),
+		}}
+	}
+
+	// Consecutive deletions and insertions are joined into edits, and the position of each edit is
+	// counted in the position unit.
+	edits := []OTransform{}
+	x, y, pos := 0, 0, offset
+	for i := 0; i < len(script); {
+		if script[i] == diffKeep {
+			pos += unitRuneLength(unit, a[x])
+			x++
+			y++
+			i++
+			continue
+		}
+		edit := OTransform{Position: pos}
+		insert := []rune{}
+		for ; i < len(script) && script[i] != diffKeep; i++ {
+			if script[i] == diffDelete {
+				edit.Delete += unitRuneLength(unit, a[x])
+				x++
+			} else {
+				insert = append(insert, b[y])
+				y++
+			}
+		}
+		edit.Insert = string(insert)
+		pos += edit.Delete
+		edits = append(edits, edit)
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+/*
+DiffTransform - Computes the edits that turn one version of content into another as a single
+transform of a version, which is compound if there is more than one edit. Returns false if the
+content is unchanged.
+*/
+func DiffTransform(from, to string, unit string, version int) (OTransform, bool) {
+	edits := DiffContent(from, to, unit)
+	if len(edits) == 0 {
+		return OTransform{}, false
+	}
+	if len(edits) == 1 {
+		edits[0].Version = version
+		return edits[0], true
+	}
+	return OTransform{Version: version, Operations: edits}, true
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Steps of an edit script.
+const (
+	diffKeep = iota
+	diffDelete
+	diffInsert
+)
+
+/*
+diffScript - Returns the shortest edit script that turns a into b, as a step for each rune that is
+kept, deleted from a, or inserted from b. Returns nil if the script would exceed the maximum cost.
+*/
+func diffScript(a, b []rune) []int {
+	n, m := len(a), len(b)
+	limit := intMin(n+m, diffMaxCost)
+
+	// The furthest x reached on each diagonal k, for each cost d, where v[d][k+d] holds diagonal k.
+	trace := [][]int{}
+	prev := []int{0}
+	for d := 0; d <= limit; d++ {
+		v := make([]int, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && diffAt(prev, d-1, k-1) < diffAt(prev, d-1, k+1)) {
+				x = diffAt(prev, d-1, k+1)
+			} else {
+				x = diffAt(prev, d-1, k-1) + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+d] = x
+			if x >= n && y >= m {
+				trace = append(trace, v)
+				return diffBacktrack(trace, n, m)
+			}
+		}
+		trace = append(trace, v)
+		prev = v
+	}
+	return nil
+}
+
+/*
+diffAt - Returns the furthest x of a diagonal from the results of a cost, where the results of a cost
+below zero are treated as a single diagonal reaching zero.
+*/
+func diffAt(v []int, d, k int) int {
+	if d < 0 {
+		return 0
+	}
+	if k < -d || k > d {
+		return -1
+	}
+	return v[k+d]
+}
+
+/*
+diffBacktrack - Walks the trace of a diff from the end of both sequences back to the start, returning
+the steps of the edit script in order.
+*/
+func diffBacktrack(trace [][]int, n, m int) []int {
+	script := []int{}
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && diffAt(trace[d-1], d-1, k-1) < diffAt(trace[d-1], d-1, k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := diffAt(trace[d-1], d-1, prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			script = append(script, diffKeep)
+			x--
+			y--
+		}
+		if x == prevX {
+			script = append(script, diffInsert)
+		} else {
+			script = append(script, diffDelete)
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		script = append(script, diffKeep)
+		x--
+		y--
+	}
+	for i, j := 0, len(script)-1; i < j; i, j = i+1, j-1 {
+		script[i], script[j] = script[j], script[i]
+	}
+	return script
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
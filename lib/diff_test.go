@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func applyDiff(t *testing.T, from string, edits []OTransform, unit string) string {
+	rope := NewRope(from)
+	for _, edit := range edits {
+		if _, err := rope.SpliceUnits(unit, edit.Position, edit.Delete, edit.Insert); err != nil {
+			t.Errorf("Error applying edit %v: %v", edit, err)
+		}
+	}
+	return rope.String()
+}
+
+func TestDiffContent(t *testing.T) {
+	type diffStory struct {
+		from, to string
+		cost     int // Runes deleted and inserted
+	}
+	stories := []diffStory{
+		{"hello world", "hello world", 0},
+		{"", "hello", 5},
+		{"hello", "", 5},
+		{"hello world", "hello there world", 6},
+		{"the quick brown fox", "the slow brown dog", 13},
+		{"abcabba", "cbabac", 5},
+		{"a😀b😀c", "a😀c😀b", 4},
+	}
+	for _, unit := range []string{PositionCodePoints, PositionUTF16, PositionBytes} {
+		for i, story := range stories {
+			edits := DiffContent(story.from, story.to, unit)
+			if unit == PositionCodePoints {
+				cost := 0
+				for _, edit := range edits {
+					cost += edit.Delete + len([]rune(edit.Insert))
+				}
+				if cost != story.cost {
+					t.Errorf("Wrong cost of edits for story %v: %v != %v", i, cost, story.cost)
+				}
+			}
+			if act := applyDiff(t, story.from, edits, unit); act != story.to {
+				t.Errorf("Wrong result for story %v, %v: %v != %v", i, unit, act, story.to)
+			}
+		}
+	}
+}
+
+func TestDiffContentRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	words := []string{"lorem", "ipsum", "😀", "dolor", "日本語", "\n", " "}
+
+	randomText := func(length int) string {
+		parts := make([]string, length)
+		for i := range parts {
+			parts[i] = words[rng.Intn(len(words))]
+		}
+		return strings.Join(parts, "")
+	}
+
+	for i := 0; i < 200; i++ {
+		from := randomText(rng.Intn(50))
+		to := from
+		for j := rng.Intn(5); j >= 0; j-- {
+			runes := []rune(to)
+			pos := rng.Intn(len(runes) + 1)
+			del := rng.Intn(len(runes)-pos+1) / 2
+			to = string(runes[:pos]) + randomText(rng.Intn(3)) + string(runes[pos+del:])
+		}
+		edits := DiffContent(from, to, PositionUTF16)
+		if act := applyDiff(t, from, edits, PositionUTF16); act != to {
+			t.Errorf("Wrong result at %v: %q != %q", i, act, to)
+			return
+		}
+		for j := 1; j < len(edits); j++ {
+			if edits[j].Position+edits[j].Delete > edits[j-1].Position {
+				t.Errorf("Edits overlap or are out of order at %v: %v", i, edits)
+				return
+			}
+		}
+	}
+}
+
+func TestDiffContentCost(t *testing.T) {
+	from := strings.Repeat("a", 5000)
+	to := strings.Repeat("b", 5000)
+
+	edits := DiffContent("x"+from+"y", "x"+to+"y", PositionCodePoints)
+	if len(edits) != 1 {
+		t.Errorf("Expected a single edit beyond the maximum cost: %v", len(edits))
+		return
+	}
+	if edits[0].Position != 1 || edits[0].Delete != 5000 || edits[0].Insert != to {
+		t.Errorf("Wrong edit: %v, %v", edits[0].Position, edits[0].Delete)
+	}
+}
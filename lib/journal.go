@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+journalSupported - Returns true if a store is able to keep the journals of documents.
+*/
+func journalSupported(block store.Store) bool {
+	_, ok := block.(store.JournalStore)
+	return ok
+}
+
+/*
+storeJournal - A TransformJournal of a document that is persisted within a store.JournalStore, each
+transform is stored as a JSON encoded entry.
+*/
+type storeJournal struct {
+	id    string
+	store store.JournalStore
+}
+
+/*
+ReadTransforms - Reads and decodes the transforms of a range of versions from the store.
+*/
+func (j storeJournal) ReadTransforms(from, to int) ([]OTransform, error) {
+	entries, err := j.store.ReadJournal(j.id, from)
+	if err != nil {
+		return nil, err
+	}
+	transforms := []OTransform{}
+	for _, entry := range entries {
+		if entry.Version >= to {
+			break
+		}
+		var ot OTransform
+		if err = json.Unmarshal(entry.Data, &ot); err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, ot)
+	}
+	return transforms, nil
+}
+
+/*
+versionRange - Returns the versions of the first and last transforms of the journal, or zeros if it
+is empty.
+*/
+func (j storeJournal) versionRange() (int, int, error) {
+	entries, err := j.store.ReadJournal(j.id, 0)
+	if err != nil || len(entries) == 0 {
+		return 0, 0, err
+	}
+	return entries[0].Version, entries[len(entries)-1].Version, nil
+}
+
+/*
+appendTransforms - Encodes and appends transforms to the journal in the store.
+*/
+func (j storeJournal) appendTransforms(transforms []OTransform) error {
+	entries := make([]store.JournalEntry, len(transforms))
+	for i, ot := range transforms {
+		data, err := json.Marshal(ot)
+		if err != nil {
+			return err
+		}
+		entries[i] = store.JournalEntry{Version: ot.Version, Data: data}
+	}
+	return j.store.AppendJournal(j.id, entries)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -22,15 +22,35 @@ THE SOFTWARE.
 
 package lib
 
+import (
+	"errors"
+	"sync"
+)
+
 /*--------------------------------------------------------------------------------------------------
  */
 
+// Units in which text transform positions and lengths can be counted.
+const (
+	PositionCodePoints = "code_points"
+	PositionUTF16      = "utf16"
+	PositionBytes      = "bytes"
+)
+
+// Errors for model configuration.
+var (
+	ErrUnknownPositionUnit = errors.New("model config contained an unrecognised position unit")
+)
+
 /*
-ModelConfig - Holds configuration options for a transform model.
+ModelConfig - Holds configuration options for a transform model. The position unit determines how
+the positions, deletions and insert lengths of text transforms are counted, clients written in
+JavaScript, which index strings by UTF-16 code units, should use PositionUTF16.
 */
 type ModelConfig struct {
 	MaxDocumentSize    uint64 `json:"max_document_size" yaml:"max_document_size"`
 	MaxTransformLength uint64 `json:"max_transform_length" yaml:"max_transform_length"`
+	PositionUnit       string `json:"position_unit" yaml:"position_unit"`
 }
 
 /*
@@ -40,13 +60,26 @@ func DefaultModelConfig() ModelConfig {
 	return ModelConfig{
 		MaxDocumentSize:    50000000, // ~50MB
 		MaxTransformLength: 50000,    // ~50KB
+		PositionUnit:       PositionCodePoints,
+	}
+}
+
+/*
+validate - Returns ErrUnknownPositionUnit if the config selects an unknown position unit.
+*/
+func (c ModelConfig) validate() error {
+	switch c.PositionUnit {
+	case "", PositionCodePoints, PositionUTF16, PositionBytes:
+		return nil
 	}
+	return ErrUnknownPositionUnit
 }
 
 /*
 Model - an interface that represents an internal operation transform model of a particular type.
-Initially text is the only supported transform model, however, the plan will eventually be to have
-different models for various types of document that should all be supported by our binder.
+Currently text (CreateTextModel) and JSON (CreateJSONModel) documents are supported, the plan will
+eventually be to have different models for various types of document that should all be supported
+by our binder.
 */
 type Model interface {
 	/* PushTransform - Push a single transform to our model, and if successful, return the updated
@@ -68,5 +101,139 @@ type Model interface {
 	GetVersion() int
 }
 
+/*
+InvertibleModel - an optional interface for models that are able to revert their own transforms,
+which is required for undo and redo within a binder.
+*/
+type InvertibleModel interface {
+	/* InvertTransform - returns a transform that reverts the applied transform of a particular
+	 * version. The inverse is versioned as if it were submitted directly after the original, so
+	 * that pushing it to the model rebases it over any later transforms.
+	 */
+	InvertTransform(version int) (OTransform, error)
+
+	/* InvertUnappliedTransform - behaves the same as InvertTransform, but is also able to revert a
+	 * transform that is yet to be flushed, given the content of the model after its applied
+	 * transforms.
+	 */
+	InvertUnappliedTransform(version int, content *Rope) (OTransform, error)
+}
+
+/*
+RebaseableModel - an optional interface for models that are able to show how a transform would be
+fixed in relation to earlier transforms before it is pushed, allowing a transform to be checked
+against the current version of the document.
+*/
+type RebaseableModel interface {
+	/* RebaseTransform - returns a transform as it would be pushed to the model, without modifying
+	 * the model.
+	 */
+	RebaseTransform(ot OTransform) (OTransform, error)
+}
+
+/*
+RopeModel - an optional interface for models that are able to apply transforms directly to content
+held within a Rope, allowing a binder to keep its live content in memory and apply transforms to it
+at a cost in proportion to the size of the edits. Storing the content still costs in proportion to
+the size of the document.
+*/
+type RopeModel interface {
+	/* FlushTransformsToRope - behaves the same as FlushTransforms, but applies transforms to a
+	 * rope rather than a string.
+	 */
+	FlushTransformsToRope(content *Rope, secondsRetention int64) (bool, error)
+}
+
+/*
+TransformJournal - a source of transforms that have been flushed, which may include transforms that
+are no longer retained by a model.
+*/
+type TransformJournal interface {
+	/* ReadTransforms - returns the transforms with a version from 'from', up to but not including
+	 * 'to', in ascending order of version.
+	 */
+	ReadTransforms(from, to int) ([]OTransform, error)
+}
+
+/*
+JournaledModel - an optional interface for models that are able to rebase transforms against a
+journal once they are too old for the transforms retained in memory.
+*/
+type JournaledModel interface {
+	/* SetJournal - sets the journal of the model along with the version of the last transform it
+	 * holds, the model continues from this version. Must be called before any transforms are pushed.
+	 */
+	SetJournal(journal TransformJournal, version int)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Document types supported out of the box.
+const (
+	TextDocumentType = "text"
+	JSONDocumentType = "json"
+)
+
+// Errors for the model registry.
+var (
+	ErrUnknownDocumentType = errors.New("document type does not have a registered model")
+)
+
+/*
+ModelConstructor - A function that creates a fresh Model for a particular type of document.
+*/
+type ModelConstructor func(config ModelConfig) Model
+
+var (
+	modelConstructors = map[string]ModelConstructor{
+		"":               CreateTextModel,
+		TextDocumentType: CreateTextModel,
+		JSONDocumentType: CreateJSONModel,
+	}
+	modelConstructorsMutex sync.RWMutex
+)
+
+/*
+RegisterModel - Register a model constructor for a document type, documents of this type opened
+after registration will be bound to a model created with the constructor. Registering an existing
+type replaces its constructor.
+*/
+func RegisterModel(docType string, constructor ModelConstructor) {
+	modelConstructorsMutex.Lock()
+	defer modelConstructorsMutex.Unlock()
+
+	modelConstructors[docType] = constructor
+}
+
+/*
+ModelRegistered - Returns true if a model constructor is registered for the document type.
+*/
+func ModelRegistered(docType string) bool {
+	modelConstructorsMutex.RLock()
+	defer modelConstructorsMutex.RUnlock()
+
+	_, ok := modelConstructors[docType]
+	return ok
+}
+
+/*
+NewModel - Create a fresh Model for a document type, returns ErrUnknownDocumentType if the type has
+no registered constructor, or ErrUnknownPositionUnit if the config selects an unknown position unit.
+*/
+func NewModel(docType string, config ModelConfig) (Model, error) {
+	modelConstructorsMutex.RLock()
+	constructor, ok := modelConstructors[docType]
+	modelConstructorsMutex.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownDocumentType
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return constructor(config), nil
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
@@ -0,0 +1,561 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the JSON Operational Transform model.
+var (
+	ErrJSONMissingOperation = errors.New("transform did not contain a JSON operation")
+	ErrJSONInvalidOperation = errors.New("JSON operation type was not recognised")
+	ErrJSONInvalidPath      = errors.New("JSON operation path could not be resolved")
+	ErrJSONNotNumber        = errors.New("JSON operation value or target was not a number")
+	ErrJSONNotList          = errors.New("JSON operation target was not a list")
+)
+
+// Types of JSON operation.
+const (
+	JSONInsert  = "insert"
+	JSONDelete  = "delete"
+	JSONReplace = "replace"
+	JSONMove    = "move"
+	JSONAdd     = "add"
+	JSONNoop    = "noop"
+)
+
+/*
+JSONOperation - A single operation on a JSON document. The path is a list of object keys (strings)
+and list indexes (numbers) leading from the root of the document to the target of the operation.
+
+- insert: Sets a new key of an object, or inserts Value into a list at the index of the path.
+- delete: Removes a key from an object, or an element from a list.
+- replace: Replaces the value at the path with Value, an empty path replaces the whole document.
+- move: Moves an element of a list from the index of the path to the index To.
+- add: Adds the number Value to the number found at the path.
+
+An operation can be reduced to a noop when an earlier transform made its target obsolete, e.g. the
+element it referred to was deleted.
+*/
+type JSONOperation struct {
+	Type  string        `json:"type" yaml:"type"`
+	Path  []interface{} `json:"path" yaml:"path"`
+	Value interface{}   `json:"value,omitempty" yaml:"value,omitempty"`
+	To    int           `json:"to,omitempty" yaml:"to,omitempty"`
+}
+
+/*
+JSONModel - A representation of the transform model surrounding a JSON document session. Works the
+same way as OModel except that transforms carry JSON operations rather than text edits.
+*/
+type JSONModel struct {
+	config    ModelConfig
+	Version   int
+	Applied   []OTransform
+	Unapplied []OTransform
+}
+
+/*
+CreateJSONModel - Returns a fresh JSON transform model, with the version set to 1.
+*/
+func CreateJSONModel(config ModelConfig) Model {
+	return &JSONModel{
+		config:    config,
+		Version:   1,
+		Applied:   []OTransform{},
+		Unapplied: []OTransform{},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+PushTransform - Inserts a transform onto the unapplied stack and increments the version number of
+the document. Whilst doing so it fixes the JSON operation in relation to earlier transforms it was
+unaware of, this fixed version gets sent back for distributing across other clients.
+*/
+func (m *JSONModel) PushTransform(ot OTransform) (OTransform, int, error) {
+	if ot.JSONOp == nil {
+		return OTransform{}, 0, ErrJSONMissingOperation
+	}
+	switch ot.JSONOp.Type {
+	case JSONInsert, JSONDelete, JSONReplace, JSONMove, JSONAdd, JSONNoop:
+	default:
+		return OTransform{}, 0, ErrJSONInvalidOperation
+	}
+	if ot.JSONOp.Value != nil {
+		valueBytes, err := json.Marshal(ot.JSONOp.Value)
+		if err != nil {
+			return OTransform{}, 0, err
+		}
+		if uint64(len(valueBytes)) > m.config.MaxTransformLength {
+			return OTransform{}, 0, ErrTransformTooLong
+		}
+	}
+
+	// The operation is shared with the submitter, so we modify a copy.
+	op := *ot.JSONOp
+	op.Path = append([]interface{}{}, op.Path...)
+	ot.JSONOp = &op
+
+	lenApplied, lenUnapplied := len(m.Applied), len(m.Unapplied)
+
+	diff := (m.Version + 1) - ot.Version
+
+	if diff > lenApplied+lenUnapplied {
+		return OTransform{}, 0, ErrTransformTooOld
+	}
+	if diff < 0 {
+		return OTransform{}, 0, fmt.Errorf(
+			"transform version %v greater than expected doc version (%v), offender: %v",
+			ot.Version, (m.Version + 1), ot)
+	}
+
+	for j := lenApplied - (diff - lenUnapplied); j < lenApplied; j++ {
+		updateJSONOperation(ot.JSONOp, m.Applied[j].JSONOp)
+		diff--
+	}
+	for j := lenUnapplied - diff; j < lenUnapplied; j++ {
+		updateJSONOperation(ot.JSONOp, m.Unapplied[j].JSONOp)
+	}
+
+	m.Version++
+
+	ot.Version = m.Version
+	ot.TReceived = time.Now().Unix()
+
+	m.Unapplied = append(m.Unapplied, ot)
+
+	return ot, m.Version, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+IsDirty - Check if there is any unapplied transforms.
+*/
+func (m *JSONModel) IsDirty() bool {
+	return len(m.Unapplied) > 0
+}
+
+/*
+GetVersion - returns the current version of the document.
+*/
+func (m *JSONModel) GetVersion() int {
+	return m.Version
+}
+
+/*
+FlushTransforms - parse the content as JSON, apply all unapplied transforms and append them to the
+applied stack, then remove old entries from the applied stack. The content is written back as
+compact JSON. An empty content is treated as a null document.
+*/
+func (m *JSONModel) FlushTransforms(content *string, secondsRetention int64) (bool, error) {
+	transforms := m.Unapplied[:]
+	m.Unapplied = []OTransform{}
+
+	var root interface{}
+	if len(*content) > 0 {
+		if err := json.Unmarshal([]byte(*content), &root); err != nil {
+			return false, fmt.Errorf("failed to parse JSON document: %v", err)
+		}
+	}
+
+	var i, j int
+	var err error
+	for i = 0; i < len(transforms); i++ {
+		if root, err = applyJSONOperation(root, transforms[i].JSONOp); err != nil {
+			break
+		}
+	}
+
+	if i > 0 {
+		contentBytes, errMarshal := json.Marshal(root)
+		if errMarshal != nil {
+			return false, errMarshal
+		}
+		if uint64(len(contentBytes)) > m.config.MaxDocumentSize {
+			return false, ErrTransformTooLong
+		}
+		*content = string(contentBytes)
+	}
+
+	upto := time.Now().Unix() - secondsRetention
+	for j = 0; j < len(m.Applied); j++ {
+		if m.Applied[j].TReceived > upto {
+			break
+		}
+	}
+
+	applied := m.Applied[j:]
+	m.Applied = make([]OTransform, len(transforms)+len(applied))
+
+	copy(m.Applied[:], applied)
+	copy(m.Applied[len(applied):], transforms)
+
+	return i > 0, err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+jsonIndex - Converts a path element into a list index, path elements decoded from JSON are float64
+but elements created in Go might be any integer type.
+*/
+func jsonIndex(element interface{}) (int, bool) {
+	switch v := element.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), float64(int(v)) == v
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err == nil
+	}
+	return 0, false
+}
+
+/*
+jsonNumber - Converts a JSON value into a float64 if it is numeric.
+*/
+func jsonNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+/*
+jsonPathHasPrefix - Returns true if the path begins with all elements of prefix.
+*/
+func jsonPathHasPrefix(path, prefix []interface{}) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i, element := range prefix {
+		if !jsonElementEqual(path[i], element) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+jsonElementEqual - Compares two path elements, numbers are compared as indexes.
+*/
+func jsonElementEqual(left, right interface{}) bool {
+	if l, ok := jsonIndex(left); ok {
+		r, ok := jsonIndex(right)
+		return ok && l == r
+	}
+	l, lok := left.(string)
+	r, rok := right.(string)
+	return lok && rok && l == r
+}
+
+/*
+jsonMoveIndex - Maps an index of a list onto the same element after a move from 'from' to 'to' has
+been applied.
+*/
+func jsonMoveIndex(index, from, to int) int {
+	if index == from {
+		return to
+	}
+	if from < index {
+		index--
+	}
+	if to <= index {
+		index++
+	}
+	return index
+}
+
+/*
+updateJSONOperation - When a transform is speculative it potentially has missed transforms that are
+already applied. This method retroactively modifies the operation 'sub' in relation to the missed
+operation 'pre' in order to preserve its intention.
+
+Operations on list indexes are shifted by earlier inserts, deletes and moves within the same list.
+Operations whose target was removed or replaced by 'pre' are reduced to noops.
+*/
+func updateJSONOperation(sub, pre *JSONOperation) {
+	if sub == nil || pre == nil || sub.Type == JSONNoop || pre.Type == JSONNoop {
+		return
+	}
+
+	preLen := len(pre.Path)
+	if preLen == 0 {
+		// The whole document was replaced, anything other than another replace is obsolete.
+		if pre.Type == JSONReplace && len(sub.Path) > 0 {
+			sub.Type = JSONNoop
+		}
+		return
+	}
+	if len(sub.Path) < preLen || !jsonPathHasPrefix(sub.Path, pre.Path[:preLen-1]) {
+		return
+	}
+
+	// Both operations share the container targeted by pre.
+	atTarget := len(sub.Path) == preLen
+
+	preIndex, isList := jsonIndex(pre.Path[preLen-1])
+	if !isList {
+		if !jsonElementEqual(sub.Path[preLen-1], pre.Path[preLen-1]) || pre.Type == JSONAdd {
+			return
+		}
+		if !atTarget || sub.Type == JSONAdd || (pre.Type == JSONDelete && sub.Type != JSONInsert) {
+			sub.Type = JSONNoop
+		}
+		return
+	}
+
+	subIndex, ok := jsonIndex(sub.Path[preLen-1])
+	if !ok {
+		return
+	}
+	subInsert := atTarget && sub.Type == JSONInsert
+	subMove := atTarget && sub.Type == JSONMove
+
+	switch pre.Type {
+	case JSONInsert:
+		if preIndex <= subIndex {
+			subIndex++
+		}
+		if subMove && preIndex <= sub.To {
+			sub.To++
+		}
+	case JSONDelete:
+		if preIndex < subIndex {
+			subIndex--
+		} else if preIndex == subIndex && !subInsert {
+			sub.Type = JSONNoop
+			return
+		}
+		if subMove && preIndex < sub.To {
+			sub.To--
+		}
+	case JSONMove:
+		if subInsert {
+			if preIndex < subIndex {
+				subIndex--
+			}
+			if pre.To < subIndex {
+				subIndex++
+			}
+		} else {
+			subIndex = jsonMoveIndex(subIndex, preIndex, pre.To)
+		}
+		if subMove {
+			sub.To = jsonMoveIndex(sub.To, preIndex, pre.To)
+		}
+	case JSONReplace:
+		if preIndex == subIndex && !subInsert && (!atTarget || sub.Type == JSONAdd) {
+			sub.Type = JSONNoop
+			return
+		}
+	}
+
+	sub.Path[preLen-1] = subIndex
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+applyJSONOperation - Apply a JSON operation to a parsed document, returns the resulting document.
+*/
+func applyJSONOperation(root interface{}, op *JSONOperation) (interface{}, error) {
+	if op == nil {
+		return root, ErrJSONMissingOperation
+	}
+	if op.Type == JSONNoop {
+		return root, nil
+	}
+	if len(op.Path) == 0 {
+		if op.Type != JSONReplace {
+			return root, fmt.Errorf("%v, offender: %v", ErrJSONInvalidPath, *op)
+		}
+		return jsonCopy(op.Value), nil
+	}
+	return applyJSONAt(root, op.Path, op)
+}
+
+/*
+jsonCopy - Deep copies a JSON value. Values of operations are copied before they are placed into a
+document so that later operations on the document never modify a transform already dispatched.
+*/
+func jsonCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			c[key] = jsonCopy(child)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, child := range v {
+			c[i] = jsonCopy(child)
+		}
+		return c
+	}
+	return value
+}
+
+/*
+applyJSONAt - Walks down the path and applies the operation to the final container, containers are
+reassigned on the way back up since lists may be reallocated.
+*/
+func applyJSONAt(node interface{}, path []interface{}, op *JSONOperation) (interface{}, error) {
+	if len(path) > 1 {
+		var child interface{}
+		switch n := node.(type) {
+		case map[string]interface{}:
+			key, ok := path[0].(string)
+			if !ok {
+				return node, fmt.Errorf("%v, offender: %v", ErrJSONInvalidPath, *op)
+			}
+			if child, ok = n[key]; !ok {
+				return node, fmt.Errorf("%v, offender: %v", ErrJSONInvalidPath, *op)
+			}
+			newChild, err := applyJSONAt(child, path[1:], op)
+			if err != nil {
+				return node, err
+			}
+			n[key] = newChild
+		case []interface{}:
+			index, ok := jsonIndex(path[0])
+			if !ok || index < 0 || index >= len(n) {
+				return node, fmt.Errorf("%v, offender: %v", ErrJSONInvalidPath, *op)
+			}
+			newChild, err := applyJSONAt(n[index], path[1:], op)
+			if err != nil {
+				return node, err
+			}
+			n[index] = newChild
+		default:
+			return node, fmt.Errorf("%v, offender: %v", ErrJSONInvalidPath, *op)
+		}
+		return node, nil
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		key, ok := path[0].(string)
+		if !ok {
+			return node, fmt.Errorf("%v, offender: %v", ErrJSONInvalidPath, *op)
+		}
+		switch op.Type {
+		case JSONInsert, JSONReplace:
+			n[key] = jsonCopy(op.Value)
+		case JSONDelete:
+			if _, exists := n[key]; !exists {
+				return node, fmt.Errorf("%v, offender: %v", ErrJSONInvalidPath, *op)
+			}
+			delete(n, key)
+		case JSONAdd:
+			sum, err := jsonAdd(n[key], op.Value)
+			if err != nil {
+				return node, err
+			}
+			n[key] = sum
+		case JSONMove:
+			return node, ErrJSONNotList
+		default:
+			return node, ErrJSONInvalidOperation
+		}
+		return n, nil
+	case []interface{}:
+		index, ok := jsonIndex(path[0])
+		if !ok || index < 0 || index > len(n) || (index == len(n) && op.Type != JSONInsert) {
+			return node, fmt.Errorf("%v, offender: %v", ErrJSONInvalidPath, *op)
+		}
+		switch op.Type {
+		case JSONInsert:
+			n = append(n, nil)
+			copy(n[index+1:], n[index:])
+			n[index] = jsonCopy(op.Value)
+		case JSONReplace:
+			n[index] = jsonCopy(op.Value)
+		case JSONDelete:
+			n = append(n[:index], n[index+1:]...)
+		case JSONAdd:
+			sum, err := jsonAdd(n[index], op.Value)
+			if err != nil {
+				return node, err
+			}
+			n[index] = sum
+		case JSONMove:
+			if op.To < 0 || op.To >= len(n) {
+				return node, fmt.Errorf("%v, offender: %v", ErrJSONInvalidPath, *op)
+			}
+			element := n[index]
+			n = append(n[:index], n[index+1:]...)
+			n = append(n, nil)
+			copy(n[op.To+1:], n[op.To:])
+			n[op.To] = element
+		default:
+			return node, ErrJSONInvalidOperation
+		}
+		return n, nil
+	}
+	return node, fmt.Errorf("%v, offender: %v", ErrJSONInvalidPath, *op)
+}
+
+/*
+jsonAdd - Adds two JSON numbers together.
+*/
+func jsonAdd(target, value interface{}) (interface{}, error) {
+	t, ok := jsonNumber(target)
+	if !ok {
+		return target, ErrJSONNotNumber
+	}
+	v, ok := jsonNumber(value)
+	if !ok {
+		return target, ErrJSONNotNumber
+	}
+	return t + v, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
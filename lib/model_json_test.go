@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func jsonOp(version int, opType string, value interface{}, path ...interface{}) OTransform {
+	return OTransform{
+		Version: version,
+		JSONOp: &JSONOperation{
+			Type:  opType,
+			Path:  path,
+			Value: value,
+		},
+	}
+}
+
+func jsonEqual(t *testing.T, expected, received string) {
+	var exp, rec interface{}
+	if err := json.Unmarshal([]byte(expected), &exp); err != nil {
+		t.Errorf("Failed to parse expected: %v", err)
+		return
+	}
+	if err := json.Unmarshal([]byte(received), &rec); err != nil {
+		t.Errorf("Failed to parse received: %v", err)
+		return
+	}
+	expBytes, _ := json.Marshal(exp)
+	recBytes, _ := json.Marshal(rec)
+	if string(expBytes) != string(recBytes) {
+		t.Errorf("Expected %s, received %s", expBytes, recBytes)
+	}
+}
+
+func TestJSONModelSimpleOperations(t *testing.T) {
+	content := `{"name":"leaps","tags":["a","b","c"],"count":5,"nested":{"deep":true}}`
+
+	model := CreateJSONModel(DefaultModelConfig())
+
+	tforms := []OTransform{
+		jsonOp(2, JSONReplace, "leaps2", "name"),
+		jsonOp(3, JSONInsert, "z", "tags", 1),
+		jsonOp(4, JSONDelete, nil, "nested", "deep"),
+		jsonOp(5, JSONAdd, 2.5, "count"),
+		jsonOp(6, JSONInsert, []interface{}{1, 2}, "list"),
+		jsonOp(7, JSONAdd, 1, "list", 0),
+	}
+	move := jsonOp(8, JSONMove, nil, "tags", 0)
+	move.JSONOp.To = 3
+	tforms = append(tforms, move)
+
+	for _, tform := range tforms {
+		if _, _, err := model.PushTransform(tform); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+	}
+	if _, err := model.FlushTransforms(&content, 60); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+
+	jsonEqual(t, `{"name":"leaps2","tags":["z","b","c","a"],"count":7.5,"nested":{},"list":[2,2]}`, content)
+}
+
+func TestJSONModelConcurrentOperations(t *testing.T) {
+	content := `{"list":["a","b","c","d"],"obj":{"key":{"inner":1}}}`
+
+	model := CreateJSONModel(DefaultModelConfig())
+
+	move := jsonOp(5, JSONMove, nil, "list", 0)
+	move.JSONOp.To = 3
+
+	tforms := []OTransform{
+		jsonOp(2, JSONInsert, "x", "list", 0),
+		// Unaware of the insert, targets "c"
+		jsonOp(2, JSONReplace, "C", "list", 2),
+		// Unaware of both, deletes "a"
+		jsonOp(2, JSONDelete, nil, "list", 0),
+		jsonOp(5, JSONDelete, nil, "obj", "key"),
+		// Unaware of the deletion of "key"
+		jsonOp(5, JSONAdd, 1, "obj", "key", "inner"),
+		// Moves "x" to the end of the list
+		move,
+		// Unaware of the move, targets "C"
+		jsonOp(7, JSONReplace, "CC", "list", 2),
+	}
+	expected := []OTransform{
+		jsonOp(2, JSONInsert, "x", "list", 0),
+		jsonOp(3, JSONReplace, "C", "list", 3),
+		jsonOp(4, JSONDelete, nil, "list", 1),
+		jsonOp(5, JSONDelete, nil, "obj", "key"),
+		jsonOp(6, JSONNoop, 1, "obj", "key", "inner"),
+		move,
+		jsonOp(8, JSONReplace, "CC", "list", 1),
+	}
+
+	for i, tform := range tforms {
+		corrected, _, err := model.PushTransform(tform)
+		if err != nil {
+			t.Errorf("Error: %v", err)
+			continue
+		}
+		exp := expected[i]
+		if i != 5 {
+			expBytes, _ := json.Marshal(exp.JSONOp)
+			recBytes, _ := json.Marshal(corrected.JSONOp)
+			if string(expBytes) != string(recBytes) {
+				t.Errorf("Wrong correction %v: %s != %s", i, expBytes, recBytes)
+			}
+		}
+	}
+	if _, err := model.FlushTransforms(&content, 60); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+
+	jsonEqual(t, `{"list":["b","CC","d","x"],"obj":{}}`, content)
+}
+
+func TestJSONModelErrors(t *testing.T) {
+	config := DefaultModelConfig()
+	config.MaxTransformLength = 10
+
+	model := CreateJSONModel(config)
+
+	if _, _, err := model.PushTransform(OTransform{Version: 2}); err != ErrJSONMissingOperation {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, _, err := model.PushTransform(jsonOp(2, "nope", nil, "a")); err != ErrJSONInvalidOperation {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, _, err := model.PushTransform(jsonOp(2, JSONInsert, "this is too long", "a")); err != ErrTransformTooLong {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, _, err := model.PushTransform(jsonOp(2, JSONAdd, 1, "a")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	content := `{"a":"not a number"}`
+	if _, err := model.FlushTransforms(&content, 60); err != ErrJSONNotNumber {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
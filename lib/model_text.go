@@ -23,9 +23,9 @@ THE SOFTWARE.
 package lib
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 )
 
@@ -34,21 +34,39 @@ import (
 
 // Errors for the internal Operational Transform model.
 var (
-	ErrTransformNegDelete = errors.New("transform contained negative delete")
-	ErrTransformTooLong   = errors.New("transform insert length exceeded the limit")
-	ErrTransformTooOld    = errors.New("transform diff greater than transform archive")
+	ErrTransformNegDelete       = errors.New("transform contained negative delete")
+	ErrTransformTooLong         = errors.New("transform insert length exceeded the limit")
+	ErrTransformTooOld          = errors.New("transform diff greater than transform archive")
+	ErrTransformUnapplied       = errors.New("transform has not yet been applied")
+	ErrTransformOverlap         = errors.New("compound transform contained overlapping operations")
+	ErrTransformCompound        = errors.New("compound transform contained a top level edit")
+	ErrTransformSplitsCharacter = errors.New("transform position or deletion landed within a character")
 )
 
 /*
 OTransform - A representation of a transformation relating to a leap document. This can either be a
-text addition, a text deletion, or both.
+text addition, a text deletion, or both. Transforms of JSON documents instead carry a JSONOperation.
+
+A compound transform carries a list of Operations instead of a single edit, which are applied
+atomically under a single version. Each operation is relative to the same version of the document,
+as if the others had not been applied, and the operations must not overlap. Only the position,
+delete and insert fields of operations are used.
+
+Transforms sent out by the binder of a text document carry the checksum of the document content
+once the transform is applied, which clients can use to detect that their content has diverged.
+Consecutive transforms sent out to a client may be coalesced into one, in which case the Span is the
+number of versions covered and the Version is the last of them. A Span of zero covers one version.
 */
 type OTransform struct {
-	Position  int    `json:"position" yaml:"position"`
-	Delete    int    `json:"num_delete" yaml:"num_delete"`
-	Insert    string `json:"insert" yaml:"insert"`
-	Version   int    `json:"version" yaml:"version"`
-	TReceived int64  `json:"received,omitempty" yaml:"received,omitempty"`
+	Position   int            `json:"position" yaml:"position"`
+	Delete     int            `json:"num_delete" yaml:"num_delete"`
+	Insert     string         `json:"insert" yaml:"insert"`
+	Operations []OTransform   `json:"operations,omitempty" yaml:"operations,omitempty"`
+	JSONOp     *JSONOperation `json:"json_op,omitempty" yaml:"json_op,omitempty"`
+	Version    int            `json:"version" yaml:"version"`
+	TReceived  int64          `json:"received,omitempty" yaml:"received,omitempty"`
+	Checksum   uint32         `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+	Span       int            `json:"span,omitempty" yaml:"span,omitempty"`
 }
 
 /*
@@ -57,20 +75,33 @@ of changes submitted and recently applied in order to distribute those changes t
 */
 type OModel struct {
 	config    ModelConfig
+	configErr error
 	Version   int
 	Applied   []OTransform
 	Unapplied []OTransform
+
+	// The text removed by each operation of applied transforms, keyed by version, used for
+	// inverting transforms.
+	deleted map[int][]string
+
+	// Optional journal of flushed transforms, used for rebasing transforms older than those
+	// retained.
+	journal TransformJournal
 }
 
 /*
-CreateTextModel - Returns a fresh transform model, with the version set to 1.
+CreateTextModel - Returns a fresh transform model, with the version set to 1. A model created with an
+unknown position unit rejects every transform with ErrUnknownPositionUnit, NewModel rejects such a
+config up front.
 */
 func CreateTextModel(config ModelConfig) Model {
 	return &OModel{
 		config:    config,
+		configErr: config.validate(),
 		Version:   1,
 		Applied:   []OTransform{},
 		Unapplied: []OTransform{},
+		deleted:   map[int][]string{},
 	}
 }
 
@@ -83,11 +114,48 @@ the document. Whilst doing so it fixes the transform in relation to earlier tran
 unaware of, this fixed version gets sent back for distributing across other clients.
 */
 func (m *OModel) PushTransform(ot OTransform) (OTransform, int, error) {
-	if ot.Delete < 0 {
-		return OTransform{}, 0, ErrTransformNegDelete
+	ot, err := m.RebaseTransform(ot)
+	if err != nil {
+		return OTransform{}, 0, err
+	}
+
+	m.Version++
+
+	ot.Version = m.Version
+	ot.TReceived = time.Now().Unix()
+
+	m.Unapplied = append(m.Unapplied, ot)
+
+	return ot, m.Version, nil
+}
+
+/*
+RebaseTransform - Validates a transform and fixes it in relation to earlier transforms it was unaware
+of, without pushing it to the model. The returned transform is relative to the current version.
+*/
+func (m *OModel) RebaseTransform(ot OTransform) (OTransform, error) {
+	if m.configErr != nil {
+		return OTransform{}, m.configErr
+	}
+	if len(ot.Operations) > 0 {
+		if ot.Delete != 0 || len(ot.Insert) > 0 {
+			return OTransform{}, ErrTransformCompound
+		}
+		// The operations are shared with the submitter, so we modify a copy.
+		ot.Operations = append([]OTransform{}, ot.Operations...)
+		if err := normaliseOperations(ot.Operations); err != nil {
+			return OTransform{}, err
+		}
+	}
+	insertLength := 0
+	for _, op := range transformOperations(&ot) {
+		if op.Delete < 0 {
+			return OTransform{}, ErrTransformNegDelete
+		}
+		insertLength += unitLength(m.config.PositionUnit, op.Insert)
 	}
-	if uint64(len(ot.Insert)) > m.config.MaxTransformLength {
-		return OTransform{}, 0, ErrTransformTooLong
+	if uint64(insertLength) > m.config.MaxTransformLength {
+		return OTransform{}, ErrTransformTooLong
 	}
 
 	lenApplied, lenUnapplied := len(m.Applied), len(m.Unapplied)
@@ -95,30 +163,62 @@ func (m *OModel) PushTransform(ot OTransform) (OTransform, int, error) {
 	diff := (m.Version + 1) - ot.Version
 
 	if diff > lenApplied+lenUnapplied {
-		return OTransform{}, 0, ErrTransformTooOld
+		if err := m.rebaseJournal(&ot, diff-(lenApplied+lenUnapplied)); err != nil {
+			return OTransform{}, err
+		}
+		diff = lenApplied + lenUnapplied
 	}
 	if diff < 0 {
-		return OTransform{}, 0, fmt.Errorf(
+		return OTransform{}, fmt.Errorf(
 			"transform version %v greater than expected doc version (%v), offender: %v",
 			ot.Version, (m.Version + 1), ot)
 	}
 
 	for j := lenApplied - (diff - lenUnapplied); j < lenApplied; j++ {
-		updateTransform(&ot, &m.Applied[j])
+		rebaseTransform(&ot, &m.Applied[j], m.config.PositionUnit)
 		diff--
 	}
 	for j := lenUnapplied - diff; j < lenUnapplied; j++ {
-		updateTransform(&ot, &m.Unapplied[j])
+		rebaseTransform(&ot, &m.Unapplied[j], m.config.PositionUnit)
 	}
+	return ot, nil
+}
 
-	m.Version++
-
-	ot.Version = m.Version
-	ot.TReceived = time.Now().Unix()
-
-	m.Unapplied = append(m.Unapplied, ot)
+/*
+rebaseJournal - Rebases a transform against the transforms of the journal that are no longer retained
+in memory, of which there are 'missed' many preceeding the oldest retained transform. Returns
+ErrTransformTooOld if there is no journal or it does not hold every missed transform.
+*/
+func (m *OModel) rebaseJournal(ot *OTransform, missed int) error {
+	if m.journal == nil {
+		return ErrTransformTooOld
+	}
+	from := m.Version - len(m.Applied) - len(m.Unapplied) + 1 - missed
+	transforms, err := m.journal.ReadTransforms(from, from+missed)
+	if err != nil {
+		return err
+	}
+	if len(transforms) != missed {
+		return ErrTransformTooOld
+	}
+	for i := range transforms {
+		if transforms[i].Version != from+i {
+			return ErrTransformTooOld
+		}
+		rebaseTransform(ot, &transforms[i], m.config.PositionUnit)
+	}
+	return nil
+}
 
-	return ot, m.Version, nil
+/*
+SetJournal - Sets a journal for rebasing transforms that are older than those retained, the model
+continues from the version of the last transform of the journal.
+*/
+func (m *OModel) SetJournal(journal TransformJournal, version int) {
+	m.journal = journal
+	if version > m.Version {
+		m.Version = version
+	}
 }
 
 /*--------------------------------------------------------------------------------------------------
@@ -138,38 +238,127 @@ func (m *OModel) GetVersion() int {
 	return m.Version
 }
 
+/*
+InvertTransform - returns a transform that reverts an applied transform of a particular version, the
+transform must have been flushed and not yet removed in accordance with the retention period.
+*/
+func (m *OModel) InvertTransform(version int) (OTransform, error) {
+	for _, ot := range m.Unapplied {
+		if ot.Version == version {
+			return OTransform{}, ErrTransformUnapplied
+		}
+	}
+	for i := range m.Applied {
+		if m.Applied[i].Version == version {
+			return m.invertTransform(&m.Applied[i], m.deleted[version]), nil
+		}
+	}
+	return OTransform{}, ErrTransformTooOld
+}
+
+/*
+InvertUnappliedTransform - returns a transform that reverts a transform of a particular version
+whether or not it has been flushed. The text removed by an unapplied transform is found by applying
+the unapplied transforms up to and including it to a copy of content, which must be the content
+after all applied transforms, the content itself is left untouched.
+*/
+func (m *OModel) InvertUnappliedTransform(version int, content *Rope) (OTransform, error) {
+	working := *content
+	for i := range m.Unapplied {
+		ops := transformOperations(&m.Unapplied[i])
+		deleted := make([]string, len(ops))
+		for j := range ops {
+			var err error
+			if deleted[j], err = applyOperation(&working, &ops[j], m.config.PositionUnit); err != nil {
+				return OTransform{}, err
+			}
+		}
+		if m.Unapplied[i].Version == version {
+			return m.invertTransform(&m.Unapplied[i], deleted), nil
+		}
+	}
+	return m.InvertTransform(version)
+}
+
+/*
+invertTransform - returns a transform that reverts a transform given the text removed by each of
+its operations, versioned as if it were submitted directly after the transform.
+*/
+func (m *OModel) invertTransform(ot *OTransform, deleted []string) OTransform {
+	ops := transformOperations(ot)
+
+	/* Operations are ordered by descending position, so each inverse is shifted by the change in
+	 * length caused by all of the following operations.
+	 */
+	inverse := make([]OTransform, len(ops))
+	shift := 0
+	for j := len(ops) - 1; j >= 0; j-- {
+		insertLength := unitLength(m.config.PositionUnit, ops[j].Insert)
+		inverse[j] = OTransform{
+			Position: ops[j].Position + shift,
+			Delete:   insertLength,
+		}
+		if j < len(deleted) {
+			inverse[j].Insert = deleted[j]
+		}
+		shift += insertLength - ops[j].Delete
+	}
+	if len(ot.Operations) == 0 {
+		inverse[0].Version = ot.Version + 1
+		return inverse[0]
+	}
+	return OTransform{
+		Operations: inverse,
+		Version:    ot.Version + 1,
+	}
+}
+
 /*
 FlushTransforms - apply all unapplied transforms and append them to the applied stack, then remove
 old entries from the applied stack. Accepts retention as an indicator for how many seconds applied
 transforms should be retained. Returns a bool indicating whether any changes were applied.
 */
 func (m *OModel) FlushTransforms(content *string, secondsRetention int64) (bool, error) {
+	rope := NewRope(*content)
+	changed, err := m.FlushTransformsToRope(rope, secondsRetention)
+	if changed {
+		*content = rope.String()
+	}
+	return changed, err
+}
+
+/*
+FlushTransformsToRope - apply all unapplied transforms directly to a rope, the cost of which is in
+proportion to the size of the transforms rather than the size of the document. Otherwise behaves
+the same as FlushTransforms.
+*/
+func (m *OModel) FlushTransformsToRope(content *Rope, secondsRetention int64) (bool, error) {
 	transforms := m.Unapplied[:]
 	m.Unapplied = []OTransform{}
 
-	lenContent := len(*content)
-
-	runeContent := bytes.Runes([]byte(*content))
-
 	var i, j int
 	var err error
 	for i = 0; i < len(transforms); i++ {
-		lenContent += (len(transforms[i].Insert) - transforms[i].Delete)
-		if uint64(lenContent) > m.config.MaxDocumentSize {
-			return i > 0, ErrTransformTooLong
-		}
-		if err = m.applyTransform(&runeContent, &transforms[i]); err != nil {
+		working := *content
+		if err = m.applyTransform(&working, &transforms[i]); err != nil {
 			break
 		}
+		// The size limit is counted in bytes whereas deletions are counted in the position unit, so
+		// the size is only known once the transform is applied, and the result is discarded if it
+		// is too large.
+		if uint64(working.Size()) > m.config.MaxDocumentSize {
+			delete(m.deleted, transforms[i].Version)
+			return i > 0, ErrTransformTooLong
+		}
+		*content = working
 	}
 
-	*content = string(runeContent)
-
 	upto := time.Now().Unix() - secondsRetention
 	for j = 0; j < len(m.Applied); j++ {
 		if m.Applied[j].TReceived > upto {
 			break
 		}
+		delete(m.deleted, m.Applied[j].Version)
 	}
 
 	applied := m.Applied[j:]
@@ -195,6 +384,64 @@ func intMax(left, right int) int {
 	return right
 }
 
+/*
+transformOperations - Returns the operations of a transform, which is either the list of operations
+of a compound transform or the transform itself.
+*/
+func transformOperations(ot *OTransform) []OTransform {
+	if len(ot.Operations) > 0 {
+		return ot.Operations
+	}
+	return []OTransform{*ot}
+}
+
+/*
+operationsByPosition - Sorts the operations of a compound transform by descending position.
+*/
+type operationsByPosition []OTransform
+
+func (o operationsByPosition) Len() int           { return len(o) }
+func (o operationsByPosition) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o operationsByPosition) Less(i, j int) bool { return o[i].Position > o[j].Position }
+
+/*
+normaliseOperations - Sorts the operations of a compound transform by descending position and
+checks that none of them overlap. Since each operation only affects content after its position the
+sorted operations can then be treated as a sequence, where each is relative to the document after
+the preceeding operations were applied.
+*/
+func normaliseOperations(ops []OTransform) error {
+	sort.Stable(operationsByPosition(ops))
+	for i := 1; i < len(ops); i++ {
+		if ops[i].Position+ops[i].Delete > ops[i-1].Position {
+			return ErrTransformOverlap
+		}
+	}
+	return nil
+}
+
+/*
+rebaseTransform - Updates the transform 'sub' in relation to the earlier transform 'pre' that it
+was unaware of, where either transform can be compound. The operations of 'pre' are a sequence and
+are applied to 'sub' in order, whereas the operations of 'sub' are each independently relative to
+the same version of the document. Lengths of inserts are counted in the given position unit.
+*/
+func rebaseTransform(sub *OTransform, pre *OTransform, unit string) {
+	preOps := transformOperations(pre)
+	if len(sub.Operations) == 0 {
+		for i := range preOps {
+			updateTransform(sub, &preOps[i], unit)
+		}
+		return
+	}
+	for i := range preOps {
+		for j := range sub.Operations {
+			updateTransform(&sub.Operations[j], &preOps[i], unit)
+		}
+	}
+	sort.Stable(operationsByPosition(sub.Operations))
+}
+
 /*
 updateTransform - When a transform is speculative it potentially has missed transforms that are
 already applied. This method retroactively modifies these transforms in relation to the missed
@@ -206,9 +453,8 @@ regard to an earlier transform.
 The transform 'pre' is the preceeding transform that should be used to alter 'sub' to preserve
 its originally intended change.
 */
-func updateTransform(sub *OTransform, pre *OTransform) {
-	subInsert, preInsert := bytes.Runes([]byte(sub.Insert)), bytes.Runes([]byte(pre.Insert))
-	subLength, preLength := len(subInsert), len(preInsert)
+func updateTransform(sub *OTransform, pre *OTransform, unit string) {
+	preLength := unitLength(unit, pre.Insert)
 
 	if pre.Position <= sub.Position {
 		if preLength > 0 && pre.Delete == 0 {
@@ -226,12 +472,7 @@ func updateTransform(sub *OTransform, pre *OTransform) {
 
 		if excess > pre.Delete {
 			sub.Delete += (preLength - pre.Delete)
-
-			newInsert := make([]rune, subLength+preLength)
-			copy(newInsert[:], subInsert)
-			copy(newInsert[subLength:], preInsert)
-
-			sub.Insert = string(newInsert)
+			sub.Insert = sub.Insert + pre.Insert
 		} else {
 			sub.Delete = posGap
 		}
@@ -239,31 +480,220 @@ func updateTransform(sub *OTransform, pre *OTransform) {
 }
 
 /*
-applyTransform - Apply a specific transform to some content.
+composeSegment - A segment of content during the composition of transforms, which is either a range
+of the original content, open ended when the length is negative, or inserted text.
 */
-func (m *OModel) applyTransform(content *[]rune, ot *OTransform) error {
-	if ot.Delete < 0 {
-		return ErrTransformNegDelete
+type composeSegment struct {
+	start    int
+	length   int
+	insert   string
+	inserted bool
+}
+
+/*
+composeTransforms - Returns a single transform with the same effect as applying the text transform
+'first' followed by the text transform 'second', which must be of the version directly after it.
+The result covers the versions of both transforms and carries the checksum of the second.
+*/
+func composeTransforms(first, second OTransform, unit string) OTransform {
+	firstOps := append([]OTransform(nil), transformOperations(&first)...)
+	sort.Sort(sort.Reverse(operationsByPosition(firstOps)))
+
+	// Describe the content after the first transform as segments of the original content.
+	segments, cursor := []composeSegment{}, 0
+	for _, op := range firstOps {
+		if op.Position > cursor {
+			segments = append(segments, composeSegment{start: cursor, length: op.Position - cursor})
+		}
+		if len(op.Insert) > 0 {
+			segments = append(segments, composeSegment{insert: op.Insert, inserted: true})
+		}
+		cursor = op.Position + op.Delete
 	}
-	if ot.Position+ot.Delete > len(*content) {
-		return fmt.Errorf(
-			"transform position (%v) and deletion (%v) surpassed document content length (%v), offender: %v",
-			ot.Position, ot.Delete, len(*content), *ot)
+	segments = append(segments, composeSegment{start: cursor, length: -1})
+
+	// Operations of the second transform are applied in descending order, so that each remains
+	// relative to the content after the first transform.
+	for _, op := range transformOperations(&second) {
+		var from, to int
+		segments, from = splitSegments(segments, op.Position, unit)
+		segments, to = splitSegments(segments, op.Position+op.Delete, unit)
+		replaced := []composeSegment{}
+		if len(op.Insert) > 0 {
+			replaced = append(replaced, composeSegment{insert: op.Insert, inserted: true})
+		}
+		segments = append(segments[:from], append(replaced, segments[to:]...)...)
+	}
+
+	// The gaps between the remaining ranges of the original content are the composed operations.
+	ops, cursor, pending := []OTransform{}, 0, ""
+	for _, seg := range segments {
+		if seg.inserted {
+			pending += seg.insert
+			continue
+		}
+		if seg.length == 0 {
+			continue
+		}
+		if seg.start > cursor || len(pending) > 0 {
+			ops = append(ops, OTransform{Position: cursor, Delete: seg.start - cursor, Insert: pending})
+		}
+		cursor, pending = seg.start+seg.length, ""
+	}
+	sort.Sort(operationsByPosition(ops))
+
+	composed := OTransform{
+		Version:   second.Version,
+		TReceived: second.TReceived,
+		Checksum:  second.Checksum,
+		Span:      transformSpan(&first) + transformSpan(&second),
+	}
+	switch len(ops) {
+	case 0:
+	case 1:
+		composed.Position, composed.Delete, composed.Insert = ops[0].Position, ops[0].Delete, ops[0].Insert
+	default:
+		composed.Operations = ops
 	}
+	return composed
+}
 
-	start := (*content)[:ot.Position]
-	middle := bytes.Runes([]byte(ot.Insert))
-	end := (*content)[ot.Position+ot.Delete:]
+/*
+splitSegments - Splits the segments of a composition so that a segment begins at a position, which is
+counted in a position unit, and returns the segments along with the index of that segment.
+*/
+func splitSegments(segments []composeSegment, position int, unit string) ([]composeSegment, int) {
+	offset := 0
+	for i, seg := range segments {
+		length := seg.length
+		if seg.inserted {
+			length = unitLength(unit, seg.insert)
+		}
+		if position == offset {
+			return segments, i
+		}
+		if length >= 0 && position >= offset+length {
+			offset += length
+			continue
+		}
+		var left, right composeSegment
+		if seg.inserted {
+			head, tail := unitSplit(unit, seg.insert, position-offset)
+			left = composeSegment{insert: head, inserted: true}
+			right = composeSegment{insert: tail, inserted: true}
+		} else {
+			left = composeSegment{start: seg.start, length: position - offset}
+			right = composeSegment{start: seg.start + position - offset, length: -1}
+			if length >= 0 {
+				right.length = length - left.length
+			}
+		}
+		split := append([]composeSegment{}, segments[:i]...)
+		split = append(split, left, right)
+		return append(split, segments[i+1:]...), i + 1
+	}
+	return segments, len(segments)
+}
 
-	startLen, middleLen, endLen := len(start), len(middle), len(end)
+/*
+MergeTransforms - Merges consecutive transforms where one is an insert adjacent to or within the text
+inserted by the other, or where both are deletions of adjacent content, such as when typing or
+deleting characters one at a time. Merged transforms cover the versions of the transforms they
+replace. Positions are counted in the given position unit.
+*/
+func MergeTransforms(transforms []OTransform, unit string) []OTransform {
+	merged := make([]OTransform, 0, len(transforms))
+	for _, ot := range transforms {
+		if n := len(merged); n > 0 && mergeTransform(&merged[n-1], ot, unit) {
+			continue
+		}
+		merged = append(merged, ot)
+	}
+	return merged
+}
 
-	(*content) = make([]rune, startLen+middleLen+endLen)
-	copy(*content, start)
-	copy((*content)[startLen:], middle)
-	copy((*content)[startLen+middleLen:], end)
+/*
+mergeTransform - Merges the transform 'next' into the transform 'prev' that directly preceeds it,
+returns false if they cannot be merged.
+*/
+func mergeTransform(prev *OTransform, next OTransform, unit string) bool {
+	if next.Version-transformSpan(&next) != prev.Version {
+		return false
+	}
+	for _, ot := range []*OTransform{prev, &next} {
+		if len(ot.Operations) > 0 || ot.JSONOp != nil {
+			return false
+		}
+	}
+	switch {
+	case prev.Delete == 0 && next.Delete == 0 && len(prev.Insert) > 0 && len(next.Insert) > 0:
+		offset := next.Position - prev.Position
+		if offset < 0 || offset > unitLength(unit, prev.Insert) {
+			return false
+		}
+		head, tail := unitSplit(unit, prev.Insert, offset)
+		prev.Insert = head + next.Insert + tail
+	case len(prev.Insert) == 0 && len(next.Insert) == 0 && prev.Delete > 0 && next.Delete > 0:
+		if next.Position+next.Delete == prev.Position {
+			prev.Position = next.Position
+		} else if next.Position != prev.Position {
+			return false
+		}
+		prev.Delete += next.Delete
+	default:
+		return false
+	}
+	prev.Span = transformSpan(prev) + transformSpan(&next)
+	prev.Version, prev.Checksum, prev.TReceived = next.Version, next.Checksum, next.TReceived
+	return true
+}
+
+/*
+transformSpan - Returns the number of versions covered by a transform.
+*/
+func transformSpan(ot *OTransform) int {
+	if ot.Span > 1 {
+		return ot.Span
+	}
+	return 1
+}
 
+/*
+applyTransform - Apply a specific transform to some content, the text removed by the transform is
+recorded for inverting it later. The operations of a compound transform are applied to a copy of the
+content, which replaces the content only once every operation succeeded, and since ropes are never
+modified in place the copy is free.
+*/
+func (m *OModel) applyTransform(content *Rope, ot *OTransform) error {
+	working := *content
+	ops := transformOperations(ot)
+	deleted := make([]string, len(ops))
+	for i := range ops {
+		var err error
+		if deleted[i], err = applyOperation(&working, &ops[i], m.config.PositionUnit); err != nil {
+			return err
+		}
+	}
+	*content = working
+	m.deleted[ot.Version] = deleted
 	return nil
 }
 
+/*
+applyOperation - Apply a single edit to some content, where the position and deletion are counted in
+a position unit, returns the text that was removed.
+*/
+func applyOperation(content *Rope, ot *OTransform, unit string) (string, error) {
+	if ot.Delete < 0 {
+		return "", ErrTransformNegDelete
+	}
+	if length := content.Length(unit); ot.Position+ot.Delete > length {
+		return "", fmt.Errorf(
+			"transform position (%v) and deletion (%v) surpassed document content length (%v), offender: %v",
+			ot.Position, ot.Delete, length, *ot)
+	}
+	return content.SpliceUnits(unit, ot.Position, ot.Delete, ot.Insert)
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
@@ -26,6 +26,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"testing"
 
 	"github.com/jeffail/leaps/lib/store"
@@ -261,3 +262,580 @@ func TestLimits(t *testing.T) {
 		t.Errorf("Expected failed flush")
 	}
 }
+
+func TestTextModelDocumentSizeUnits(t *testing.T) {
+	for _, unit := range []string{PositionCodePoints, PositionUTF16, PositionBytes} {
+		config := DefaultModelConfig()
+		config.PositionUnit = unit
+		config.MaxDocumentSize = 10
+
+		model := CreateTextModel(config)
+		content := NewRope("😀😀")
+
+		// Replaces 4 bytes with 6, leaving exactly the limit.
+		if _, _, err := model.PushTransform(OTransform{
+			Version: 2, Position: 0, Delete: unitLength(unit, "😀"), Insert: "abcdef",
+		}); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+		if _, err := model.(RopeModel).FlushTransformsToRope(content, 60); err != nil {
+			t.Errorf("Legit flush error for %v: %v", unit, err)
+		}
+
+		if _, _, err := model.PushTransform(OTransform{Version: 3, Position: 0, Insert: "x"}); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+		if _, err := model.(RopeModel).FlushTransformsToRope(content, 60); err != ErrTransformTooLong {
+			t.Errorf("Expected too long error for %v, received: %v", unit, err)
+		}
+		if act := content.String(); act != "abcdef😀" {
+			t.Errorf("Wrong content for %v: %v", unit, act)
+		}
+	}
+}
+
+func TestTextModelInvertTransform(t *testing.T) {
+	content := "hello world"
+
+	model := CreateTextModel(DefaultModelConfig())
+	invertible, ok := model.(InvertibleModel)
+	if !ok {
+		t.Errorf("Text model is not invertible")
+		return
+	}
+
+	if _, _, err := model.PushTransform(OTransform{
+		Version:  2,
+		Position: 6,
+		Delete:   5,
+		Insert:   "我的朋友",
+	}); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if _, err := invertible.InvertTransform(2); err != ErrTransformUnapplied {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := model.FlushTransforms(&content, 60); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+	if _, _, err := model.PushTransform(OTransform{
+		Version:  3,
+		Position: 0,
+		Insert:   "oh, ",
+	}); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	inverse, err := invertible.InvertTransform(2)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if exp := (OTransform{Version: 3, Position: 6, Delete: 4, Insert: "world"}); inverse.Version != exp.Version ||
+		inverse.Position != exp.Position || inverse.Delete != exp.Delete || inverse.Insert != exp.Insert {
+		t.Errorf("Wrong inverse: %v != %v", inverse, exp)
+	}
+	if _, _, err = model.PushTransform(inverse); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if _, err = model.FlushTransforms(&content, 60); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+	if exp := "oh, hello world"; content != exp {
+		t.Errorf("Expected %v, received %v", exp, content)
+	}
+
+	if _, err = invertible.InvertTransform(100); err != ErrTransformTooOld {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestTextModelInvertUnappliedTransform(t *testing.T) {
+	content := "hello world"
+	rope := NewRope(content)
+
+	model := CreateTextModel(DefaultModelConfig())
+	invertible := model.(InvertibleModel)
+
+	for _, tform := range []OTransform{
+		{Version: 2, Position: 6, Delete: 5, Insert: "friend"},
+		{Version: 3, Position: 0, Insert: "oh, "},
+	} {
+		if _, _, err := model.PushTransform(tform); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+	}
+
+	inverse, err := invertible.InvertUnappliedTransform(2, rope)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if inverse.Position != 6 || inverse.Delete != 6 || inverse.Insert != "world" || inverse.Version != 3 {
+		t.Errorf("Wrong inverse: %v", inverse)
+	}
+	if rope.String() != content {
+		t.Errorf("Content was modified: %v", rope.String())
+	}
+	if _, _, err = model.PushTransform(inverse); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if _, err = model.FlushTransforms(&content, 60); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+	if exp := "oh, hello world"; content != exp {
+		t.Errorf("Expected %v, received %v", exp, content)
+	}
+
+	if _, err = invertible.InvertUnappliedTransform(100, NewRope(content)); err != ErrTransformTooOld {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestTextModelCompoundTransforms(t *testing.T) {
+	content := "hello world"
+
+	model := CreateTextModel(DefaultModelConfig())
+
+	if _, _, err := model.PushTransform(OTransform{
+		Version:  2,
+		Position: 5,
+		Insert:   ",",
+	}); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	compound := OTransform{
+		Version: 2,
+		Operations: []OTransform{
+			{Position: 0, Insert: "A"},
+			{Position: 6, Delete: 5, Insert: "there"},
+		},
+	}
+	corrected, version, err := model.PushTransform(compound)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if version != 3 || len(corrected.Operations) != 2 ||
+		corrected.Operations[0].Position != 7 || corrected.Operations[1].Position != 0 {
+		t.Errorf("Wrong corrected compound transform: %v", corrected)
+	}
+	if compound.Operations[1].Position != 6 {
+		t.Errorf("Submitted compound transform was modified: %v", compound)
+	}
+
+	if _, err = model.FlushTransforms(&content, 60); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+	if exp := "Ahello, there"; content != exp {
+		t.Errorf("Expected %v, received %v", exp, content)
+	}
+
+	inverse, err := model.(InvertibleModel).InvertTransform(3)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if _, _, err = model.PushTransform(inverse); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if _, err = model.FlushTransforms(&content, 60); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+	if exp := "hello, world"; content != exp {
+		t.Errorf("Expected %v, received %v", exp, content)
+	}
+
+	if _, _, err = model.PushTransform(OTransform{
+		Version: model.GetVersion() + 1,
+		Operations: []OTransform{
+			{Position: 0, Delete: 3},
+			{Position: 2, Insert: "overlap"},
+		},
+	}); err != ErrTransformOverlap {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, _, err = model.PushTransform(OTransform{
+		Version:    model.GetVersion() + 1,
+		Insert:     "top level",
+		Operations: []OTransform{{Position: 0, Insert: "op"}},
+	}); err != ErrTransformCompound {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestTextModelPositionUnits(t *testing.T) {
+	type unitTest struct {
+		unit    string
+		content string
+		tforms  []OTransform
+		result  string
+	}
+
+	// Emoji is a surrogate pair in UTF-16, and the acute accent is a combining character.
+	tests := []unitTest{
+		{PositionCodePoints, "a😀e\u0301b", []OTransform{
+			{Position: 1, Delete: 1, Insert: "x"},
+			{Position: 3, Delete: 1},
+		}, "axeb"},
+		{PositionUTF16, "a😀e\u0301b", []OTransform{
+			{Position: 1, Delete: 2, Insert: "x"},
+			{Position: 3, Delete: 1},
+		}, "axeb"},
+		{PositionBytes, "a😀e\u0301b", []OTransform{
+			{Position: 1, Delete: 4, Insert: "x"},
+			{Position: 3, Delete: 2},
+		}, "axeb"},
+		{PositionUTF16, "😀😀", []OTransform{
+			{Position: 4, Insert: "👍"},
+			{Position: 6, Insert: "!"},
+			{Position: 0, Delete: 2},
+		}, "😀👍!"},
+	}
+
+	for _, test := range tests {
+		config := DefaultModelConfig()
+		config.PositionUnit = test.unit
+
+		model, err := NewModel(TextDocumentType, config)
+		if err != nil {
+			t.Errorf("Error: %v", err)
+			continue
+		}
+		content := test.content
+		for _, tform := range test.tforms {
+			tform.Version = model.GetVersion() + 1
+			if _, _, err = model.PushTransform(tform); err != nil {
+				t.Errorf("Error: %v", err)
+			}
+			if _, err = model.FlushTransforms(&content, 60); err != nil {
+				t.Errorf("Error flushing %v: %v", test.unit, err)
+			}
+		}
+		if content != test.result {
+			t.Errorf("Wrong result for %v: %v != %v", test.unit, test.result, content)
+		}
+	}
+}
+
+func TestTextModelSplitCharacters(t *testing.T) {
+	for _, unit := range []string{PositionUTF16, PositionBytes} {
+		config := DefaultModelConfig()
+		config.PositionUnit = unit
+
+		model := CreateTextModel(config)
+		content := "a😀b"
+
+		if _, _, err := model.PushTransform(OTransform{Version: 2, Position: 2, Insert: "x"}); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+		if _, err := model.FlushTransforms(&content, 60); err != ErrTransformSplitsCharacter {
+			t.Errorf("Expected split character error for %v, received: %v", unit, err)
+		}
+		if content != "a😀b" {
+			t.Errorf("Content was modified by failed transform: %v", content)
+		}
+
+		if _, _, err := model.PushTransform(OTransform{Version: 3, Position: 1, Delete: 1}); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+		if _, err := model.FlushTransforms(&content, 60); err != ErrTransformSplitsCharacter {
+			t.Errorf("Expected split character error for %v, received: %v", unit, err)
+		}
+	}
+}
+
+func TestTextModelAtomicCompoundTransforms(t *testing.T) {
+	config := DefaultModelConfig()
+	config.PositionUnit = PositionUTF16
+
+	model := CreateTextModel(config)
+	content := NewRope("a😀b")
+
+	// The first operation applied is valid, the second splits a character.
+	if _, _, err := model.PushTransform(OTransform{Version: 2, Operations: []OTransform{
+		{Position: 4, Insert: "!"},
+		{Position: 2, Insert: "x"},
+	}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if _, err := model.(RopeModel).FlushTransformsToRope(content, 60); err != ErrTransformSplitsCharacter {
+		t.Errorf("Expected split character error, received: %v", err)
+	}
+	if act := content.String(); act != "a😀b" {
+		t.Errorf("Content was partly modified by failed transform: %v", act)
+	}
+}
+
+func TestTextModelUnitRebasing(t *testing.T) {
+	config := DefaultModelConfig()
+	config.PositionUnit = PositionUTF16
+
+	model := CreateTextModel(config)
+	content := "hello"
+
+	if _, _, err := model.PushTransform(OTransform{Version: 2, Position: 0, Insert: "😀"}); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	// Submitted without knowledge of the emoji, so must be shifted by two code units.
+	tform, _, err := model.PushTransform(OTransform{Version: 2, Position: 5, Insert: "!"})
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if tform.Position != 7 {
+		t.Errorf("Wrong rebased position: %v != %v", 7, tform.Position)
+	}
+
+	if _, err = model.FlushTransforms(&content, 60); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+	if exp := "😀hello!"; content != exp {
+		t.Errorf("Wrong result: %v != %v", exp, content)
+	}
+
+	inverse, err := model.(InvertibleModel).InvertTransform(2)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if inverse.Position != 0 || inverse.Delete != 2 || inverse.Insert != "" {
+		t.Errorf("Wrong inverse: %v", inverse)
+	}
+}
+
+func TestTextModelUnitTransformLength(t *testing.T) {
+	expected := map[string]error{
+		PositionCodePoints: nil,
+		PositionUTF16:      nil,
+		PositionBytes:      ErrTransformTooLong,
+	}
+	for unit, exp := range expected {
+		config := DefaultModelConfig()
+		config.PositionUnit = unit
+		config.MaxTransformLength = 2
+
+		model := CreateTextModel(config)
+		if _, _, err := model.PushTransform(OTransform{Version: 2, Insert: "😀"}); err != exp {
+			t.Errorf("Wrong error for %v: %v != %v", unit, exp, err)
+		}
+	}
+
+	config := DefaultModelConfig()
+	config.PositionUnit = "nope"
+	if _, err := NewModel(TextDocumentType, config); err != ErrUnknownPositionUnit {
+		t.Errorf("Expected unknown position unit error, received: %v", err)
+	}
+	model := CreateTextModel(config)
+	if _, _, err := model.PushTransform(OTransform{Version: 2, Insert: "x"}); err != ErrUnknownPositionUnit {
+		t.Errorf("Expected unknown position unit error, received: %v", err)
+	}
+}
+
+/*
+testJournal - A TransformJournal held in a slice.
+*/
+type testJournal []OTransform
+
+func (j testJournal) ReadTransforms(from, to int) ([]OTransform, error) {
+	transforms := []OTransform{}
+	for _, ot := range j {
+		if ot.Version >= from && ot.Version < to {
+			transforms = append(transforms, ot)
+		}
+	}
+	return transforms, nil
+}
+
+func TestTextModelJournal(t *testing.T) {
+	model := CreateTextModel(DefaultModelConfig())
+	content := "hello world"
+
+	journal := testJournal{}
+	for i := 0; i < 5; i++ {
+		tform, _, err := model.PushTransform(OTransform{
+			Version:  model.GetVersion() + 1,
+			Position: 0,
+			Insert:   "a",
+		})
+		if err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+		journal = append(journal, tform)
+
+		// Zero retention discards every applied transform.
+		if _, err = model.FlushTransforms(&content, -1); err != nil {
+			t.Errorf("Error flushing: %v", err)
+		}
+	}
+
+	stale := OTransform{Version: 3, Position: 12, Insert: "!"}
+	if _, _, err := model.PushTransform(stale); err != ErrTransformTooOld {
+		t.Errorf("Expected transform too old error, received: %v", err)
+	}
+
+	model.(JournaledModel).SetJournal(journal[1:], model.GetVersion())
+
+	tform, version, err := model.PushTransform(stale)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if version != 7 || tform.Position != 16 {
+		t.Errorf("Wrong rebased transform: %v, version: %v", tform, version)
+	}
+	if _, err = model.FlushTransforms(&content, -1); err != nil {
+		t.Errorf("Error flushing: %v", err)
+	}
+	if exp := "aaaaahello world!"; content != exp {
+		t.Errorf("Wrong result: %v != %v", exp, content)
+	}
+
+	// The journal is missing the transform of version 2.
+	if _, _, err = model.PushTransform(OTransform{Version: 2, Position: 0, Insert: "b"}); err != ErrTransformTooOld {
+		t.Errorf("Expected transform too old error, received: %v", err)
+	}
+}
+
+func TestTextModelComposeTransforms(t *testing.T) {
+	type composeStory struct {
+		content       string
+		first, second OTransform
+		result        string
+	}
+	stories := []composeStory{
+		{
+			"hello world",
+			OTransform{Position: 5, Insert: " there", Version: 2},
+			OTransform{Position: 11, Insert: ",", Version: 3},
+			"hello there, world",
+		},
+		{
+			"hello world",
+			OTransform{Position: 0, Delete: 5, Insert: "goodbye", Version: 2},
+			OTransform{Position: 4, Delete: 6, Insert: "", Version: 3},
+			"goodrld",
+		},
+		{
+			"hello world",
+			OTransform{Operations: []OTransform{
+				{Position: 6, Delete: 5, Insert: "there"},
+				{Position: 0, Delete: 1, Insert: "H"},
+			}, Version: 2},
+			OTransform{Position: 6, Delete: 5, Insert: "world", Version: 3},
+			"Hello world",
+		},
+		{
+			"a😀b",
+			OTransform{Position: 1, Insert: "😀", Version: 2},
+			OTransform{Position: 2, Delete: 1, Version: 3},
+			"a😀b",
+		},
+	}
+	for i, story := range stories {
+		composed := composeTransforms(story.first, story.second, PositionCodePoints)
+		if composed.Version != story.second.Version || composed.Span != 2 {
+			t.Errorf("Wrong version or span %v: %v, %v", i, composed.Version, composed.Span)
+		}
+		result := applyDiff(t, story.content, transformOperations(&composed), PositionCodePoints)
+		if result != story.result {
+			t.Errorf("Wrong result %v: %v != %v", i, result, story.result)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(7))
+	alphabet := []rune("ab😀 ")
+	randomContent := func() string {
+		runes := make([]rune, rng.Intn(12))
+		for i := range runes {
+			runes[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		return string(runes)
+	}
+	for _, unit := range []string{PositionCodePoints, PositionUTF16, PositionBytes} {
+		for i := 0; i < 200; i++ {
+			contents := []string{randomContent(), randomContent(), randomContent(), randomContent()}
+			composed, _ := DiffTransform(contents[0], contents[1], unit, 2)
+			for j := 2; j < len(contents); j++ {
+				// Unchanged content results in an empty transform.
+				next, _ := DiffTransform(contents[j-1], contents[j], unit, j+1)
+				next.Version = j + 1
+				composed = composeTransforms(composed, next, unit)
+			}
+			if composed.Span != 3 || composed.Version != 4 {
+				t.Errorf("Wrong version or span: %v, %v", composed.Version, composed.Span)
+			}
+			ops := transformOperations(&composed)
+			if err := normaliseOperations(ops); err != nil {
+				t.Errorf("Composed transform was invalid: %v, %v", composed, err)
+			}
+			if result := applyDiff(t, contents[0], ops, unit); result != contents[3] {
+				t.Errorf("Wrong result from %v, %v: %v != %v", contents, unit, result, contents[3])
+			}
+		}
+	}
+}
+
+func TestMergeTransforms(t *testing.T) {
+	type mergeStory struct {
+		content    string
+		transforms []OTransform
+		merged     int
+		result     string
+	}
+	stories := []mergeStory{
+		{
+			"hello world",
+			[]OTransform{
+				{Position: 5, Insert: ",", Version: 2},
+				{Position: 6, Insert: " ", Version: 3},
+				{Position: 7, Insert: "😀", Version: 4},
+				{Position: 6, Insert: "!", Version: 5},
+			},
+			1, "hello,! 😀 world",
+		},
+		{
+			"hello world",
+			[]OTransform{
+				{Position: 4, Delete: 1, Version: 2},
+				{Position: 3, Delete: 1, Version: 3},
+				{Position: 3, Delete: 2, Version: 4},
+				{Position: 0, Insert: "well ", Version: 5},
+				{Position: 12, Insert: "!", Version: 6},
+			},
+			3, "well helorld!",
+		},
+		{
+			"hello world",
+			[]OTransform{
+				{Position: 0, Delete: 1, Insert: "H", Version: 2},
+				{Position: 1, Insert: "e", Version: 3},
+				{Operations: []OTransform{{Position: 6, Insert: "x"}}, Version: 4},
+				{Position: 7, Insert: "y", Version: 5},
+				{Position: 8, Insert: "z", Version: 7},
+			},
+			5, "Heelloxyz world",
+		},
+	}
+	for i, story := range stories {
+		merged := MergeTransforms(story.transforms, PositionUTF16)
+		if len(merged) != story.merged {
+			t.Errorf("Wrong count of merged transforms %v: %v != %v", i, len(merged), story.merged)
+		}
+		versions, content := 0, story.content
+		for _, tform := range merged {
+			versions += transformSpan(&tform)
+			content = applyDiff(t, content, transformOperations(&tform), PositionUTF16)
+		}
+		if versions != len(story.transforms) {
+			t.Errorf("Wrong count of versions %v: %v != %v", i, versions, len(story.transforms))
+		}
+		if content != story.result {
+			t.Errorf("Wrong result %v: %v != %v", i, content, story.result)
+		}
+		if last := merged[len(merged)-1]; last.Version != story.transforms[len(story.transforms)-1].Version {
+			t.Errorf("Wrong version of last transform %v: %v", i, last.Version)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// The maximum number of runes held by a single leaf of a rope.
+const ropeLeafSize = 512
+
+/*
+The checksum of content is a polynomial hash of its runes, the sum of each rune multiplied by the base
+raised to the power of its position, modulo a prime. Both the base and the prime are small enough
+that clients without 64 bit integers can compute the same checksum exactly.
+*/
+const (
+	checksumBase    = 65599
+	checksumModulus = 67108859
+)
+
+/*
+ropeNode - A node of a rope, either a leaf holding content or a branch joining two subtrees. Nodes
+are never modified once created, which allows subtrees and leaf content to be shared freely.
+*/
+type ropeNode struct {
+	left, right *ropeNode
+	leaf        []rune
+	length      int    // Total runes
+	size        int    // Total bytes when encoded as UTF-8
+	units16     int    // Total code units when encoded as UTF-16
+	hash        uint64 // Checksum of the content
+	power       uint64 // The checksum base raised to the power of the length
+	height      int
+}
+
+func newRopeLeaf(content []rune) *ropeNode {
+	size, units16 := 0, 0
+	hash, power := uint64(0), uint64(1)
+	for _, r := range content {
+		size += unitRuneLength(PositionBytes, r)
+		units16 += unitRuneLength(PositionUTF16, r)
+		hash = (hash + uint64(r)*power) % checksumModulus
+		power = (power * checksumBase) % checksumModulus
+	}
+	return &ropeNode{
+		leaf:    content,
+		length:  len(content),
+		size:    size,
+		units16: units16,
+		hash:    hash,
+		power:   power,
+	}
+}
+
+func newRopeBranch(left, right *ropeNode) *ropeNode {
+	return &ropeNode{
+		left:    left,
+		right:   right,
+		length:  left.length + right.length,
+		size:    left.size + right.size,
+		units16: left.units16 + right.units16,
+		hash:    (left.hash + right.hash*left.power) % checksumModulus,
+		power:   (left.power * right.power) % checksumModulus,
+		height:  intMax(left.height, right.height) + 1,
+	}
+}
+
+/*
+measure - Returns the length of the content beneath a node in a particular position unit.
+*/
+func (n *ropeNode) measure(unit string) int {
+	if n == nil {
+		return 0
+	}
+	switch unit {
+	case PositionUTF16:
+		return n.units16
+	case PositionBytes:
+		return n.size
+	}
+	return n.length
+}
+
+func ropeHeight(n *ropeNode) int {
+	if n == nil {
+		return -1
+	}
+	return n.height
+}
+
+/*
+ropeBalance - Creates a branch of two subtrees whose heights differ by at most two, rotating where
+necessary in order to keep the tree balanced.
+*/
+func ropeBalance(left, right *ropeNode) *ropeNode {
+	if ropeHeight(left) > ropeHeight(right)+1 {
+		if ropeHeight(left.left) >= ropeHeight(left.right) {
+			return newRopeBranch(left.left, newRopeBranch(left.right, right))
+		}
+		return newRopeBranch(
+			newRopeBranch(left.left, left.right.left),
+			newRopeBranch(left.right.right, right),
+		)
+	}
+	if ropeHeight(right) > ropeHeight(left)+1 {
+		if ropeHeight(right.right) >= ropeHeight(right.left) {
+			return newRopeBranch(newRopeBranch(left, right.left), right.right)
+		}
+		return newRopeBranch(
+			newRopeBranch(left, right.left.left),
+			newRopeBranch(right.left.right, right.right),
+		)
+	}
+	return newRopeBranch(left, right)
+}
+
+/*
+ropeConcat - Joins two ropes, the cost is proportional to the difference in their heights. Small
+neighbouring leaves are merged in order to avoid fragmentation.
+*/
+func ropeConcat(left, right *ropeNode) *ropeNode {
+	if left == nil || left.length == 0 {
+		return right
+	}
+	if right == nil || right.length == 0 {
+		return left
+	}
+	if left.leaf != nil && right.leaf != nil && left.length+right.length <= ropeLeafSize {
+		merged := make([]rune, left.length+right.length)
+		copy(merged, left.leaf)
+		copy(merged[left.length:], right.leaf)
+		return newRopeLeaf(merged)
+	}
+	if hl, hr := ropeHeight(left), ropeHeight(right); hl > hr+1 {
+		return ropeBalance(left.left, ropeConcat(left.right, right))
+	} else if hr > hl+1 {
+		return ropeBalance(ropeConcat(left, right.left), right.right)
+	}
+	return newRopeBranch(left, right)
+}
+
+/*
+ropeSplit - Splits a rope at a rune position, returning the content before and after it.
+*/
+func ropeSplit(n *ropeNode, pos int) (*ropeNode, *ropeNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if pos <= 0 {
+		return nil, n
+	}
+	if pos >= n.length {
+		return n, nil
+	}
+	if n.leaf != nil {
+		// Capacity is capped so that appending to either half can never overwrite the other.
+		return newRopeLeaf(n.leaf[:pos:pos]), newRopeLeaf(n.leaf[pos:])
+	}
+	if pos < n.left.length {
+		left, right := ropeSplit(n.left, pos)
+		return left, ropeConcat(right, n.right)
+	}
+	left, right := ropeSplit(n.right, pos-n.left.length)
+	return ropeConcat(n.left, left), right
+}
+
+/*
+ropeBuild - Builds a balanced rope from content.
+*/
+func ropeBuild(content []rune) *ropeNode {
+	if len(content) == 0 {
+		return nil
+	}
+	if len(content) <= ropeLeafSize {
+		return newRopeLeaf(content)
+	}
+	leaves := (len(content) + ropeLeafSize - 1) / ropeLeafSize
+	mid := (leaves / 2) * ropeLeafSize
+	return newRopeBranch(ropeBuild(content[:mid:mid]), ropeBuild(content[mid:]))
+}
+
+/*
+ropeRuneOffset - Converts a position counted in a unit into a rune position, returns an error if the
+position lands within a character or is beyond the content.
+*/
+func ropeRuneOffset(n *ropeNode, pos int, unit string) (int, error) {
+	runes := 0
+	for n != nil && n.leaf == nil {
+		if leftLength := n.left.measure(unit); pos < leftLength {
+			n = n.left
+		} else {
+			pos -= leftLength
+			runes += n.left.length
+			n = n.right
+		}
+	}
+	if n != nil {
+		for i, r := range n.leaf {
+			if pos == 0 {
+				return runes + i, nil
+			}
+			if pos -= unitRuneLength(unit, r); pos < 0 {
+				return 0, ErrTransformSplitsCharacter
+			}
+		}
+		runes += n.length
+	}
+	if pos != 0 {
+		return 0, fmt.Errorf("position surpassed content length by (%v)", pos)
+	}
+	return runes, nil
+}
+
+func (n *ropeNode) writeTo(buf *bytes.Buffer) {
+	if n == nil {
+		return
+	}
+	if n.leaf != nil {
+		for _, r := range n.leaf {
+			buf.WriteRune(r)
+		}
+		return
+	}
+	n.left.writeTo(buf)
+	n.right.writeTo(buf)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Rope - Holds the content of a document as a balanced tree of small chunks, this allows edits to be
+applied at a cost in proportion to the size of the edit rather than the size of the document.
+Positions within a rope are counted in runes unless otherwise stated.
+*/
+type Rope struct {
+	root *ropeNode
+}
+
+/*
+NewRope - Creates a rope holding some content.
+*/
+func NewRope(content string) *Rope {
+	return &Rope{root: ropeBuild(bytes.Runes([]byte(content)))}
+}
+
+/*
+Len - Returns the length of the content in runes.
+*/
+func (r *Rope) Len() int {
+	if r.root == nil {
+		return 0
+	}
+	return r.root.length
+}
+
+/*
+Length - Returns the length of the content in a position unit.
+*/
+func (r *Rope) Length(unit string) int {
+	return r.root.measure(unit)
+}
+
+/*
+Size - Returns the length of the content in bytes.
+*/
+func (r *Rope) Size() int {
+	if r.root == nil {
+		return 0
+	}
+	return r.root.size
+}
+
+/*
+Splice - Removes a number of runes at a position and inserts content in their place, returns the
+removed content.
+*/
+func (r *Rope) Splice(pos, del int, insert string) (string, error) {
+	if pos < 0 || del < 0 || pos+del > r.Len() {
+		return "", fmt.Errorf(
+			"splice position (%v) and deletion (%v) surpassed content length (%v)", pos, del, r.Len())
+	}
+	left, rest := ropeSplit(r.root, pos)
+	middle, right := ropeSplit(rest, del)
+
+	var deleted bytes.Buffer
+	middle.writeTo(&deleted)
+
+	r.root = ropeConcat(ropeConcat(left, ropeBuild(bytes.Runes([]byte(insert)))), right)
+	return deleted.String(), nil
+}
+
+/*
+SpliceUnits - Behaves the same as Splice, but the position and deletion are counted in a position
+unit. Returns ErrTransformSplitsCharacter if either end of the removed content lands within a
+character.
+*/
+func (r *Rope) SpliceUnits(unit string, pos, del int, insert string) (string, error) {
+	if unit == "" || unit == PositionCodePoints {
+		return r.Splice(pos, del, insert)
+	}
+	if pos < 0 || del < 0 || pos+del > r.Length(unit) {
+		return "", fmt.Errorf(
+			"splice position (%v) and deletion (%v) surpassed content length (%v)",
+			pos, del, r.Length(unit))
+	}
+	start, err := ropeRuneOffset(r.root, pos, unit)
+	if err != nil {
+		return "", err
+	}
+	end, err := ropeRuneOffset(r.root, pos+del, unit)
+	if err != nil {
+		return "", err
+	}
+	return r.Splice(start, end-start, insert)
+}
+
+/*
+Checksum - Returns the checksum of the content, which is maintained as the rope is edited and
+therefore costs nothing to obtain.
+*/
+func (r *Rope) Checksum() uint32 {
+	if r.root == nil {
+		return 0
+	}
+	return uint32(r.root.hash)
+}
+
+/*
+String - Returns the full content of the rope.
+*/
+func (r *Rope) String() string {
+	var buf bytes.Buffer
+	buf.Grow(r.Size())
+	r.root.writeTo(&buf)
+	return buf.String()
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Checksum - Returns the checksum of some content, which is the same as the checksum of a rope holding
+that content.
+*/
+func Checksum(content string) uint32 {
+	hash, power := uint64(0), uint64(1)
+	for _, r := range content {
+		hash = (hash + uint64(r)*power) % checksumModulus
+		power = (power * checksumBase) % checksumModulus
+	}
+	return uint32(hash)
+}
+
+/*
+unitRuneLength - Returns the length of a rune in a position unit. Invalid runes are counted as the
+replacement character that they are stored as.
+*/
+func unitRuneLength(unit string, r rune) int {
+	switch unit {
+	case PositionUTF16:
+		if r >= 0x10000 && r <= utf8.MaxRune {
+			return 2
+		}
+		return 1
+	case PositionBytes:
+		if n := utf8.RuneLen(r); n > 0 {
+			return n
+		}
+		return utf8.RuneLen(utf8.RuneError)
+	}
+	return 1
+}
+
+/*
+unitLength - Returns the length of some text in a position unit.
+*/
+func unitLength(unit string, text string) int {
+	if unit != PositionUTF16 && unit != PositionBytes {
+		return utf8.RuneCountInString(text)
+	}
+	length := 0
+	for _, r := range text {
+		length += unitRuneLength(unit, r)
+	}
+	return length
+}
+
+/*
+unitSplit - Splits some text at an offset counted in a position unit.
+*/
+func unitSplit(unit string, text string, offset int) (string, string) {
+	length := 0
+	for i, r := range text {
+		if length >= offset {
+			return text[:i], text[i:]
+		}
+		length += unitRuneLength(unit, r)
+	}
+	return text, ""
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,230 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestRopeSplice(t *testing.T) {
+	content := []rune(strings.Repeat("hello 世界, ", 500))
+	rope := NewRope(string(content))
+
+	inserts := []string{"", "a", "ü", "日本語", strings.Repeat("x", 1500)}
+
+	rng := rand.New(rand.NewSource(42))
+	for i := 0; i < 2000; i++ {
+		pos := rng.Intn(len(content) + 1)
+		del := 0
+		if pos < len(content) {
+			del = rng.Intn(intMin(len(content)-pos, 700) + 1)
+		}
+		insert := inserts[rng.Intn(len(inserts))]
+
+		deleted, err := rope.Splice(pos, del, insert)
+		if err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+		if exp := string(content[pos : pos+del]); exp != deleted {
+			t.Errorf("Wrong deleted content at %v: %v != %v", i, exp, deleted)
+			return
+		}
+
+		newContent := append([]rune{}, content[:pos]...)
+		newContent = append(newContent, []rune(insert)...)
+		content = append(newContent, content[pos+del:]...)
+
+		if rope.Len() != len(content) {
+			t.Errorf("Wrong rope length at %v: %v != %v", i, rope.Len(), len(content))
+			return
+		}
+		if rope.Size() != len(string(content)) {
+			t.Errorf("Wrong rope size at %v: %v != %v", i, rope.Size(), len(string(content)))
+			return
+		}
+		if i%100 == 0 && rope.Checksum() != Checksum(string(content)) {
+			t.Errorf("Wrong rope checksum at %v: %v != %v", i, rope.Checksum(), Checksum(string(content)))
+			return
+		}
+	}
+
+	if exp, act := string(content), rope.String(); exp != act {
+		t.Errorf("Rope content diverged: %v != %v", len(exp), len(act))
+	}
+	if rope.root != nil && rope.root.height > 40 {
+		t.Errorf("Rope has become unbalanced, height: %v", rope.root.height)
+	}
+}
+
+func TestRopeSpliceBounds(t *testing.T) {
+	rope := NewRope("hello")
+
+	if _, err := rope.Splice(3, 3, ""); err == nil {
+		t.Errorf("Expected error from deletion beyond content")
+	}
+	if _, err := rope.Splice(6, 0, "x"); err == nil {
+		t.Errorf("Expected error from position beyond content")
+	}
+	if _, err := rope.Splice(0, -1, "x"); err == nil {
+		t.Errorf("Expected error from negative deletion")
+	}
+	if _, err := rope.Splice(5, 0, " world"); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if exp, act := "hello world", rope.String(); exp != act {
+		t.Errorf("Wrong content: %v != %v", exp, act)
+	}
+}
+
+func TestTextModelFlushToRope(t *testing.T) {
+	content := "hello world"
+	rope := NewRope(content)
+
+	stringModel := CreateTextModel(DefaultModelConfig())
+	ropeModel := CreateTextModel(DefaultModelConfig()).(RopeModel)
+
+	for i := 0; i < 3; i++ {
+		tform := OTransform{
+			Version: i + 2,
+			Operations: []OTransform{
+				{Position: 0, Insert: "a", Delete: 1},
+				{Position: 6 + i, Delete: 1, Insert: "世界"},
+			},
+		}
+		if _, _, err := stringModel.PushTransform(tform); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+		if _, _, err := ropeModel.(Model).PushTransform(tform); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+		if _, err := stringModel.FlushTransforms(&content, 60); err != nil {
+			t.Errorf("Error flushing: %v", err)
+		}
+		if _, err := ropeModel.FlushTransformsToRope(rope, 60); err != nil {
+			t.Errorf("Error flushing: %v", err)
+		}
+	}
+
+	if exp, act := content, rope.String(); exp != act {
+		t.Errorf("Rope flush diverged: %v != %v", exp, act)
+	}
+}
+
+func TestRopeSpliceUnits(t *testing.T) {
+	rope := NewRope(strings.Repeat("a😀e\u0301", 300))
+
+	if exp, act := 1500, rope.Length(PositionUTF16); exp != act {
+		t.Errorf("Wrong UTF-16 length: %v != %v", exp, act)
+	}
+	if exp, act := 2400, rope.Length(PositionBytes); exp != act {
+		t.Errorf("Wrong byte length: %v != %v", exp, act)
+	}
+
+	// Removes the final emoji, which lies beyond the first leaf.
+	deleted, err := rope.SpliceUnits(PositionUTF16, 1496, 2, "b")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if deleted != "😀" {
+		t.Errorf("Wrong deleted content: %v", deleted)
+	}
+	if _, err = rope.SpliceUnits(PositionUTF16, 1492, 0, "b"); err != ErrTransformSplitsCharacter {
+		t.Errorf("Expected split character error, received: %v", err)
+	}
+	if _, err = rope.SpliceUnits(PositionBytes, 1594, 1, ""); err != ErrTransformSplitsCharacter {
+		t.Errorf("Expected split character error, received: %v", err)
+	}
+	if exp := strings.Repeat("a😀e\u0301", 299) + "abe\u0301"; exp != rope.String() {
+		t.Errorf("Wrong content after splice")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	if act := Checksum(""); act != 0 {
+		t.Errorf("Wrong checksum of empty content: %v", act)
+	}
+	if exp, act := uint32(97+98*65599), Checksum("ab"); exp != act {
+		t.Errorf("Wrong checksum: %v != %v", exp, act)
+	}
+	// The same value is expected by the unit tests of the javascript client.
+	if exp, act := uint32(5859554), Checksum("hello 😀 world"); exp != act {
+		t.Errorf("Wrong checksum: %v != %v", exp, act)
+	}
+	content := strings.Repeat("a😀e\u0301", 1000)
+	if exp, act := Checksum(content), NewRope(content).Checksum(); exp != act {
+		t.Errorf("Wrong rope checksum: %v != %v", exp, act)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Transforms per flush and size of document used for flush benchmarks.
+const (
+	benchTransforms  = 50
+	benchContentSize = 1000000
+)
+
+func benchTransform(model Model, rng *rand.Rand, length int) {
+	model.PushTransform(OTransform{
+		Version:  model.GetVersion() + 1,
+		Position: rng.Intn(length),
+		Delete:   1,
+		Insert:   "ab",
+	})
+}
+
+func BenchmarkTextModelFlushString(b *testing.B) {
+	content := strings.Repeat("a", benchContentSize)
+	model := CreateTextModel(DefaultModelConfig())
+	rng := rand.New(rand.NewSource(42))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchTransforms; j++ {
+			benchTransform(model, rng, benchContentSize)
+		}
+		if _, err := model.FlushTransforms(&content, 60); err != nil {
+			b.Fatalf("Error flushing: %v", err)
+		}
+	}
+}
+
+func BenchmarkTextModelFlushRope(b *testing.B) {
+	content := NewRope(strings.Repeat("a", benchContentSize))
+	model := CreateTextModel(DefaultModelConfig())
+	rng := rand.New(rand.NewSource(42))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchTransforms; j++ {
+			benchTransform(model, rng, benchContentSize)
+		}
+		if _, err := model.(RopeModel).FlushTransformsToRope(content, 60); err != nil {
+			b.Fatalf("Error flushing: %v", err)
+		}
+	}
+}
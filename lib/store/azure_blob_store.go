@@ -2,6 +2,7 @@ package store
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -20,8 +21,18 @@ type AzureStorageConfig struct {
 	AccessType string `json:"access_type" yaml:"access_type"`
 }
 
+// The metadata key of a blob that holds the document type.
+const azureTypeMetadataKey = "leapstype"
+
+// The prefix of the name of the blob that holds the locks, comment threads and suggestions of a
+// document.
+const azureMetaBlobPrefix = ".leaps-meta/"
+
 /*
-AzureBlobStore - Contains configuration and logic for CRUD operations on Azure.
+AzureBlobStore - Contains configuration and logic for CRUD operations on Azure. The document type
+is stored as metadata of the blob, and the locks, comment threads and suggestions of a document are
+stored as JSON in a separate blob named after the document with the prefix .leaps-meta/, which is
+removed when a document has none.
 */
 type AzureBlobStore struct {
 	config      AzureStorageConfig
@@ -96,10 +107,61 @@ func (m *AzureBlobStore) Update(doc Document) error {
 	b.MaxElapsedTime = 15 * time.Minute
 	return backoff.Retry(func() error {
 		r := strings.NewReader(doc.Content)
-		return m.blobStorage.CreateBlockBlobFromReader(m.config.Container, doc.ID, uint64(r.Len()), r)
+		if err := m.blobStorage.CreateBlockBlobFromReader(m.config.Container, doc.ID, uint64(r.Len()), r); err != nil {
+			return err
+		}
+		if len(doc.Type) > 0 {
+			if err := m.blobStorage.SetBlobMetadata(m.config.Container, doc.ID, map[string]string{
+				azureTypeMetadataKey: doc.Type,
+			}); err != nil {
+				return err
+			}
+		}
+		return m.updateMeta(doc)
 	}, b)
 }
 
+/*
+updateMeta - Writes the blob holding the fields of a document that are stored apart from its
+content, or removes it if there are none.
+*/
+func (m *AzureBlobStore) updateMeta(doc Document) error {
+	meta := newDocumentMeta(doc)
+	if meta.empty() {
+		_, err := m.blobStorage.DeleteBlobIfExists(m.config.Container, azureMetaBlobPrefix+doc.ID)
+		return err
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return m.blobStorage.CreateBlockBlobFromReader(
+		m.config.Container, azureMetaBlobPrefix+doc.ID, uint64(len(metaBytes)), bytes.NewReader(metaBytes),
+	)
+}
+
+/*
+readMeta - Reads the blob holding the fields of a document that are stored apart from its content,
+a missing blob means that there are none.
+*/
+func (m *AzureBlobStore) readMeta(id string) (documentMeta, error) {
+	var meta documentMeta
+	rc, err := m.blobStorage.GetBlob(m.config.Container, azureMetaBlobPrefix+id)
+	if rc != nil {
+		defer rc.Close()
+	}
+	if err != nil {
+		if e, ok := err.(azure.AzureStorageServiceError); ok && e.StatusCode == 404 {
+			return meta, nil
+		}
+		return meta, err
+	}
+	if err = json.NewDecoder(rc).Decode(&meta); err != nil {
+		return meta, fmt.Errorf("failed to parse meta blob of document: %v", err)
+	}
+	return meta, nil
+}
+
 /*
 Read - Read document from a azure blob storage
 */
@@ -143,6 +205,18 @@ func (m *AzureBlobStore) Read(id string) (Document, error) {
 			return err
 		}
 		doc.Content = b.String()
+
+		metadata, err := m.blobStorage.GetBlobMetadata(m.config.Container, id)
+		if err != nil {
+			return err
+		}
+		doc.Type = metadata[azureTypeMetadataKey]
+
+		meta, err := m.readMeta(id)
+		if err != nil {
+			return err
+		}
+		meta.apply(&doc)
 		return nil
 	}, b)
 	if retErr != nil {
@@ -22,17 +22,130 @@ THE SOFTWARE.
 
 package store
 
-import "github.com/jeffail/leaps/lib/util"
+import (
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/util"
+)
 
 /*--------------------------------------------------------------------------------------------------
  */
 
 /*
-Document - A representation of a leap document.
+Lock - A protected range of the content of a document, from Start up to but not including End, which
+is counted in the position unit of the transform model. Only the owner of the lock, or users with at
+least the access level of the lock, may edit content within it. A lock without an access level can
+only be edited by its owner.
 */
-type Document struct {
-	ID      string `json:"id" yaml:"id"`
+type Lock struct {
+	ID          string           `json:"id" yaml:"id"`
+	Start       int              `json:"start" yaml:"start"`
+	End         int              `json:"end" yaml:"end"`
+	Owner       string           `json:"owner" yaml:"owner"`
+	AccessLevel auth.AccessLevel `json:"access_level" yaml:"access_level"`
+	Released    bool             `json:"released,omitempty" yaml:"released,omitempty"`
+}
+
+/*
+Comment - A single comment within a comment thread, Created is a unix timestamp.
+*/
+type Comment struct {
+	Author  string `json:"author" yaml:"author"`
 	Content string `json:"content" yaml:"content"`
+	Created int64  `json:"created" yaml:"created"`
+}
+
+/*
+CommentThread - A thread of comments anchored to a range of the content of a document, from Start up
+to but not including End, which is counted in the position unit of the transform model. The anchors
+move with edits to the document, and an anchored range that is deleted collapses to the point of the
+deletion rather than removing the thread.
+*/
+type CommentThread struct {
+	ID       string    `json:"id" yaml:"id"`
+	Start    int       `json:"start" yaml:"start"`
+	End      int       `json:"end" yaml:"end"`
+	Comments []Comment `json:"comments" yaml:"comments"`
+	Resolved bool      `json:"resolved" yaml:"resolved"`
+}
+
+/*
+SuggestionEdit - A single proposed edit, which replaces the content from Start up to but not
+including End with Insert. Positions are counted in the position unit of the transform model.
+*/
+type SuggestionEdit struct {
+	Start  int    `json:"start" yaml:"start"`
+	End    int    `json:"end" yaml:"end"`
+	Insert string `json:"insert,omitempty" yaml:"insert,omitempty"`
+}
+
+// Statuses of a suggestion that is no longer pending.
+const (
+	SuggestionAccepted = "accepted"
+	SuggestionRejected = "rejected"
+)
+
+/*
+Suggestion - A proposed change to a document that has not been applied. The edits are anchored to the
+content in the same way as comment threads, and are ordered by descending position. Stored
+suggestions are always pending, the status is only set when announcing that a suggestion was
+accepted or rejected.
+*/
+type Suggestion struct {
+	ID      string           `json:"id" yaml:"id"`
+	Author  string           `json:"author" yaml:"author"`
+	Created int64            `json:"created" yaml:"created"`
+	Edits   []SuggestionEdit `json:"edits" yaml:"edits"`
+	Status  string           `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+/*
+Document - A representation of a leap document. The Type determines which transform model is used
+for editing the document, an empty Type is treated as plain text.
+*/
+type Document struct {
+	ID          string          `json:"id" yaml:"id"`
+	Type        string          `json:"type,omitempty" yaml:"type,omitempty"`
+	Content     string          `json:"content" yaml:"content"`
+	Locks       []Lock          `json:"locks,omitempty" yaml:"locks,omitempty"`
+	Comments    []CommentThread `json:"comments,omitempty" yaml:"comments,omitempty"`
+	Suggestions []Suggestion    `json:"suggestions,omitempty" yaml:"suggestions,omitempty"`
+}
+
+/*
+documentMeta - The fields of a document that are stored apart from its content, by stores that
+cannot keep them alongside the content, encoded as JSON.
+*/
+type documentMeta struct {
+	Locks       []Lock          `json:"locks,omitempty"`
+	Comments    []CommentThread `json:"comments,omitempty"`
+	Suggestions []Suggestion    `json:"suggestions,omitempty"`
+}
+
+/*
+newDocumentMeta - Returns the fields of a document that are stored apart from its content.
+*/
+func newDocumentMeta(doc Document) documentMeta {
+	return documentMeta{
+		Locks:       doc.Locks,
+		Comments:    doc.Comments,
+		Suggestions: doc.Suggestions,
+	}
+}
+
+/*
+empty - Returns true if there are no fields worth storing.
+*/
+func (m documentMeta) empty() bool {
+	return len(m.Locks) == 0 && len(m.Comments) == 0 && len(m.Suggestions) == 0
+}
+
+/*
+apply - Sets the fields of a document that were stored apart from its content.
+*/
+func (m documentMeta) apply(doc *Document) {
+	doc.Locks = m.Locks
+	doc.Comments = m.Comments
+	doc.Suggestions = m.Suggestions
 }
 
 /*--------------------------------------------------------------------------------------------------
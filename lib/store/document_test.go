@@ -22,7 +22,11 @@ THE SOFTWARE.
 
 package store
 
-import "testing"
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
 
 func TestIDGenerator(t *testing.T) {
 	num := 10000
@@ -43,3 +47,40 @@ func TestIDGenerator(t *testing.T) {
 		t.Errorf("There were %v ID collisions out of %v documents generated.", collisions, num)
 	}
 }
+
+func TestDocumentMeta(t *testing.T) {
+	if meta := newDocumentMeta(Document{ID: "a", Content: "hello"}); !meta.empty() {
+		t.Errorf("Meta of plain document was not empty: %v", meta)
+	}
+
+	doc := Document{
+		ID:      "a",
+		Content: "hello world",
+		Locks:   []Lock{{ID: "lock", Start: 0, End: 5, Owner: "alice"}},
+		Comments: []CommentThread{{
+			ID: "thread", Start: 6, End: 11, Comments: []Comment{{Author: "bob", Content: "hi", Created: 1}},
+		}},
+		Suggestions: []Suggestion{{
+			ID: "suggestion", Author: "bob", Created: 2, Edits: []SuggestionEdit{{Start: 0, End: 5, Insert: "hi"}},
+		}},
+	}
+	meta := newDocumentMeta(doc)
+	if meta.empty() {
+		t.Fatal("Meta of document with locks was empty")
+	}
+
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	var decoded documentMeta
+	if err = json.Unmarshal(metaBytes, &decoded); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	read := Document{ID: doc.ID, Content: doc.Content}
+	decoded.apply(&read)
+	if !reflect.DeepEqual(read, doc) {
+		t.Errorf("Document changed through meta: %v != %v", read, doc)
+	}
+}
@@ -23,11 +23,14 @@ THE SOFTWARE.
 package store
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 /*--------------------------------------------------------------------------------------------------
@@ -45,9 +48,50 @@ directory.
 
 For example, with StoreDirectory set to /var/www, a document can be given the ID css/main.css to
 create and edit the file /var/www/css/main.css
+
+Any document fields other than the content, such as the type and locks, are stored in a hidden file
+alongside the document, following the example above this would be /var/www/css/.main.css.leaps.
+Plain text documents without locks do not need this file. Similarly the journal of a document is
+stored in a hidden file, which would be /var/www/css/.main.css.journal, holding an entry per line.
+
+Documents can be watched for modifications made outside of leaps, see FileWatcherConfig.
 */
 type FileStore struct {
 	config Config
+
+	watcherOnce sync.Once
+	watcher     *fileWatcher
+}
+
+/*
+fileMeta - The fields of a document stored in its hidden meta file.
+*/
+type fileMeta struct {
+	Type string `json:"type,omitempty"`
+	documentMeta
+}
+
+/*
+empty - Returns true if there are no fields worth storing in a meta file.
+*/
+func (m fileMeta) empty() bool {
+	return len(m.Type) == 0 && m.documentMeta.empty()
+}
+
+/*
+metaPath - Returns the path of the hidden meta file of a document.
+*/
+func (s *FileStore) metaPath(id string) string {
+	filePath := filepath.Join(s.config.StoreDirectory, id)
+	return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+".leaps")
+}
+
+/*
+journalPath - Returns the path of the hidden journal file of a document.
+*/
+func (s *FileStore) journalPath(id string) string {
+	filePath := filepath.Join(s.config.StoreDirectory, id)
+	return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+".journal")
 }
 
 /*
@@ -69,7 +113,26 @@ func (s *FileStore) Update(doc Document) error {
 			return fmt.Errorf("cannot create file path for document: %v, err: %v", doc.ID, err)
 		}
 	}
-	return ioutil.WriteFile(filePath, []byte(doc.Content), 0666)
+	if err := ioutil.WriteFile(filePath, []byte(doc.Content), 0666); err != nil {
+		return err
+	}
+
+	metaPath := s.metaPath(doc.ID)
+	meta := fileMeta{
+		Type:         doc.Type,
+		documentMeta: newDocumentMeta(doc),
+	}
+	if meta.empty() {
+		if err := os.Remove(metaPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove meta file of document: %v, err: %v", doc.ID, err)
+		}
+		return nil
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(metaPath, metaBytes, 0666)
 }
 
 /*
@@ -80,10 +143,111 @@ func (s *FileStore) Read(id string) (Document, error) {
 	if err != nil {
 		return Document{}, fmt.Errorf("failed to read content from document file: %v", err)
 	}
-	return Document{
+
+	var meta fileMeta
+	if metaBytes, err := ioutil.ReadFile(s.metaPath(id)); err == nil {
+		if err = json.Unmarshal(metaBytes, &meta); err != nil {
+			return Document{}, fmt.Errorf("failed to parse meta file of document: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return Document{}, fmt.Errorf("failed to read meta file of document: %v", err)
+	}
+
+	doc := Document{
 		Content: string(bytes),
 		ID:      id,
-	}, nil
+		Type:    meta.Type,
+	}
+	meta.apply(&doc)
+	return doc, nil
+}
+
+/*
+encodeJournal - Encodes journal entries as they are stored in a journal file, an entry per line.
+*/
+func encodeJournal(entries []JournalEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(entryBytes)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+/*
+AppendJournal - Append entries to the journal file of a document.
+*/
+func (s *FileStore) AppendJournal(id string, entries []JournalEntry) error {
+	entryBytes, err := encodeJournal(entries)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.journalPath(id), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to open journal file of document: %v", err)
+	}
+	if _, err = file.Write(entryBytes); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write journal file of document: %v", err)
+	}
+	return file.Close()
+}
+
+/*
+ReadJournal - Read entries from the journal file of a document, a document without a journal file
+has an empty journal.
+*/
+func (s *FileStore) ReadJournal(id string, from int) ([]JournalEntry, error) {
+	journalBytes, err := ioutil.ReadFile(s.journalPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []JournalEntry{}, nil
+		}
+		return nil, fmt.Errorf("failed to read journal file of document: %v", err)
+	}
+
+	entries := []JournalEntry{}
+	for _, line := range bytes.Split(journalBytes, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err = json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse journal file of document: %v", err)
+		}
+		if entry.Version >= from {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+/*
+TrimJournal - Rewrite the journal file of a document without its old entries. The remaining entries
+are written to a temporary file which then replaces the journal.
+*/
+func (s *FileStore) TrimJournal(id string, before int) error {
+	journalPath := s.journalPath(id)
+	if _, err := os.Stat(journalPath); os.IsNotExist(err) {
+		return nil
+	}
+	entries, err := s.ReadJournal(id, before)
+	if err != nil {
+		return err
+	}
+	entryBytes, err := encodeJournal(entries)
+	if err != nil {
+		return err
+	}
+	tmpPath := journalPath + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, entryBytes, 0666); err != nil {
+		return fmt.Errorf("failed to write journal file of document: %v", err)
+	}
+	return os.Rename(tmpPath, journalPath)
 }
 
 /*
@@ -0,0 +1,211 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeffail/leaps/lib/auth"
+)
+
+func TestFileStoreDocumentType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.StoreDirectory = dir
+
+	fileStore, err := GetFileStore(config)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	docs := []Document{
+		{ID: "plain.txt", Content: "hello world"},
+		{ID: "nested/config", Type: "json", Content: `{"hello":"world"}`},
+	}
+	for _, doc := range docs {
+		if err = fileStore.Create(doc); err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+	}
+	for _, doc := range docs {
+		read, err := fileStore.Read(doc.ID)
+		if err != nil {
+			t.Errorf("Error: %v", err)
+			continue
+		}
+		if read.ID != doc.ID || read.Type != doc.Type || read.Content != doc.Content {
+			t.Errorf("Wrong document read: %v != %v", read, doc)
+		}
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, ".plain.txt.leaps")); !os.IsNotExist(err) {
+		t.Errorf("Unexpected meta file for plain text document: %v", err)
+	}
+
+	docs[1].Type = ""
+	if err = fileStore.Update(docs[1]); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if read, err := fileStore.Read(docs[1].ID); err != nil || read.Type != "" {
+		t.Errorf("Type not cleared: %v, %v", read.Type, err)
+	}
+}
+
+func TestFileStoreDocumentLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.StoreDirectory = dir
+
+	fileStore, err := GetFileStore(config)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	lock := Lock{ID: "header", Start: 0, End: 5, Owner: "admin", AccessLevel: auth.CreateAccess}
+	doc := Document{ID: "template.md", Content: "hello world", Locks: []Lock{lock}}
+	if err = fileStore.Create(doc); err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	read, err := fileStore.Read(doc.ID)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if len(read.Locks) != 1 || read.Locks[0] != lock {
+		t.Errorf("Wrong locks read: %v != %v", read.Locks, doc.Locks)
+	}
+
+	doc.Locks = nil
+	if err = fileStore.Update(doc); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, ".template.md.leaps")); !os.IsNotExist(err) {
+		t.Errorf("Meta file not removed with locks: %v", err)
+	}
+}
+
+func TestFileStoreDocumentComments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.StoreDirectory = dir
+
+	fileStore, err := GetFileStore(config)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	thread := CommentThread{
+		ID:       "first",
+		Start:    6,
+		End:      11,
+		Comments: []Comment{{Author: "reviewer", Content: "which world?", Created: 1}},
+	}
+	doc := Document{ID: "notes.md", Content: "hello world", Comments: []CommentThread{thread}}
+	if err = fileStore.Create(doc); err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	read, err := fileStore.Read(doc.ID)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if len(read.Comments) != 1 || len(read.Comments[0].Comments) != 1 {
+		t.Errorf("Wrong comments read: %v != %v", read.Comments, doc.Comments)
+		return
+	}
+	if act := read.Comments[0]; act.ID != thread.ID || act.Start != thread.Start ||
+		act.End != thread.End || act.Comments[0] != thread.Comments[0] {
+		t.Errorf("Wrong comment thread read: %v != %v", act, thread)
+	}
+}
+
+func TestFileStoreDocumentSuggestions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.StoreDirectory = dir
+
+	fileStore, err := GetFileStore(config)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	edit := SuggestionEdit{Start: 0, End: 5, Insert: "goodbye"}
+	doc := Document{
+		ID:          "draft.md",
+		Content:     "hello world",
+		Suggestions: []Suggestion{{ID: "first", Author: "reviewer", Edits: []SuggestionEdit{edit}}},
+	}
+	if err = fileStore.Create(doc); err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+
+	read, err := fileStore.Read(doc.ID)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if len(read.Suggestions) != 1 || len(read.Suggestions[0].Edits) != 1 {
+		t.Errorf("Wrong suggestions read: %v != %v", read.Suggestions, doc.Suggestions)
+		return
+	}
+	if act := read.Suggestions[0]; act.ID != "first" || act.Author != "reviewer" || act.Edits[0] != edit {
+		t.Errorf("Wrong suggestion read: %v", act)
+	}
+}
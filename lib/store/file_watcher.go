@@ -0,0 +1,276 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+FileWatcherConfig - The configuration fields for watching the files of documents in a file store.
+Files are watched with inotify where supported, otherwise, or when polling is forced, each file is
+checked for modifications once per poll period.
+*/
+type FileWatcherConfig struct {
+	Enabled      bool `json:"enabled" yaml:"enabled"`
+	ForcePolling bool `json:"force_polling" yaml:"force_polling"`
+	PollPeriod   int  `json:"poll_period_ms" yaml:"poll_period_ms"`
+}
+
+/*
+NewFileWatcherConfig - A default file watcher configuration.
+*/
+func NewFileWatcherConfig() FileWatcherConfig {
+	return FileWatcherConfig{
+		Enabled:      true,
+		ForcePolling: false,
+		PollPeriod:   1000,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+notifyBackend - Implemented by platform specific notification systems, which watch directories and
+report the paths of files within them that were written to or moved into place.
+*/
+type notifyBackend interface {
+	add(dir string) error
+	remove(dir string) error
+	events() <-chan string
+}
+
+/*
+fileState - The modification time and size of a file, used for detecting modifications by polling.
+*/
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+/*
+statFile - Returns the current state of a file.
+*/
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
+
+/*
+fileWatcher - Watches files for modifications and signals the channels of each watch of a modified
+file. The parent directories of files are watched rather than the files themselves, since editors and
+version control tools often replace a file rather than write to it.
+*/
+type fileWatcher struct {
+	config  FileWatcherConfig
+	backend notifyBackend
+
+	mut     sync.Mutex
+	watches map[string]map[chan struct{}]struct{}
+	states  map[string]fileState
+	dirs    map[string]int
+}
+
+/*
+newFileWatcher - Creates a file watcher, falling back to polling when the notification backend of
+the platform is unavailable.
+*/
+func newFileWatcher(config FileWatcherConfig) *fileWatcher {
+	w := &fileWatcher{
+		config:  config,
+		watches: map[string]map[chan struct{}]struct{}{},
+		states:  map[string]fileState{},
+		dirs:    map[string]int{},
+	}
+	if !config.ForcePolling {
+		if backend, err := newNotifyBackend(); err == nil {
+			w.backend = backend
+		}
+	}
+	if w.backend != nil {
+		go w.loopNotify()
+	} else {
+		go w.loopPoll()
+	}
+	return w
+}
+
+/*
+watch - Starts watching a file, the returned channel receives a signal when the file is modified and
+the returned function stops the watch.
+*/
+func (w *fileWatcher) watch(path string) (<-chan struct{}, func(), error) {
+	path = filepath.Clean(path)
+	dir := filepath.Dir(path)
+
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	if w.backend != nil && w.dirs[dir] == 0 {
+		if err := w.backend.add(dir); err != nil {
+			return nil, nil, err
+		}
+	}
+	w.dirs[dir]++
+
+	changes := make(chan struct{}, 1)
+	if _, ok := w.watches[path]; !ok {
+		w.watches[path] = map[chan struct{}]struct{}{}
+		w.states[path] = statFile(path)
+	}
+	w.watches[path][changes] = struct{}{}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			w.unwatch(path, changes)
+		})
+	}
+	return changes, stop, nil
+}
+
+/*
+unwatch - Stops a watch of a file.
+*/
+func (w *fileWatcher) unwatch(path string, changes chan struct{}) {
+	dir := filepath.Dir(path)
+
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	delete(w.watches[path], changes)
+	if len(w.watches[path]) == 0 {
+		delete(w.watches, path)
+		delete(w.states, path)
+	}
+	if w.dirs[dir]--; w.dirs[dir] <= 0 {
+		delete(w.dirs, dir)
+		if w.backend != nil {
+			w.backend.remove(dir)
+		}
+	}
+}
+
+/*
+signal - Signals each watch of a file without blocking, a watch that has not yet received a previous
+signal already has one pending.
+*/
+func (w *fileWatcher) signal(path string) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	for changes := range w.watches[path] {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+/*
+loopNotify - Signals the watches of files reported by the notification backend.
+*/
+func (w *fileWatcher) loopNotify() {
+	for path := range w.backend.events() {
+		w.signal(path)
+	}
+}
+
+/*
+loopPoll - Checks each watched file for modifications once per poll period.
+*/
+func (w *fileWatcher) loopPoll() {
+	period := time.Duration(w.config.PollPeriod) * time.Millisecond
+	if period <= 0 {
+		period = time.Second
+	}
+	for range time.Tick(period) {
+		w.mut.Lock()
+		paths := make([]string, 0, len(w.states))
+		for path := range w.states {
+			paths = append(paths, path)
+		}
+		w.mut.Unlock()
+
+		for _, path := range paths {
+			state := statFile(path)
+
+			w.mut.Lock()
+			previous, ok := w.states[path]
+			if ok {
+				w.states[path] = state
+			}
+			w.mut.Unlock()
+
+			if ok && state != previous {
+				w.signal(path)
+			}
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+WatchableStore - Implemented by stores able to notify when the content of a document is modified by
+something other than the store, such as when the files of a file store are edited by other tools. A
+store is not required to implement this interface.
+*/
+type WatchableStore interface {
+	// Watch - Start watching a document, the returned channel receives a signal after each external
+	// modification of the document, and the returned function stops the watch. The channel is nil
+	// if watching is disabled.
+	Watch(id string) (<-chan struct{}, func(), error)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Watch - Watch the file of a document for modifications. Modifications made by the store itself are
+also signalled, a reader should compare the content against what it last stored.
+*/
+func (s *FileStore) Watch(id string) (<-chan struct{}, func(), error) {
+	if !s.config.FileWatcher.Enabled {
+		return nil, func() {}, nil
+	}
+	s.watcherOnce.Do(func() {
+		s.watcher = newFileWatcher(s.config.FileWatcher)
+	})
+	return s.watcher.watch(filepath.Join(s.config.StoreDirectory, id))
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,145 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Events of files within a watched directory that indicate the file was modified.
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO
+
+/*
+inotifyBackend - A notifyBackend that uses inotify. The inotify file descriptor is non-blocking and
+wrapped in an os.File, so that reads are handled by the runtime poller.
+*/
+type inotifyBackend struct {
+	file      *os.File
+	mut       sync.Mutex
+	dirs      map[string]int
+	wds       map[int]string
+	eventChan chan string
+}
+
+/*
+newNotifyBackend - Creates an inotify backend.
+*/
+func newNotifyBackend() (notifyBackend, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	backend := &inotifyBackend{
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		dirs:      map[string]int{},
+		wds:       map[int]string{},
+		eventChan: make(chan string),
+	}
+	go backend.loop()
+	return backend, nil
+}
+
+/*
+add - Starts watching a directory.
+*/
+func (i *inotifyBackend) add(dir string) error {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	wd, err := syscall.InotifyAddWatch(int(i.file.Fd()), dir, inotifyMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	i.dirs[dir], i.wds[wd] = wd, dir
+	return nil
+}
+
+/*
+remove - Stops watching a directory.
+*/
+func (i *inotifyBackend) remove(dir string) error {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	wd, ok := i.dirs[dir]
+	if !ok {
+		return nil
+	}
+	delete(i.dirs, dir)
+	delete(i.wds, wd)
+	_, err := syscall.InotifyRmWatch(int(i.file.Fd()), uint32(wd))
+	return err
+}
+
+/*
+events - Returns the channel of paths of modified files.
+*/
+func (i *inotifyBackend) events() <-chan string {
+	return i.eventChan
+}
+
+/*
+loop - Reads and decodes inotify events until the file descriptor fails.
+*/
+func (i *inotifyBackend) loop() {
+	defer close(i.eventChan)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := i.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameBytes := buf[nameStart : nameStart+int(event.Len)]
+			offset = nameStart + int(event.Len)
+
+			if event.Mask&inotifyMask == 0 || event.Len == 0 {
+				continue
+			}
+			i.mut.Lock()
+			dir, ok := i.wds[int(event.Wd)]
+			i.mut.Unlock()
+			if ok {
+				name := string(bytes.TrimRight(nameBytes, "\x00"))
+				i.eventChan <- filepath.Join(dir, name)
+			}
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,140 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreWatch(t *testing.T) {
+	for _, polling := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "leaps_file_watcher")
+		if err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		config := NewConfig()
+		config.StoreDirectory = dir
+		config.FileWatcher.ForcePolling = polling
+		config.FileWatcher.PollPeriod = 10
+
+		fileStore, err := GetFileStore(config)
+		if err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+		for _, id := range []string{"watched.txt", "other.txt"} {
+			if err = fileStore.Create(Document{ID: id, Content: "hello world"}); err != nil {
+				t.Errorf("Error: %v", err)
+				return
+			}
+		}
+
+		changes, stop, err := fileStore.(WatchableStore).Watch("watched.txt")
+		if err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+
+		expectSignal := func(expected bool, context string) {
+			select {
+			case <-changes:
+				if !expected {
+					t.Errorf("Unexpected signal, polling %v: %v", polling, context)
+				}
+			case <-time.After(time.Millisecond * 500):
+				if expected {
+					t.Errorf("Timed out waiting for signal, polling %v: %v", polling, context)
+				}
+			}
+		}
+
+		otherPath := filepath.Join(dir, "other.txt")
+		if err = ioutil.WriteFile(otherPath, []byte("other modified"), 0666); err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+		expectSignal(false, "modified other file")
+
+		watchedPath := filepath.Join(dir, "watched.txt")
+		if err = ioutil.WriteFile(watchedPath, []byte("hello world, modified"), 0666); err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+		expectSignal(true, "written")
+
+		// Editors and version control tools commonly replace files.
+		tmpPath := filepath.Join(dir, ".watched.txt.tmp")
+		if err = ioutil.WriteFile(tmpPath, []byte("replaced"), 0666); err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+		if err = os.Rename(tmpPath, watchedPath); err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+		expectSignal(true, "replaced")
+
+		stop()
+		stop()
+		if err = ioutil.WriteFile(watchedPath, []byte("modified after stopping"), 0666); err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+		expectSignal(false, "stopped")
+	}
+}
+
+func TestFileStoreWatchDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_watcher")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.StoreDirectory = dir
+	config.FileWatcher.Enabled = false
+
+	fileStore, err := GetFileStore(config)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	changes, stop, err := fileStore.(WatchableStore).Watch("watched.txt")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if changes != nil {
+		t.Error("Expected nil channel from disabled watcher")
+	}
+	stop()
+}
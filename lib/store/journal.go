@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"encoding/json"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+JournalEntry - A single versioned change to a document, the data is opaque to the store.
+*/
+type JournalEntry struct {
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+/*
+JournalStore - Implemented by stores able to persist a journal of changes alongside each document,
+which allows changes that are no longer held in memory to be recovered. A store is not required to
+implement this interface.
+*/
+type JournalStore interface {
+	// AppendJournal - Append entries, in ascending order of version, to the journal of a document.
+	AppendJournal(id string, entries []JournalEntry) error
+
+	// ReadJournal - Read all entries of the journal of a document with a version of at least from.
+	ReadJournal(id string, from int) ([]JournalEntry, error)
+
+	// TrimJournal - Remove all entries from the journal of a document with a version below before.
+	TrimJournal(id string, before int) error
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AppendJournal - Append entries to the journal of a document in memory.
+*/
+func (s *MemoryStore) AppendJournal(id string, entries []JournalEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.journals == nil {
+		s.journals = make(map[string][]JournalEntry)
+	}
+	s.journals[id] = append(s.journals[id], entries...)
+	return nil
+}
+
+/*
+ReadJournal - Read entries of the journal of a document from memory.
+*/
+func (s *MemoryStore) ReadJournal(id string, from int) ([]JournalEntry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return filterJournal(s.journals[id], from), nil
+}
+
+/*
+TrimJournal - Remove old entries of the journal of a document from memory.
+*/
+func (s *MemoryStore) TrimJournal(id string, before int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entries, ok := s.journals[id]; ok {
+		s.journals[id] = filterJournal(entries, before)
+	}
+	return nil
+}
+
+/*
+filterJournal - Returns a copy of the entries with a version of at least from.
+*/
+func filterJournal(entries []JournalEntry, from int) []JournalEntry {
+	filtered := []JournalEntry{}
+	for _, entry := range entries {
+		if entry.Version >= from {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
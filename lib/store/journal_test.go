@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, sub to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testJournalStore(journal JournalStore, t *testing.T) {
+	if entries, err := journal.ReadJournal("doc", 0); err != nil {
+		t.Errorf("Error: %v", err)
+	} else if len(entries) != 0 {
+		t.Errorf("Expected empty journal, received: %v", entries)
+	}
+
+	for i := 0; i < 10; i += 2 {
+		entries := []JournalEntry{}
+		for j := i; j < i+2; j++ {
+			entries = append(entries, JournalEntry{
+				Version: j + 1,
+				Data:    json.RawMessage(fmt.Sprintf(`{"n":%v}`, j)),
+			})
+		}
+		if err := journal.AppendJournal("doc", entries); err != nil {
+			t.Errorf("Error: %v", err)
+			return
+		}
+	}
+
+	entries, err := journal.ReadJournal("doc", 4)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	if len(entries) != 7 {
+		t.Errorf("Wrong number of entries: %v != %v", len(entries), 7)
+		return
+	}
+	for i, entry := range entries {
+		if entry.Version != i+4 {
+			t.Errorf("Wrong entry version: %v != %v", entry.Version, i+4)
+		}
+		if exp, act := fmt.Sprintf(`{"n":%v}`, i+3), string(entry.Data); exp != act {
+			t.Errorf("Wrong entry data: %v != %v", exp, act)
+		}
+	}
+
+	if err = journal.TrimJournal("doc", 8); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if entries, err = journal.ReadJournal("doc", 0); err != nil {
+		t.Errorf("Error: %v", err)
+	} else if len(entries) != 3 || entries[0].Version != 8 {
+		t.Errorf("Wrong entries after trim: %v", entries)
+	}
+	if err = journal.TrimJournal("other", 8); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+}
+
+func TestMemoryStoreJournal(t *testing.T) {
+	memStore, _ := GetMemoryStore(NewConfig())
+	testJournalStore(memStore.(JournalStore), t)
+}
+
+func TestFileStoreJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file_store")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.StoreDirectory = dir
+
+	fileStore, err := GetFileStore(config)
+	if err != nil {
+		t.Errorf("Error: %v", err)
+		return
+	}
+	testJournalStore(fileStore.(JournalStore), t)
+}
@@ -24,7 +24,9 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	// Blank because SQL driver
 	_ "github.com/go-sql-driver/mysql"
@@ -36,11 +38,16 @@ import (
 
 /*
 TableConfig - The configuration fields for specifying the table labels of the SQL database target.
+The type and meta columns are added to an existing table when they are missing, rows without a type
+are read as plain text documents. The meta column holds the locks, comment threads and suggestions
+of a document as JSON.
 */
 type TableConfig struct {
 	Name       string `json:"table" yaml:"table"`
 	IDCol      string `json:"id_column" yaml:"id_column"`
 	ContentCol string `json:"content_column" yaml:"content_column"`
+	TypeCol    string `json:"type_column" yaml:"type_column"`
+	MetaCol    string `json:"meta_column" yaml:"meta_column"`
 }
 
 /*
@@ -51,6 +58,8 @@ func NewTableConfig() TableConfig {
 		Name:       "leaps_documents",
 		IDCol:      "ID",
 		ContentCol: "CONTENT",
+		TypeCol:    "DOC_TYPE",
+		MetaCol:    "META",
 	}
 }
 
@@ -90,7 +99,11 @@ type SQLStore struct {
 Create - Create a new document in a database table.
 */
 func (m *SQLStore) Create(doc Document) error {
-	_, err := m.createStmt.Exec(doc.ID, doc.Content)
+	meta, err := encodeSQLMeta(doc)
+	if err != nil {
+		return err
+	}
+	_, err = m.createStmt.Exec(doc.ID, doc.Content, doc.Type, meta)
 	return err
 }
 
@@ -98,7 +111,11 @@ func (m *SQLStore) Create(doc Document) error {
 Update - Update document in a database table.
 */
 func (m *SQLStore) Update(doc Document) error {
-	_, err := m.updateStmt.Exec(doc.Content, doc.ID)
+	meta, err := encodeSQLMeta(doc)
+	if err != nil {
+		return err
+	}
+	_, err = m.updateStmt.Exec(doc.Content, doc.Type, meta, doc.ID)
 	return err
 }
 
@@ -109,7 +126,9 @@ func (m *SQLStore) Read(id string) (Document, error) {
 	var document Document
 	document.ID = id
 
-	err := m.readStmt.QueryRow(id).Scan(&document.Content)
+	var docType, meta sql.NullString
+	err := m.readStmt.QueryRow(id).Scan(&document.Content, &docType, &meta)
+	document.Type = docType.String
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -117,63 +136,116 @@ func (m *SQLStore) Read(id string) (Document, error) {
 	case err != nil:
 		return Document{}, err
 	}
+	if len(meta.String) > 0 {
+		var docMeta documentMeta
+		if err = json.Unmarshal([]byte(meta.String), &docMeta); err != nil {
+			return Document{}, fmt.Errorf("failed to parse meta column of document: %v", err)
+		}
+		docMeta.apply(&document)
+	}
 	return document, nil
 }
 
+/*
+encodeSQLMeta - Encodes the fields of a document stored in the meta column, documents without any
+such fields are stored with a NULL meta column.
+*/
+func encodeSQLMeta(doc Document) (sql.NullString, error) {
+	meta := newDocumentMeta(doc)
+	if meta.empty() {
+		return sql.NullString{}, nil
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(metaBytes), Valid: true}, nil
+}
+
+/*
+addMissingColumn - Adds a column to a table of the database if it does not already exist, which
+allows tables created before the column was introduced to be used as they are.
+*/
+func addMissingColumn(db *sql.DB, table, column, colType string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT %v FROM %v WHERE 1 = 0", column, table))
+	if err == nil {
+		return rows.Close()
+	}
+	if _, err = db.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", table, column, colType)); err != nil {
+		return fmt.Errorf("failed to add column %v to table %v: %v", column, table, err)
+	}
+	return nil
+}
+
 /*
 GetSQLStore - Just a func that returns an SQLStore
 */
 func GetSQLStore(config Config) (Store, error) {
 	var (
-		db                            *sql.DB
-		createStr, updateStr, readStr string
-		create, update, read          *sql.Stmt
-		err                           error
+		db                   *sql.DB
+		create, update, read *sql.Stmt
+		err                  error
 	)
 	if len(config.SQLConfig.DSN) == 0 {
 		return nil, fmt.Errorf("attempted to connect to %v database without a valid DSN", config.Type)
 	}
+
+	tableConfig := config.SQLConfig.TableConfig
+	if len(tableConfig.TypeCol) == 0 || len(tableConfig.MetaCol) == 0 {
+		return nil, fmt.Errorf("attempted to connect to %v database without type and meta columns", config.Type)
+	}
+
 	db, err = sql.Open(config.Type, config.SQLConfig.DSN)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = addMissingColumn(db, tableConfig.Name, tableConfig.TypeCol, "VARCHAR(255)"); err != nil {
+		return nil, err
+	}
+	metaType := "MEDIUMTEXT"
+	if config.Type == "postgres" {
+		metaType = "TEXT"
+	}
+	if err = addMissingColumn(db, tableConfig.Name, tableConfig.MetaCol, metaType); err != nil {
+		return nil, err
+	}
+
 	/* Now we set up prepared statements. This ensures at initialization that we can successfully
 	 * connect to the database.
 	 */
 
-	switch config.Type {
-	case "postgres":
-		createStr = "INSERT INTO %v (%v, %v) VALUES ($1, $2)"
-		updateStr = "UPDATE %v SET %v = $1 WHERE %v = $2"
-		readStr = "SELECT %v FROM %v WHERE %v = $1"
-	default:
-		createStr = "INSERT INTO %v (%v, %v) VALUES (?, ?)"
-		updateStr = "UPDATE %v SET %v = ? WHERE %v = ?"
-		readStr = "SELECT %v FROM %v WHERE %v = ?"
-	}
-
-	create, err = db.Prepare(fmt.Sprintf(createStr,
-		config.SQLConfig.TableConfig.Name,
-		config.SQLConfig.TableConfig.IDCol,
-		config.SQLConfig.TableConfig.ContentCol,
-	))
+	// The columns written and read alongside the ID of each document, in the order of the arguments
+	// of each statement.
+	valueCols := []string{tableConfig.ContentCol, tableConfig.TypeCol, tableConfig.MetaCol}
+
+	placeholder := func(i int) string {
+		if config.Type == "postgres" {
+			return fmt.Sprintf("$%v", i)
+		}
+		return "?"
+	}
+
+	createCols, createVals := []string{tableConfig.IDCol}, []string{placeholder(1)}
+	setCols := []string{}
+	for i, col := range valueCols {
+		createCols = append(createCols, col)
+		createVals = append(createVals, placeholder(i+2))
+		setCols = append(setCols, fmt.Sprintf("%v = %v", col, placeholder(i+1)))
+	}
+
+	create, err = db.Prepare(fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v)",
+		tableConfig.Name, strings.Join(createCols, ", "), strings.Join(createVals, ", ")))
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare create statement: %v", err)
 	}
-	update, err = db.Prepare(fmt.Sprintf(updateStr,
-		config.SQLConfig.TableConfig.Name,
-		config.SQLConfig.TableConfig.ContentCol,
-		config.SQLConfig.TableConfig.IDCol,
-	))
+	update, err = db.Prepare(fmt.Sprintf("UPDATE %v SET %v WHERE %v = %v",
+		tableConfig.Name, strings.Join(setCols, ", "), tableConfig.IDCol, placeholder(len(valueCols)+1)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare update statement: %v", err)
 	}
-	read, err = db.Prepare(fmt.Sprintf(readStr,
-		config.SQLConfig.TableConfig.ContentCol,
-		config.SQLConfig.TableConfig.Name,
-		config.SQLConfig.TableConfig.IDCol,
-	))
+	read, err = db.Prepare(fmt.Sprintf("SELECT %v FROM %v WHERE %v = %v",
+		strings.Join(valueCols, ", "), tableConfig.Name, tableConfig.IDCol, placeholder(1)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare get statement: %v", err)
 	}
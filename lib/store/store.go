@@ -39,6 +39,7 @@ type Config struct {
 	StoreDirectory string             `json:"store_directory" yaml:"store_directory"`
 	SQLConfig      SQLConfig          `json:"sql" yaml:"sql"`
 	AzureBlobStore AzureStorageConfig `json:"azure" yaml:"azure"`
+	FileWatcher    FileWatcherConfig  `json:"file_watcher" yaml:"file_watcher"`
 }
 
 /*
@@ -50,6 +51,7 @@ func NewConfig() Config {
 		Name:           "",
 		StoreDirectory: "",
 		SQLConfig:      NewSQLConfig(),
+		FileWatcher:    NewFileWatcherConfig(),
 	}
 }
 
@@ -107,11 +109,12 @@ var (
 )
 
 /*
-MemoryStore - Most basic implementation of , simply keeps the document in memory. Has
-zero persistence across sessions.
+MemoryStore - Most basic implementation of , simply keeps the document, and optionally its journal,
+in memory. Has zero persistence across sessions.
 */
 type MemoryStore struct {
 	documents map[string]Document
+	journals  map[string][]JournalEntry
 	mutex     sync.RWMutex
 }
 
@@ -0,0 +1,345 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the write-ahead log.
+var (
+	ErrWALMismatch = errors.New(
+		"write-ahead log does not match the stored content of the document, it may have been modified")
+)
+
+// The file extension of write-ahead logs, and of logs that could not be replayed.
+const (
+	walExtension       = ".wal"
+	walFailedExtension = ".failed"
+)
+
+/*
+walRecord - A line of a write-ahead log, which is either a transform that was applied to the document,
+or a mark holding the checksum of the content that was about to be written to the store.
+*/
+type walRecord struct {
+	Checksum  *uint32     `json:"checksum,omitempty"`
+	Transform *OTransform `json:"transform,omitempty"`
+}
+
+/*
+writeAheadLog - An append-only log of the transforms applied to a document since it was last stored,
+which is kept in a local file so that the transforms survive a crash. Each record is synced to disk
+before it is acknowledged.
+
+The log begins with a mark, which is the checksum of the stored content. Before content is written to
+the store a further mark is appended, and once the store is updated the log is rewritten to hold only
+that mark. When the log is replayed only the transforms following the last mark that matches the
+stored content are applied, and so a crash at any point between these steps loses nothing.
+*/
+type writeAheadLog struct {
+	path string
+	file *os.File
+}
+
+/*
+walPath - Returns the path of the write-ahead log of a document within a directory.
+*/
+func walPath(directory, id string) string {
+	return filepath.Join(directory, url.PathEscape(id)+walExtension)
+}
+
+/*
+openWAL - Opens the write-ahead log of a document for appending, creating the directory if needed.
+*/
+func openWAL(directory, id string) (*writeAheadLog, error) {
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("cannot create write-ahead log directory: %v", err)
+	}
+	path := walPath(directory, id)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %v", err)
+	}
+	return &writeAheadLog{path: path, file: file}, nil
+}
+
+/*
+write - Appends a record to the log and syncs it to disk.
+*/
+func (w *writeAheadLog) write(record walRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = w.file.Write(append(recordBytes, '\n')); err != nil {
+		return fmt.Errorf("failed to write to write-ahead log: %v", err)
+	}
+	return w.file.Sync()
+}
+
+/*
+appendTransform - Appends a transform to the log.
+*/
+func (w *writeAheadLog) appendTransform(ot OTransform) error {
+	return w.write(walRecord{Transform: &ot})
+}
+
+/*
+mark - Appends the checksum of content that is about to be written to the store.
+*/
+func (w *writeAheadLog) mark(content string) error {
+	checksum := Checksum(content)
+	return w.write(walRecord{Checksum: &checksum})
+}
+
+/*
+reset - Rewrites the log to hold only the checksum of the stored content. The mark is written to a
+temporary file which then replaces the log.
+*/
+func (w *writeAheadLog) reset(content string) error {
+	checksum := Checksum(content)
+	recordBytes, err := json.Marshal(walRecord{Checksum: &checksum})
+	if err != nil {
+		return err
+	}
+	tmpPath := w.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to reset write-ahead log: %v", err)
+	}
+	if _, err = tmpFile.Write(append(recordBytes, '\n')); err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reset write-ahead log: %v", err)
+	}
+	if err = os.Rename(tmpPath, w.path); err != nil {
+		return fmt.Errorf("failed to reset write-ahead log: %v", err)
+	}
+	w.file.Close()
+	if w.file, err = os.OpenFile(w.path, os.O_APPEND|os.O_WRONLY, 0666); err != nil {
+		return fmt.Errorf("failed to open write-ahead log: %v", err)
+	}
+	return nil
+}
+
+/*
+close - Closes the log, and removes it when the document has been fully stored.
+*/
+func (w *writeAheadLog) close(remove bool) error {
+	err := w.file.Close()
+	if remove {
+		if rmErr := os.Remove(w.path); rmErr != nil && !os.IsNotExist(rmErr) {
+			return rmErr
+		}
+	}
+	return err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ReplayWAL - Replays every write-ahead log within a directory into the store, this must be called
+before any binders are opened for the documents. Logs are removed once replayed. A log that cannot be
+replayed, such as one that does not match the stored content of its document, is corrupt or belongs
+to a document that no longer exists, is renamed with the extension .failed and left for manual
+inspection, and the remaining logs are still replayed.
+*/
+func ReplayWAL(
+	directory string,
+	block store.Store,
+	config ModelConfig,
+	log *log.Logger,
+	stats metrics.Aggregator,
+) error {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read write-ahead log directory: %v", err)
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, walExtension) {
+			continue
+		}
+		id, err := url.PathUnescape(strings.TrimSuffix(name, walExtension))
+		if err != nil {
+			log.Errorf("Skipping write-ahead log with invalid name %v: %v\n", name, err)
+			continue
+		}
+		recoverDocumentWAL(directory, id, block, config, log, stats)
+	}
+	return nil
+}
+
+/*
+recoverDocumentWAL - Replays the write-ahead log of a document into the store if one exists. A log
+that cannot be replayed is renamed with the extension .failed and left for manual inspection, so that
+the document can still be opened from the store.
+*/
+func recoverDocumentWAL(
+	directory, id string,
+	block store.Store,
+	config ModelConfig,
+	log *log.Logger,
+	stats metrics.Aggregator,
+) {
+	err := replayDocumentWAL(directory, id, block, config, log, stats)
+	if err == nil {
+		return
+	}
+	log.Errorf("Setting aside write-ahead log of document %v: %v\n", id, err)
+	path := walPath(directory, id)
+	if err = os.Rename(path, path+walFailedExtension); err != nil {
+		log.Errorf("Failed to set aside write-ahead log of document %v: %v\n", id, err)
+	}
+}
+
+/*
+replayDocumentWAL - Replays the write-ahead log of a document into the store if one exists, and then
+removes it. Returns an error if the log could not be replayed, in which case it is left in place.
+*/
+func replayDocumentWAL(
+	directory, id string,
+	block store.Store,
+	config ModelConfig,
+	log *log.Logger,
+	stats metrics.Aggregator,
+) error {
+	path := walPath(directory, id)
+	records, err := readWAL(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		stats.Incr("wal.replay.error", 1)
+		return fmt.Errorf("failed to read write-ahead log: %v", err)
+	}
+
+	doc, err := block.Read(id)
+	if err != nil {
+		stats.Incr("wal.replay.error", 1)
+		return fmt.Errorf("failed to read document: %v", err)
+	}
+
+	// Find the last mark that matches the stored content, the following transforms are missing.
+	checksum, start := Checksum(doc.Content), -1
+	for i, record := range records {
+		if record.Checksum != nil && *record.Checksum == checksum {
+			start = i + 1
+		}
+	}
+	if start < 0 {
+		stats.Incr("wal.replay.mismatch", 1)
+		return ErrWALMismatch
+	}
+
+	model, err := NewModel(doc.Type, config)
+	if err != nil {
+		stats.Incr("wal.replay.error", 1)
+		return err
+	}
+	replayed := 0
+	for _, record := range records[start:] {
+		if record.Transform == nil {
+			continue
+		}
+		ot := *record.Transform
+		ot.Version = model.GetVersion() + 1
+		if _, _, err = model.PushTransform(ot); err != nil {
+			stats.Incr("wal.replay.error", 1)
+			return fmt.Errorf("failed to replay transform: %v", err)
+		}
+		replayed++
+	}
+	if replayed > 0 {
+		if _, err = model.FlushTransforms(&doc.Content, 0); err != nil {
+			stats.Incr("wal.replay.error", 1)
+			return fmt.Errorf("failed to replay transforms: %v", err)
+		}
+		if err = block.Update(doc); err != nil {
+			stats.Incr("wal.replay.error", 1)
+			return fmt.Errorf("failed to store replayed document: %v", err)
+		}
+		log.Infof("Replayed %v transforms from write-ahead log of document %v\n", replayed, id)
+	}
+	stats.Incr("wal.replay.success", 1)
+	return os.Remove(path)
+}
+
+/*
+readWAL - Reads the records of a write-ahead log. A partially written final record, which is left
+behind by a crash during a write, was never acknowledged and is ignored.
+*/
+func readWAL(path string) ([]walRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []walRecord{}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Only complete lines were synced, anything else is an interrupted write.
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var record walRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,298 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestWALReplayStories(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	dir, err := ioutil.TempDir("", "leaps_wal")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	first := OTransform{Position: 5, Insert: " there", Version: 2}
+	second := OTransform{Position: 11, Insert: ",", Version: 3}
+
+	type walStory struct {
+		stored string
+		write  func(w *writeAheadLog) error
+		result string
+	}
+	stories := []walStory{
+		// Crash before anything was flushed.
+		{"hello world", func(w *writeAheadLog) error {
+			if err := w.appendTransform(first); err != nil {
+				return err
+			}
+			return w.appendTransform(second)
+		}, "hello there, world"},
+		// Crash after marking but before the store was updated.
+		{"hello world", func(w *writeAheadLog) error {
+			if err := w.appendTransform(first); err != nil {
+				return err
+			}
+			if err := w.mark("hello there world"); err != nil {
+				return err
+			}
+			return w.appendTransform(second)
+		}, "hello there, world"},
+		// Crash after the store was updated but before the log was reset.
+		{"hello there world", func(w *writeAheadLog) error {
+			if err := w.appendTransform(first); err != nil {
+				return err
+			}
+			if err := w.mark("hello there world"); err != nil {
+				return err
+			}
+			return w.appendTransform(second)
+		}, "hello there, world"},
+		// Crash after the log was reset.
+		{"hello there world", func(w *writeAheadLog) error {
+			if err := w.reset("hello there world"); err != nil {
+				return err
+			}
+			return w.appendTransform(second)
+		}, "hello there, world"},
+	}
+
+	for i, story := range stories {
+		memStore, _ := store.GetMemoryStore(store.NewConfig())
+		memStore.Create(store.Document{ID: "WAL/doc", Content: story.stored})
+
+		wal, err := openWAL(dir, "WAL/doc")
+		if err != nil {
+			t.Fatalf("Error %v: %v", i, err)
+		}
+		if err = wal.reset("hello world"); err != nil {
+			t.Fatalf("Error %v: %v", i, err)
+		}
+		if err = story.write(wal); err != nil {
+			t.Fatalf("Error %v: %v", i, err)
+		}
+		wal.close(false)
+
+		if err = ReplayWAL(dir, memStore, DefaultModelConfig(), logger, stats); err != nil {
+			t.Errorf("Error %v: %v", i, err)
+			continue
+		}
+		doc, _ := memStore.Read("WAL/doc")
+		if doc.Content != story.result {
+			t.Errorf("Wrong result %v: %v != %v", i, doc.Content, story.result)
+		}
+		if _, err = os.Stat(walPath(dir, "WAL/doc")); !os.IsNotExist(err) {
+			t.Errorf("Write-ahead log was not removed %v: %v", i, err)
+		}
+	}
+}
+
+func TestWALReplayMismatch(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	dir, err := ioutil.TempDir("", "leaps_wal")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "MISMATCH", Content: "changed by someone else"})
+
+	wal, err := openWAL(dir, "MISMATCH")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	wal.reset("hello world")
+	wal.appendTransform(OTransform{Position: 0, Insert: "oh ", Version: 2})
+	wal.close(false)
+
+	// A partially written record is left by a crash during a write.
+	file, _ := os.OpenFile(walPath(dir, "MISMATCH"), os.O_APPEND|os.O_WRONLY, 0666)
+	file.WriteString(`{"transform":{"posi`)
+	file.Close()
+
+	if err = ReplayWAL(dir, memStore, DefaultModelConfig(), logger, stats); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if doc, _ := memStore.Read("MISMATCH"); doc.Content != "changed by someone else" {
+		t.Errorf("Mismatched log was replayed: %v", doc.Content)
+	}
+	if _, err = os.Stat(walPath(dir, "MISMATCH") + walFailedExtension); err != nil {
+		t.Errorf("Mismatched log was not set aside: %v", err)
+	}
+}
+
+func TestWALReplayFailures(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	dir, err := ioutil.TempDir("", "leaps_wal")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	for _, id := range []string{"CORRUPT", "DELETED", "VALID"} {
+		if id != "DELETED" {
+			memStore.Create(store.Document{ID: id, Content: "hello world"})
+		}
+		wal, err := openWAL(dir, id)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		wal.reset("hello world")
+		if id == "CORRUPT" {
+			wal.file.WriteString("not a record\n")
+		}
+		wal.appendTransform(OTransform{Position: 0, Insert: "oh ", Version: 2})
+		wal.close(false)
+	}
+
+	if err = ReplayWAL(dir, memStore, DefaultModelConfig(), logger, stats); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	for _, id := range []string{"CORRUPT", "DELETED"} {
+		if _, err = os.Stat(walPath(dir, id) + walFailedExtension); err != nil {
+			t.Errorf("Failed log of %v was not set aside: %v", id, err)
+		}
+	}
+	if doc, _ := memStore.Read("CORRUPT"); doc.Content != "hello world" {
+		t.Errorf("Corrupt log was replayed: %v", doc.Content)
+	}
+	if doc, _ := memStore.Read("VALID"); doc.Content != "oh hello world" {
+		t.Errorf("Valid log was not replayed: %v", doc.Content)
+	}
+	if _, err = os.Stat(walPath(dir, "VALID")); !os.IsNotExist(err) {
+		t.Errorf("Write-ahead log was not removed: %v", err)
+	}
+}
+
+func TestBinderWAL(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	dir, err := ioutil.TempDir("", "leaps_wal")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "WAL", Content: "hello world"})
+
+	config := DefaultBinderConfig()
+	config.WALDirectory = dir
+
+	// Never flush, in order to simulate a crash with unflushed transforms.
+	crashConfig := config
+	crashConfig.FlushPeriod = 3600000
+
+	crashed, err := NewBinder("WAL", memStore, crashConfig, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	portal := crashed.Subscribe("test")
+	for i, tform := range []OTransform{
+		{Position: 5, Insert: " there"},
+		{Position: 11, Insert: ","},
+	} {
+		tform.Version = portal.Version + 1 + i
+		if _, err = portal.SendTransform(tform, time.Second); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+	}
+	if doc, _ := memStore.Read("WAL"); doc.Content != "hello world" {
+		t.Fatalf("Transforms were flushed: %v", doc.Content)
+	}
+
+	// Copy the log of the crashed binder into a fresh directory, as it is still open.
+	replayDir := filepath.Join(dir, "replay")
+	os.MkdirAll(replayDir, os.ModePerm)
+	walBytes, err := ioutil.ReadFile(walPath(dir, "WAL"))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	ioutil.WriteFile(walPath(replayDir, "WAL"), walBytes, 0666)
+
+	config.WALDirectory = replayDir
+	binder, err := NewBinder("WAL", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if exp := "hello there, world"; binder.Subscribe("test").Document.Content != exp {
+		t.Errorf("Wrong content after replay: %v != %v", binder.Subscribe("test").Document.Content, exp)
+	}
+
+	binder.Close()
+	if _, err = os.Stat(walPath(replayDir, "WAL")); !os.IsNotExist(err) {
+		t.Errorf("Write-ahead log was not removed on close: %v", err)
+	}
+}
+
+func TestBinderWALMismatch(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	logger, stats := loggerAndStats()
+
+	dir, err := ioutil.TempDir("", "leaps_wal")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(store.Document{ID: "MISMATCH", Content: "edited by hand"})
+
+	wal, err := openWAL(dir, "MISMATCH")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	wal.reset("hello world")
+	wal.appendTransform(OTransform{Position: 0, Insert: "oh ", Version: 2})
+	wal.close(false)
+
+	config := DefaultBinderConfig()
+	config.WALDirectory = dir
+
+	binder, err := NewBinder("MISMATCH", memStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer binder.Close()
+
+	if exp := "edited by hand"; binder.Subscribe("test").Document.Content != exp {
+		t.Errorf("Wrong content: %v != %v", binder.Subscribe("test").Document.Content, exp)
+	}
+	if _, err = os.Stat(walPath(dir, "MISMATCH") + walFailedExtension); err != nil {
+		t.Errorf("Mismatched log was not set aside: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+endpoint - Sends the events of its queue to a webhook endpoint in order, one at a time, from a
+goroutine of its own.
+*/
+type endpoint struct {
+	config EndpointConfig
+	events map[string]struct{}
+	queue  *payloadQueue
+	client *http.Client
+	log    *log.Logger
+	stats  metrics.Aggregator
+
+	maxRetries     int
+	retryPeriod    time.Duration
+	maxRetryPeriod time.Duration
+
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+/*
+newEndpoint - Creates an endpoint and launches its goroutine. When a queue directory is set the queue
+of the endpoint is kept in a directory within it named after the URL of the endpoint.
+*/
+func newEndpoint(
+	config EndpointConfig, webhookConfig Config, logger *log.Logger, stats metrics.Aggregator,
+) (*endpoint, error) {
+	queueDir := ""
+	if len(webhookConfig.QueueDirectory) > 0 {
+		urlHash := sha256.Sum256([]byte(config.URL))
+		queueDir = filepath.Join(webhookConfig.QueueDirectory, hex.EncodeToString(urlHash[:8]))
+	}
+	queue, err := newPayloadQueue(queueDir, webhookConfig.QueueSize, stats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open webhook queue of %v: %v", config.URL, err)
+	}
+
+	e := &endpoint{
+		config: config,
+		queue:  queue,
+		client: &http.Client{
+			Timeout: time.Duration(webhookConfig.RequestTimeout) * time.Millisecond,
+		},
+		log:            logger,
+		stats:          stats,
+		maxRetries:     webhookConfig.MaxRetries,
+		retryPeriod:    time.Duration(webhookConfig.RetryPeriod) * time.Millisecond,
+		maxRetryPeriod: time.Duration(webhookConfig.MaxRetryPeriod) * time.Millisecond,
+		closeChan:      make(chan struct{}),
+		closedChan:     make(chan struct{}),
+	}
+	if len(config.Events) > 0 {
+		e.events = map[string]struct{}{}
+		for _, event := range config.Events {
+			e.events[event] = struct{}{}
+		}
+	}
+	go e.loop()
+	return e, nil
+}
+
+/*
+accepts - Returns true if events of a type are sent to the endpoint.
+*/
+func (e *endpoint) accepts(event string) bool {
+	if e.events == nil {
+		return true
+	}
+	_, ok := e.events[event]
+	return ok
+}
+
+/*
+push - Adds an event to the queue of the endpoint, the event is dropped if the queue is full.
+*/
+func (e *endpoint) push(payload Payload) {
+	if err := e.queue.push(payload); err != nil {
+		e.stats.Incr("webhook.queue.dropped", 1)
+		e.log.Errorf("Dropped %v event for %v: %v\n", payload.Event, e.config.URL, err)
+	}
+}
+
+/*
+close - Stops the endpoint, blocking until a request in progress is finished.
+*/
+func (e *endpoint) close() {
+	close(e.closeChan)
+	<-e.closedChan
+}
+
+/*
+loop - Sends each event of the queue until the endpoint is closed. An event is only removed from the
+queue once it was sent or the retries were exhausted, and so an event being retried when the
+endpoint closes remains in the queue.
+*/
+func (e *endpoint) loop() {
+	defer close(e.closedChan)
+	for {
+		select {
+		case <-e.closeChan:
+			return
+		default:
+		}
+		payload, ok := e.queue.front()
+		if !ok {
+			select {
+			case <-e.queue.notifyChan:
+			case <-e.closeChan:
+				return
+			}
+			continue
+		}
+		if !e.deliver(payload) {
+			return
+		}
+		if err := e.queue.pop(); err != nil {
+			e.log.Errorf("Failed to remove sent event from webhook queue: %v\n", err)
+		}
+	}
+}
+
+/*
+deliver - Sends an event, retrying with backoff when the request fails. Returns false if the endpoint
+was closed before the event was sent.
+*/
+func (e *endpoint) deliver(payload Payload) bool {
+	period := e.retryPeriod
+	for attempt := 0; ; attempt++ {
+		retry, err := e.request(payload)
+		if err == nil {
+			e.stats.Incr("webhook.send.success", 1)
+			return true
+		}
+		if !retry || attempt >= e.maxRetries {
+			e.stats.Incr("webhook.send.error", 1)
+			e.log.Errorf("Failed to send %v event to %v: %v\n", payload.Event, e.config.URL, err)
+			return true
+		}
+		e.stats.Incr("webhook.send.retry", 1)
+		e.log.Warnf("Failed to send %v event to %v: %v, retrying\n", payload.Event, e.config.URL, err)
+		select {
+		case <-time.After(period):
+		case <-e.closeChan:
+			return false
+		}
+		if period *= 2; period > e.maxRetryPeriod {
+			period = e.maxRetryPeriod
+		}
+	}
+}
+
+/*
+request - Makes a single request for an event, and returns whether a failed request should be
+retried. Requests are retried after connection errors, server errors and responses that ask for the
+request to be sent later.
+*/
+func (e *endpoint) request(payload Payload) (bool, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest("POST", e.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Leaps-Event", payload.Event)
+	req.Header.Set("X-Leaps-Delivery", payload.ID)
+	if len(e.config.Secret) > 0 {
+		req.Header.Set("X-Leaps-Signature", Sign(e.config.Secret, body))
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("endpoint responded with status: %v", res.Status)
+	}
+	return false, fmt.Errorf("endpoint responded with status: %v", res.Status)
+}
+
+/*
+Sign - Returns the signature of a request body as sent in the X-Leaps-Signature header, which is
+"sha256=" followed by the hex encoded HMAC-SHA256 of the body using the secret of the endpoint.
+*/
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffail/util/metrics"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the payloadQueue type.
+var (
+	ErrQueueFull = errors.New("webhook queue is full")
+)
+
+// The extension of the file of each event in a queue directory.
+const queueExtension = ".json"
+
+/*
+queuedPayload - An event waiting in a queue, and the file it is stored in when the queue has a
+directory.
+*/
+type queuedPayload struct {
+	payload Payload
+	path    string
+}
+
+/*
+payloadQueue - A bounded queue of events, which is optionally kept in a directory with a file for
+each event. Files are named after the time they were queued, so that the order of the queue is kept
+when it is loaded.
+*/
+type payloadQueue struct {
+	dir  string
+	size int
+
+	mut        sync.Mutex
+	items      []queuedPayload
+	seq        int
+	notifyChan chan struct{}
+}
+
+/*
+newPayloadQueue - Creates a queue, loading the events left in its directory. Files that cannot be
+parsed are removed, as are the oldest events beyond the size of the queue.
+*/
+func newPayloadQueue(dir string, size int, stats metrics.Aggregator) (*payloadQueue, error) {
+	q := &payloadQueue{
+		dir:        dir,
+		size:       size,
+		notifyChan: make(chan struct{}, 1),
+	}
+	if len(dir) == 0 {
+		return q, nil
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasSuffix(info.Name(), queueExtension) {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	if size > 0 && len(names) > size {
+		for _, name := range names[:len(names)-size] {
+			os.Remove(filepath.Join(dir, name))
+			stats.Incr("webhook.queue.dropped", 1)
+		}
+		names = names[len(names)-size:]
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		var payload Payload
+		payloadBytes, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(payloadBytes, &payload)
+		}
+		if err != nil {
+			os.Remove(path)
+			stats.Incr("webhook.queue.corrupt", 1)
+			continue
+		}
+		q.items = append(q.items, queuedPayload{payload: payload, path: path})
+	}
+	if len(q.items) > 0 {
+		q.notifyChan <- struct{}{}
+	}
+	return q, nil
+}
+
+/*
+push - Adds an event to the back of the queue, writing it to a file first when the queue has a
+directory.
+*/
+func (q *payloadQueue) push(payload Payload) error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if q.size > 0 && len(q.items) >= q.size {
+		return ErrQueueFull
+	}
+	item := queuedPayload{payload: payload}
+	if len(q.dir) > 0 {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		q.seq++
+		item.path = filepath.Join(q.dir, fmt.Sprintf(
+			"%020d-%06d%v", time.Now().UnixNano(), q.seq%1000000, queueExtension,
+		))
+		tmpPath := item.path + ".tmp"
+		if err = ioutil.WriteFile(tmpPath, payloadBytes, 0666); err != nil {
+			return err
+		}
+		if err = os.Rename(tmpPath, item.path); err != nil {
+			return err
+		}
+	}
+	q.items = append(q.items, item)
+
+	select {
+	case q.notifyChan <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+/*
+front - Returns the event at the front of the queue, if there is one.
+*/
+func (q *payloadQueue) front() (Payload, bool) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if len(q.items) == 0 {
+		return Payload{}, false
+	}
+	return q.items[0].payload, true
+}
+
+/*
+pop - Removes the event at the front of the queue, and its file.
+*/
+func (q *payloadQueue) pop() error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if len(q.items) == 0 {
+		return nil
+	}
+	item := q.items[0]
+	q.items = q.items[1:]
+	if len(item.path) > 0 {
+		if err := os.Remove(item.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,314 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/util"
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Events that can be sent to webhook endpoints.
+const (
+	EventCreated     = "created"
+	EventOpened      = "opened"
+	EventEdited      = "edited"
+	EventFlushed     = "flushed"
+	EventFlushFailed = "flush_failed"
+	EventClosed      = "closed"
+)
+
+/*
+events - The webhook event of each binder event that is sent to endpoints, edits are debounced
+separately.
+*/
+var events = map[string]string{
+	lib.EventDocumentCreated: EventCreated,
+	lib.EventBinderOpened:    EventOpened,
+	lib.EventFlushSucceeded:  EventFlushed,
+	lib.EventFlushFailed:     EventFlushFailed,
+	lib.EventBinderClosed:    EventClosed,
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+EndpointConfig - The configuration of a single webhook endpoint. Events is the list of events sent to
+the endpoint, all events are sent when it is empty. When a secret is set the body of each request is
+signed with it, and the signature is sent in the X-Leaps-Signature header as "sha256=" followed by
+the hex encoded HMAC-SHA256 of the body.
+*/
+type EndpointConfig struct {
+	URL    string   `json:"url" yaml:"url"`
+	Secret string   `json:"secret" yaml:"secret"`
+	Events []string `json:"events" yaml:"events"`
+}
+
+/*
+Config - Holds configuration options for webhooks. Edits of a document are debounced, so that a single
+edited event is sent once a document has gone without edits for the debounce period.
+
+Events waiting to be sent are held in a queue for each endpoint, the queue size is the number of
+events that can wait for an endpoint before further events are dropped. When a queue directory is set
+the queues are also written to disk within it, and events that were not sent before closing are sent
+after the next start. A request that fails is retried up to the maximum retries, with a period
+between attempts that starts at the retry period and doubles up to the maximum retry period.
+*/
+type Config struct {
+	Endpoints      []EndpointConfig `json:"endpoints" yaml:"endpoints"`
+	EditDebounce   int64            `json:"edit_debounce_ms" yaml:"edit_debounce_ms"`
+	QueueSize      int              `json:"queue_size" yaml:"queue_size"`
+	QueueDirectory string           `json:"queue_directory" yaml:"queue_directory"`
+	RequestTimeout int64            `json:"request_timeout_ms" yaml:"request_timeout_ms"`
+	MaxRetries     int              `json:"max_retries" yaml:"max_retries"`
+	RetryPeriod    int64            `json:"retry_period_ms" yaml:"retry_period_ms"`
+	MaxRetryPeriod int64            `json:"max_retry_period_ms" yaml:"max_retry_period_ms"`
+}
+
+/*
+NewConfig - Returns a default webhook configuration, which has no endpoints.
+*/
+func NewConfig() Config {
+	return Config{
+		Endpoints:      []EndpointConfig{},
+		EditDebounce:   5000,
+		QueueSize:      1000,
+		QueueDirectory: "",
+		RequestTimeout: 5000,
+		MaxRetries:     5,
+		RetryPeriod:    1000,
+		MaxRetryPeriod: 60000,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Dispatcher type.
+var (
+	ErrNoURL        = errors.New("webhook endpoint has no URL")
+	ErrUnknownEvent = errors.New("webhook endpoint filters an unknown event")
+)
+
+/*
+Payload - The JSON body of a webhook request. The ID is unique to each event and is also sent in the
+X-Leaps-Delivery header, allowing receivers to ignore retried deliveries. An edited event carries the
+number of transforms since the last edited event of the document and the users that submitted them,
+and the version of the last transform. The user of a created event is its creator.
+*/
+type Payload struct {
+	ID         string   `json:"id"`
+	Event      string   `json:"event"`
+	DocumentID string   `json:"document_id"`
+	UserID     string   `json:"user_id,omitempty"`
+	Users      []string `json:"users,omitempty"`
+	Edits      int      `json:"edits,omitempty"`
+	Version    int      `json:"version"`
+	Timestamp  int64    `json:"timestamp"`
+	Error      string   `json:"error,omitempty"`
+}
+
+/*
+pendingEdits - The edits of a document that are waiting for the debounce period to elapse.
+*/
+type pendingEdits struct {
+	payload Payload
+	users   map[string]struct{}
+	timer   *time.Timer
+}
+
+/*
+Dispatcher - Sends events to webhook endpoints, implements lib.BinderObserver and should be
+registered with a curator in order to observe all documents.
+*/
+type Dispatcher struct {
+	config    Config
+	log       *log.Logger
+	stats     metrics.Aggregator
+	endpoints []*endpoint
+
+	mut     sync.Mutex
+	edits   map[string]*pendingEdits
+	closing bool
+}
+
+/*
+NewDispatcher - Creates a dispatcher and launches a goroutine for each endpoint, which begins by
+sending the events left in the queue directory by a previous run.
+*/
+func NewDispatcher(config Config, logger *log.Logger, stats metrics.Aggregator) (*Dispatcher, error) {
+	d := &Dispatcher{
+		config: config,
+		log:    logger.NewModule(":webhook"),
+		stats:  stats,
+		edits:  map[string]*pendingEdits{},
+	}
+	for _, endpointConfig := range config.Endpoints {
+		if len(endpointConfig.URL) == 0 {
+			return nil, ErrNoURL
+		}
+		for _, event := range endpointConfig.Events {
+			switch event {
+			case EventCreated, EventOpened, EventEdited, EventFlushed, EventFlushFailed, EventClosed:
+			default:
+				return nil, fmt.Errorf("%v: %v", ErrUnknownEvent, event)
+			}
+		}
+	}
+	for _, endpointConfig := range config.Endpoints {
+		e, err := newEndpoint(endpointConfig, config, d.log, stats)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.endpoints = append(d.endpoints, e)
+	}
+	return d, nil
+}
+
+/*
+OnBinderEvent - Sends a binder event to the endpoints that accept it, edits are debounced.
+*/
+func (d *Dispatcher) OnBinderEvent(event lib.BinderEvent) {
+	switch event.Type {
+	case lib.EventTransformApplied:
+		d.addEdit(event)
+		return
+	case lib.EventBinderClosed:
+		// Edits are announced before the document is closed.
+		d.flushEdits(event.DocumentID)
+	}
+	if name, ok := events[event.Type]; ok {
+		d.send(Payload{
+			Event:      name,
+			DocumentID: event.DocumentID,
+			UserID:     event.UserID,
+			Version:    event.Version,
+			Timestamp:  event.Timestamp,
+			Error:      event.Error,
+		})
+	}
+}
+
+/*
+addEdit - Adds an edit to the pending edits of its document, the debounce period of the document is
+restarted.
+*/
+func (d *Dispatcher) addEdit(event lib.BinderEvent) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if d.closing {
+		return
+	}
+	pending, ok := d.edits[event.DocumentID]
+	if !ok {
+		pending = &pendingEdits{
+			payload: Payload{Event: EventEdited, DocumentID: event.DocumentID},
+			users:   map[string]struct{}{},
+		}
+		documentID := event.DocumentID
+		pending.timer = time.AfterFunc(time.Duration(d.config.EditDebounce)*time.Millisecond, func() {
+			d.flushEdits(documentID)
+		})
+		d.edits[event.DocumentID] = pending
+	} else {
+		pending.timer.Reset(time.Duration(d.config.EditDebounce) * time.Millisecond)
+	}
+	pending.payload.Edits++
+	pending.payload.Version = event.Version
+	pending.payload.Timestamp = event.Timestamp
+	if len(event.UserID) > 0 {
+		pending.users[event.UserID] = struct{}{}
+	}
+}
+
+/*
+flushEdits - Sends the pending edits of a document, if there are any.
+*/
+func (d *Dispatcher) flushEdits(documentID string) {
+	d.mut.Lock()
+	pending, ok := d.edits[documentID]
+	if ok {
+		pending.timer.Stop()
+		delete(d.edits, documentID)
+	}
+	d.mut.Unlock()
+
+	if !ok {
+		return
+	}
+	payload := pending.payload
+	for user := range pending.users {
+		payload.Users = append(payload.Users, user)
+	}
+	sort.Strings(payload.Users)
+	d.send(payload)
+}
+
+/*
+send - Adds an event to the queue of each endpoint that accepts it.
+*/
+func (d *Dispatcher) send(payload Payload) {
+	payload.ID = util.GenerateStampedUUID()
+	for _, e := range d.endpoints {
+		if e.accepts(payload.Event) {
+			e.push(payload)
+		}
+	}
+}
+
+/*
+Close - Sends the pending edits of all documents and stops the endpoints. The events remaining in the
+queue of an endpoint are lost unless a queue directory is set.
+*/
+func (d *Dispatcher) Close() {
+	d.mut.Lock()
+	d.closing = true
+	documentIDs := []string{}
+	for documentID := range d.edits {
+		documentIDs = append(documentIDs, documentID)
+	}
+	d.mut.Unlock()
+
+	for _, documentID := range documentIDs {
+		d.flushEdits(documentID)
+	}
+	for _, e := range d.endpoints {
+		e.close()
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
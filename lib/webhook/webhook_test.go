@@ -0,0 +1,335 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/util/log"
+	"github.com/jeffail/util/metrics"
+)
+
+func loggerAndStats() (*log.Logger, metrics.Aggregator) {
+	logConf := log.DefaultLoggerConfig()
+	logConf.LogLevel = "OFF"
+
+	return log.NewLogger(os.Stdout, logConf), metrics.DudType{}
+}
+
+/*
+receiver - Records the requests of webhooks, responding with the status of each request in turn and
+then with 200 once they run out.
+*/
+type receiver struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	payloads []Payload
+	headers  []http.Header
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newReceiver(statuses ...int) *receiver {
+	r := &receiver{statuses: statuses, received: make(chan struct{}, 100)}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+
+		r.mutex.Lock()
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		if status == http.StatusOK {
+			var payload Payload
+			json.Unmarshal(body, &payload)
+			r.payloads = append(r.payloads, payload)
+			r.headers = append(r.headers, req.Header)
+			r.bodies = append(r.bodies, body)
+		}
+		r.mutex.Unlock()
+
+		w.WriteHeader(status)
+		r.received <- struct{}{}
+	}))
+	return r
+}
+
+func (r *receiver) events() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	events := []string{}
+	for _, payload := range r.payloads {
+		events = append(events, payload.Event)
+	}
+	return events
+}
+
+func (r *receiver) wait(t *testing.T, requests int) {
+	for i := 0; i < requests; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for request %v", i)
+		}
+	}
+}
+
+func TestDispatcherEvents(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	all, filtered := newReceiver(), newReceiver()
+	defer all.server.Close()
+	defer filtered.server.Close()
+
+	config := NewConfig()
+	config.EditDebounce = 3600000
+	config.Endpoints = []EndpointConfig{
+		{URL: all.server.URL},
+		{URL: filtered.server.URL, Secret: "shh", Events: []string{EventEdited, EventClosed}},
+	}
+	dispatcher, err := NewDispatcher(config, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer dispatcher.Close()
+
+	for _, event := range []lib.BinderEvent{
+		{Type: lib.EventDocumentCreated, DocumentID: "doc", UserID: "a"},
+		{Type: lib.EventBinderOpened, DocumentID: "doc"},
+		{Type: lib.EventClientJoined, DocumentID: "doc", UserID: "a", Version: 1},
+		{Type: lib.EventTransformApplied, DocumentID: "doc", UserID: "b", Version: 2},
+		{Type: lib.EventTransformApplied, DocumentID: "doc", UserID: "a", Version: 3},
+		{Type: lib.EventTransformApplied, DocumentID: "doc", UserID: "b", Version: 4},
+		{Type: lib.EventFlushSucceeded, DocumentID: "doc", Version: 4},
+		{Type: lib.EventBinderClosed, DocumentID: "doc", Version: 4},
+	} {
+		dispatcher.OnBinderEvent(event)
+	}
+	all.wait(t, 5)
+	filtered.wait(t, 2)
+
+	// Edits are sent before the document closes, even when the debounce period has not elapsed.
+	exp := []string{EventCreated, EventOpened, EventFlushed, EventEdited, EventClosed}
+	if events := all.events(); !reflect.DeepEqual(events, exp) {
+		t.Errorf("Wrong events: %v != %v", events, exp)
+	}
+	exp = []string{EventEdited, EventClosed}
+	if events := filtered.events(); !reflect.DeepEqual(events, exp) {
+		t.Errorf("Wrong filtered events: %v != %v", events, exp)
+	}
+
+	edited := filtered.payloads[0]
+	if edited.DocumentID != "doc" || edited.Edits != 3 || edited.Version != 4 ||
+		!reflect.DeepEqual(edited.Users, []string{"a", "b"}) {
+		t.Errorf("Wrong edited event: %+v", edited)
+	}
+	for i, header := range filtered.headers {
+		if sig := header.Get("X-Leaps-Signature"); sig != Sign("shh", filtered.bodies[i]) {
+			t.Errorf("Wrong signature: %v", sig)
+		}
+		if header.Get("X-Leaps-Event") != filtered.payloads[i].Event ||
+			header.Get("X-Leaps-Delivery") != filtered.payloads[i].ID {
+			t.Errorf("Wrong headers: %v", header)
+		}
+	}
+	if sig := all.headers[0].Get("X-Leaps-Signature"); len(sig) > 0 {
+		t.Errorf("Unexpected signature without a secret: %v", sig)
+	}
+
+	if _, err = NewDispatcher(Config{
+		Endpoints: []EndpointConfig{{URL: all.server.URL, Events: []string{"nope"}}},
+	}, logger, stats); err == nil {
+		t.Error("Expected error from unknown event")
+	}
+}
+
+func TestDispatcherDebounce(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	recv := newReceiver()
+	defer recv.server.Close()
+
+	config := NewConfig()
+	config.EditDebounce = 50
+	config.Endpoints = []EndpointConfig{{URL: recv.server.URL}}
+
+	dispatcher, err := NewDispatcher(config, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer dispatcher.Close()
+
+	for i := 0; i < 5; i++ {
+		dispatcher.OnBinderEvent(lib.BinderEvent{
+			Type: lib.EventTransformApplied, DocumentID: "doc", UserID: "a", Version: i + 2,
+		})
+		time.Sleep(time.Millisecond * 10)
+	}
+	recv.wait(t, 1)
+
+	recv.mutex.Lock()
+	defer recv.mutex.Unlock()
+	if len(recv.payloads) != 1 || recv.payloads[0].Edits != 5 || recv.payloads[0].Version != 6 {
+		t.Errorf("Wrong debounced edits: %+v", recv.payloads)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	recv := newReceiver(
+		http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK,
+		http.StatusBadRequest,
+	)
+	defer recv.server.Close()
+
+	config := NewConfig()
+	config.RetryPeriod = 10
+	config.Endpoints = []EndpointConfig{{URL: recv.server.URL}}
+
+	dispatcher, err := NewDispatcher(config, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer dispatcher.Close()
+
+	dispatcher.OnBinderEvent(lib.BinderEvent{Type: lib.EventBinderOpened, DocumentID: "first"})
+	dispatcher.OnBinderEvent(lib.BinderEvent{Type: lib.EventBinderOpened, DocumentID: "second"})
+	dispatcher.OnBinderEvent(lib.BinderEvent{Type: lib.EventBinderOpened, DocumentID: "third"})
+
+	// The second event is rejected and not retried.
+	recv.wait(t, 5)
+
+	recv.mutex.Lock()
+	defer recv.mutex.Unlock()
+	if len(recv.payloads) != 2 || recv.payloads[0].DocumentID != "first" ||
+		recv.payloads[1].DocumentID != "third" {
+		t.Errorf("Wrong delivered events: %+v", recv.payloads)
+	}
+}
+
+func TestDispatcherQueueDirectory(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	dir, err := ioutil.TempDir("", "leaps_webhooks")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	recv := newReceiver(http.StatusServiceUnavailable)
+	defer recv.server.Close()
+
+	config := NewConfig()
+	config.QueueSize = 2
+	config.QueueDirectory = dir
+	config.RetryPeriod = 3600000
+	config.Endpoints = []EndpointConfig{{URL: recv.server.URL}}
+
+	dispatcher, err := NewDispatcher(config, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	for _, id := range []string{"first", "second", "third"} {
+		dispatcher.OnBinderEvent(lib.BinderEvent{Type: lib.EventBinderClosed, DocumentID: id})
+	}
+	recv.wait(t, 1)
+	dispatcher.Close()
+
+	if events := recv.events(); len(events) != 0 {
+		t.Fatalf("Unexpected delivered events: %v", events)
+	}
+
+	// The queue is bounded, and survives until the next dispatcher sends it.
+	if dispatcher, err = NewDispatcher(config, logger, stats); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer dispatcher.Close()
+	recv.wait(t, 2)
+
+	recv.mutex.Lock()
+	defer recv.mutex.Unlock()
+	if len(recv.payloads) != 2 || recv.payloads[0].DocumentID != "first" ||
+		recv.payloads[1].DocumentID != "second" {
+		t.Errorf("Wrong delivered events: %+v", recv.payloads)
+	}
+}
+
+func TestDispatcherCurator(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	recv := newReceiver()
+	defer recv.server.Close()
+
+	config := NewConfig()
+	config.Endpoints = []EndpointConfig{{URL: recv.server.URL}}
+
+	dispatcher, err := NewDispatcher(config, logger, stats)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer dispatcher.Close()
+
+	storage, _ := store.Factory(store.NewConfig())
+	authenticator, _ := auth.Factory(auth.NewConfig(), logger, stats)
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, authenticator, storage)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	curator.AddObserver(dispatcher)
+
+	doc, _ := store.NewDocument("hello world")
+	portal, err := curator.CreateDocument("creator", "", *doc)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if _, err = portal.SendTransform(lib.OTransform{
+		Position: 0, Insert: "A: ", Version: portal.Version + 1,
+	}, time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	curator.Close()
+	recv.wait(t, 5)
+
+	exp := []string{EventCreated, EventOpened, EventFlushed, EventEdited, EventClosed}
+	if events := recv.events(); !reflect.DeepEqual(events, exp) {
+		t.Errorf("Wrong events: %v != %v", events, exp)
+	}
+	if created := recv.payloads[0]; created.UserID != "creator" ||
+		created.DocumentID != portal.Document.ID {
+		t.Errorf("Wrong created event: %+v", created)
+	}
+}
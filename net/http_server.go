@@ -27,7 +27,9 @@ import (
 	"fmt"
 	"net/http"
 	"path"
+	"time"
 
+	"github.com/jeffail/leaps/lib"
 	"github.com/jeffail/leaps/lib/store"
 	"github.com/jeffail/util/log"
 	"github.com/jeffail/util/metrics"
@@ -59,10 +61,17 @@ func NewSSLConfig() SSLConfig {
 }
 
 /*
-HTTPBinderConfig - Options for individual binders (one for each socket connection)
+HTTPBinderConfig - Options for individual binders (one for each socket connection). Transforms
+received within the batch period are sent to the client as a single message, with adjacent inserts
+and deletions merged, batching is disabled when the period is zero. A websocket ping is sent to the
+client every ping period, and the connection is closed when the client sends nothing, not even a
+pong, for twice the period or when a write takes longer than that. Pings are disabled when the
+period is zero.
 */
 type HTTPBinderConfig struct {
-	BindSendTimeout int `json:"bind_send_timeout_ms" yaml:"bind_send_timeout_ms"`
+	BindSendTimeout      int `json:"bind_send_timeout_ms" yaml:"bind_send_timeout_ms"`
+	TransformBatchPeriod int `json:"transform_batch_period_ms" yaml:"transform_batch_period_ms"`
+	PingPeriod           int `json:"ping_period_ms" yaml:"ping_period_ms"`
 }
 
 /*
@@ -89,7 +98,9 @@ func DefaultHTTPServerConfig() HTTPServerConfig {
 		Address:        "localhost:8080",
 		StaticFilePath: "",
 		Binder: HTTPBinderConfig{
-			BindSendTimeout: 100,
+			BindSendTimeout:      100,
+			TransformBatchPeriod: 0,
+			PingPeriod:           20000,
 		},
 		SSL:      NewSSLConfig(),
 		HTTPAuth: NewAuthMiddlewareConfig(),
@@ -101,25 +112,30 @@ func DefaultHTTPServerConfig() HTTPServerConfig {
 
 /*
 LeapClientMessage - A structure that defines a message format to expect from clients. Commands can
-be 'create' (init with new document) or 'find' (init with existing document).
+be 'create' (init with new document) or 'find' (init with existing document). The display name and
+colour of the client are optional, and are shown in the records of the clients of the document.
 */
 type LeapClientMessage struct {
-	Command  string          `json:"command"`
-	Token    string          `json:"token"`
-	DocID    string          `json:"document_id,omitempty"`
-	UserID   string          `json:"user_id"`
-	Document *store.Document `json:"leap_document,omitempty"`
+	Command     string          `json:"command"`
+	Token       string          `json:"token"`
+	DocID       string          `json:"document_id,omitempty"`
+	UserID      string          `json:"user_id"`
+	DisplayName string          `json:"display_name,omitempty"`
+	Colour      string          `json:"colour,omitempty"`
+	Document    *store.Document `json:"leap_document,omitempty"`
 }
 
 /*
 LeapServerMessage - A structure that defines a response message from the server to a client. Type
-can be 'document' (init response) or 'error' (an error message to display to the client).
+can be 'document' (init response) or 'error' (an error message to display to the client). An error
+may carry a code, see LeapSocketServerMessage.
 */
 type LeapServerMessage struct {
 	Type     string          `json:"response_type"`
 	Document *store.Document `json:"leap_document,omitempty"`
 	Version  *int            `json:"version,omitempty"`
 	Error    string          `json:"error,omitempty"`
+	Code     string          `json:"code,omitempty"`
 }
 
 /*--------------------------------------------------------------------------------------------------
@@ -230,6 +246,7 @@ func (h *HTTPServer) websocketHandler(ws *websocket.Conn) {
 		websocket.JSON.Send(ws, LeapServerMessage{
 			Type:  "error",
 			Error: fmt.Sprintf("socket initialization failed: %v", err),
+			Code:  errorCode(err),
 		})
 	}
 
@@ -254,8 +271,7 @@ func (h *HTTPServer) websocketHandler(ws *websocket.Conn) {
 					Document: &binder.Document,
 					Version:  &binder.Version,
 				})
-				socketRouter := NewWebsocketServer(h.config.Binder, ws, binder, h.closeChan, h.logger, h.stats)
-				socketRouter.Launch()
+				h.launchSocket(ws, binder, clientMsg)
 			} else {
 				handleInitError(err)
 			}
@@ -277,8 +293,7 @@ func (h *HTTPServer) websocketHandler(ws *websocket.Conn) {
 					Document: &binder.Document,
 					Version:  &binder.Version,
 				})
-				socketRouter := NewWebsocketServer(h.config.Binder, ws, binder, h.closeChan, h.logger, h.stats)
-				socketRouter.Launch()
+				h.launchSocket(ws, binder, clientMsg)
 			} else {
 				handleInitError(err)
 			}
@@ -300,8 +315,7 @@ func (h *HTTPServer) websocketHandler(ws *websocket.Conn) {
 					Document: &binder.Document,
 					Version:  &binder.Version,
 				})
-				socketRouter := NewWebsocketServer(h.config.Binder, ws, binder, h.closeChan, h.logger, h.stats)
-				socketRouter.Launch()
+				h.launchSocket(ws, binder, clientMsg)
 			} else {
 				handleInitError(err)
 			}
@@ -315,6 +329,29 @@ func (h *HTTPServer) websocketHandler(ws *websocket.Conn) {
 	}
 }
 
+/*
+launchSocket - Sets the metadata of a client that was bound to a document, and launches the router
+between its websocket and binder.
+*/
+func (h *HTTPServer) launchSocket(ws *websocket.Conn, binder lib.BinderPortal, clientMsg LeapClientMessage) {
+	metadata := lib.ClientMetadata{
+		ClientProfile: lib.ClientProfile{
+			DisplayName: clientMsg.DisplayName,
+			Colour:      clientMsg.Colour,
+		},
+	}
+	if req := ws.Request(); req != nil {
+		metadata.RemoteAddress = req.RemoteAddr
+	}
+	if err := binder.SetMetadata(
+		metadata, time.Duration(h.config.Binder.BindSendTimeout)*time.Millisecond,
+	); err != nil {
+		h.logger.Errorf("Failed to set client metadata: %v\n", err)
+	}
+	socketRouter := NewWebsocketServer(h.config.Binder, ws, binder, h.closeChan, h.logger, h.stats)
+	socketRouter.Launch()
+}
+
 /*
 Listen - Bind to the http endpoint as per configured address, and begin serving requests. This is
 simply a helper function that calls http.ListenAndServe
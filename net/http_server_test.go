@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -329,3 +330,411 @@ func TestHttpServer(t *testing.T) {
 
 	curator.Close()
 }
+
+func TestHttpServerBatchedTransforms(t *testing.T) {
+	httpServerConfig := DefaultHTTPServerConfig()
+	httpServerConfig.Address = "localhost:8255"
+	httpServerConfig.StaticPath = "/batched"
+	httpServerConfig.Path = "/batched/socket"
+	httpServerConfig.Binder.TransformBatchPeriod = 100
+
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, auth, storage)
+	if err != nil {
+		t.Errorf("Curator error: %v", err)
+		return
+	}
+	defer curator.Close()
+
+	go func() {
+		http, err := CreateHTTPServer(curator, httpServerConfig, logger, stats)
+		if err != nil {
+			t.Errorf("Create HTTP error: %v", err)
+			return
+		}
+		if err = http.Listen(); err != nil {
+			t.Errorf("Listen error: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	origin := "http://localhost/"
+	url := "ws://localhost:8255/batched/socket"
+
+	sender, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Errorf("client connect error: %v", err)
+		return
+	}
+	defer sender.Close()
+
+	websocket.JSON.Send(sender, LeapClientMessage{
+		Command:  "create",
+		UserID:   "test",
+		Document: &store.Document{Content: ""},
+	})
+	var initResponse LeapServerMessage
+	if err = websocket.JSON.Receive(sender, &initResponse); err != nil || initResponse.Type != "document" {
+		t.Errorf("Init error: %v, %v", err, initResponse)
+		return
+	}
+
+	receiver, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Errorf("client connect error: %v", err)
+		return
+	}
+	defer receiver.Close()
+
+	if err = findDocument(initResponse.Document.ID, "test2", receiver); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	nTransforms := 20
+	for i := 0; i < nTransforms; i++ {
+		websocket.JSON.Send(sender, LeapSocketClientMessage{
+			Command:   "submit",
+			Transform: &lib.OTransform{Position: i, Insert: "a", Version: *initResponse.Version + 1 + i},
+		})
+		var serverMsg LeapSocketServerMessage
+		if err = websocket.JSON.Receive(sender, &serverMsg); err != nil || serverMsg.Type != "correction" {
+			t.Errorf("Correction error: %v, %v", err, serverMsg)
+			return
+		}
+	}
+
+	content, versions, frames := "", 0, 0
+	for versions < nTransforms {
+		receiver.SetReadDeadline(time.Now().Add(time.Second))
+		var serverMsg LeapSocketServerMessage
+		if err = websocket.JSON.Receive(receiver, &serverMsg); err != nil {
+			t.Errorf("Receive error: %v", err)
+			return
+		}
+		if serverMsg.Type != "transforms" {
+			continue
+		}
+		frames++
+		for _, tform := range serverMsg.Transforms {
+			if versions++; tform.Span > 1 {
+				versions += tform.Span - 1
+			}
+			content = content[:tform.Position] + tform.Insert + content[tform.Position+tform.Delete:]
+		}
+	}
+	if frames >= nTransforms {
+		t.Errorf("Transforms were not batched: %v frames", frames)
+	}
+	if exp := strings.Repeat("a", nTransforms); content != exp {
+		t.Errorf("Wrong content from batched transforms: %v != %v", content, exp)
+	}
+}
+
+func TestHttpServerIdleClients(t *testing.T) {
+	httpServerConfig := DefaultHTTPServerConfig()
+	httpServerConfig.Address = "localhost:8256"
+	httpServerConfig.StaticPath = "/idle"
+	httpServerConfig.Path = "/idle/socket"
+	// Pings are disabled so that only the idle timeout of the binder disconnects clients.
+	httpServerConfig.Binder.PingPeriod = 0
+
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curatorConfig := lib.DefaultCuratorConfig()
+	curatorConfig.BinderConfig.ClientIdleTimeout = 200
+
+	curator, err := lib.NewCurator(curatorConfig, logger, stats, auth, storage)
+	if err != nil {
+		t.Errorf("Curator error: %v", err)
+		return
+	}
+	defer curator.Close()
+
+	go func() {
+		http, err := CreateHTTPServer(curator, httpServerConfig, logger, stats)
+		if err != nil {
+			t.Errorf("Create HTTP error: %v", err)
+			return
+		}
+		if err = http.Listen(); err != nil {
+			t.Errorf("Listen error: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	origin := "http://localhost/"
+	url := "ws://localhost:8256/idle/socket"
+
+	active, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Errorf("client connect error: %v", err)
+		return
+	}
+	defer active.Close()
+
+	websocket.JSON.Send(active, LeapClientMessage{
+		Command:  "create",
+		UserID:   "active",
+		Document: &store.Document{Content: ""},
+	})
+	var initResponse LeapServerMessage
+	if err = websocket.JSON.Receive(active, &initResponse); err != nil || initResponse.Type != "document" {
+		t.Errorf("Init error: %v, %v", err, initResponse)
+		return
+	}
+
+	idle, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Errorf("client connect error: %v", err)
+		return
+	}
+	defer idle.Close()
+
+	if err = findDocument(initResponse.Document.ID, "idle", idle); err != nil {
+		t.Errorf("%v", err)
+		return
+	}
+
+	stopPings := make(chan struct{})
+	defer close(stopPings)
+	go func() {
+		for {
+			select {
+			case <-time.After(50 * time.Millisecond):
+				websocket.JSON.Send(active, LeapSocketClientMessage{Command: "ping"})
+			case <-stopPings:
+				return
+			}
+		}
+	}()
+
+	idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var kicked LeapSocketServerMessage
+	if err = websocket.JSON.Receive(idle, &kicked); err != nil || kicked.Type != "kicked" || kicked.Reason != "inactive" {
+		t.Errorf("Expected kick of idle client: %v, %v", err, kicked)
+		return
+	}
+
+	active.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var serverMsg LeapSocketServerMessage
+		if err = websocket.JSON.Receive(active, &serverMsg); err != nil {
+			t.Errorf("Receive error: %v", err)
+			return
+		}
+		if serverMsg.Type != "update" || len(serverMsg.Updates) == 0 {
+			continue
+		}
+		update := serverMsg.Updates[0]
+		if update.Client.UserID == "idle" && !update.Message.Active {
+			break
+		}
+	}
+}
+
+func TestHttpServerPings(t *testing.T) {
+	httpServerConfig := DefaultHTTPServerConfig()
+	httpServerConfig.Address = "localhost:8257"
+	httpServerConfig.StaticPath = "/pings"
+	httpServerConfig.Path = "/pings/socket"
+	httpServerConfig.Binder.PingPeriod = 100
+
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, auth, storage)
+	if err != nil {
+		t.Errorf("Curator error: %v", err)
+		return
+	}
+	defer curator.Close()
+
+	go func() {
+		http, err := CreateHTTPServer(curator, httpServerConfig, logger, stats)
+		if err != nil {
+			t.Errorf("Create HTTP error: %v", err)
+			return
+		}
+		if err = http.Listen(); err != nil {
+			t.Errorf("Listen error: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	origin := "http://localhost/"
+	url := "ws://localhost:8257/pings/socket"
+
+	watcher, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Errorf("client connect error: %v", err)
+		return
+	}
+	defer watcher.Close()
+
+	websocket.JSON.Send(watcher, LeapClientMessage{
+		Command:  "create",
+		UserID:   "watcher",
+		Document: &store.Document{Content: ""},
+	})
+	var initResponse LeapServerMessage
+	if err = websocket.JSON.Receive(watcher, &initResponse); err != nil || initResponse.Type != "document" {
+		t.Errorf("Init error: %v, %v", err, initResponse)
+		return
+	}
+
+	// The silent client sends nothing but answers pings while it reads, the dead client never reads
+	// and so never answers.
+	clients := map[string]*websocket.Conn{}
+	for _, userID := range []string{"silent", "dead"} {
+		ws, err := websocket.Dial(url, "", origin)
+		if err != nil {
+			t.Errorf("client connect error: %v", err)
+			return
+		}
+		defer ws.Close()
+		if err = findDocument(initResponse.Document.ID, userID, ws); err != nil {
+			t.Errorf("%v", err)
+			return
+		}
+		clients[userID] = ws
+	}
+
+	silentErr := make(chan error, 1)
+	go func() {
+		var serverMsg LeapSocketServerMessage
+		for {
+			if err := websocket.JSON.Receive(clients["silent"], &serverMsg); err != nil {
+				silentErr <- err
+				return
+			}
+		}
+	}()
+
+	watcher.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var serverMsg LeapSocketServerMessage
+		if err = websocket.JSON.Receive(watcher, &serverMsg); err != nil {
+			t.Errorf("Receive error: %v", err)
+			return
+		}
+		if serverMsg.Type != "update" || len(serverMsg.Updates) == 0 {
+			continue
+		}
+		update := serverMsg.Updates[0]
+		if update.Message.Active {
+			continue
+		}
+		if update.Client.UserID == "silent" {
+			t.Error("Silent client that answers pings was disconnected")
+			return
+		}
+		if update.Client.UserID == "dead" {
+			break
+		}
+	}
+
+	// The silent client outlives several ping periods.
+	select {
+	case err = <-silentErr:
+		t.Errorf("Silent client was disconnected: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestHttpServerRateLimits(t *testing.T) {
+	httpServerConfig := DefaultHTTPServerConfig()
+	httpServerConfig.Address = "localhost:8258"
+	httpServerConfig.StaticPath = "/limited"
+	httpServerConfig.Path = "/limited/socket"
+
+	curatorConfig := lib.DefaultCuratorConfig()
+	curatorConfig.BinderConfig.TransformRateLimit = lib.RateLimitConfig{Rate: 0.001, Burst: 1}
+	curatorConfig.BinderConfig.RateLimitKickAfter = 3
+
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(curatorConfig, logger, stats, auth, storage)
+	if err != nil {
+		t.Errorf("Curator error: %v", err)
+		return
+	}
+	defer curator.Close()
+
+	go func() {
+		http, err := CreateHTTPServer(curator, httpServerConfig, logger, stats)
+		if err != nil {
+			t.Errorf("Create HTTP error: %v", err)
+			return
+		}
+		if err = http.Listen(); err != nil {
+			t.Errorf("Listen error: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	ws, err := websocket.Dial("ws://localhost:8258/limited/socket", "", "http://localhost/")
+	if err != nil {
+		t.Errorf("client connect error: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	websocket.JSON.Send(ws, LeapClientMessage{
+		Command:  "create",
+		UserID:   "limited",
+		Document: &store.Document{Content: ""},
+	})
+	var initResponse LeapServerMessage
+	if err = websocket.JSON.Receive(ws, &initResponse); err != nil || initResponse.Type != "document" {
+		t.Errorf("Init error: %v, %v", err, initResponse)
+		return
+	}
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	receive := func(responseType string) (LeapSocketServerMessage, error) {
+		for {
+			var serverMsg LeapSocketServerMessage
+			if err := websocket.JSON.Receive(ws, &serverMsg); err != nil {
+				return serverMsg, err
+			}
+			if serverMsg.Type == responseType {
+				return serverMsg, nil
+			}
+		}
+	}
+
+	// The first submission fits within the burst, the rest are rejected until the third offence.
+	for i := 0; i < 4; i++ {
+		websocket.JSON.Send(ws, LeapSocketClientMessage{
+			Command:   "submit",
+			Transform: &lib.OTransform{Position: 0, Insert: "a", Version: 2},
+		})
+		if i == 3 {
+			break
+		}
+		expected := "error"
+		if i == 0 {
+			expected = "correction"
+		}
+		serverMsg, err := receive(expected)
+		if err != nil {
+			t.Errorf("Client %v was disconnected: %v", i, err)
+			return
+		}
+		if i > 0 && serverMsg.Code != "rate_limited" {
+			t.Errorf("Wrong error %v: %v, %v", i, serverMsg.Error, serverMsg.Code)
+		}
+	}
+	if _, err = receive("kicked"); err != nil {
+		t.Errorf("Client was not kicked: %v", err)
+	}
+}
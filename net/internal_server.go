@@ -25,10 +25,12 @@ package net
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"path"
 	"time"
 
+	"github.com/jeffail/leaps/lib"
 	"github.com/jeffail/util/log"
 	"github.com/jeffail/util/metrics"
 	binpath "github.com/jeffail/util/path"
@@ -139,90 +141,227 @@ func (i *InternalServer) registerEndpoints() {
 	// Register /stats for metrics
 	i.Register("/stats", "<GET> Returns a JSON blob of the server metrics", i.stats.JSONHandler())
 
-	// Register /kick_user endpoint for kicking users from documents
-	/*
-		i.Register("/kick_user", `<POST> Kick a user from a document {"user_id":"<id>","doc_id":"<id>"}`,
-			func(w http.ResponseWriter, r *http.Request) {
-				if r.Method != "POST" {
-					i.stats.Incr("http_admin.kick_user.error", 1)
-					i.logger.Warnf("/kick_user: Wrong method %v\n", r.Method)
-					http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
-					return
-				}
-
-				bodyBytes, err := ioutil.ReadAll(r.Body)
-				if err != nil {
-					i.stats.Incr("http_admin.kick_user.error", 1)
-					i.logger.Errorf("/kick_user: %v\n", err)
-					http.Error(w, "Bad data", http.StatusBadRequest)
-					return
-				}
-
-				dataObj := struct {
-					UserID string `json:"user_id"`
-					DocID  string `json:"doc_id"`
-				}{}
-				if err := json.Unmarshal(bodyBytes, &dataObj); err != nil {
-					i.stats.Incr("http_admin.kick_user.error", 1)
-					i.logger.Errorf("/kick_user: %v\n", err)
-					http.Error(w, "Bad data", http.StatusBadRequest)
-					return
-				}
-
-				if err := i.admin.KickUser(
-					dataObj.DocID,
-					dataObj.UserID,
-					time.Second*time.Duration(i.config.RequestTimeout),
-				); err != nil {
-					i.stats.Incr("http_admin.kick_user.error", 1)
-					i.logger.Errorf("/kick_user: %v\n", err)
-					http.Error(w, "Error kicking user", http.StatusInternalServerError)
-					return
-				}
-
-				i.stats.Incr("http_admin.kick_user.success", 1)
-				i.logger.Infof("/kick_user: Kicked user %v from %v\n", dataObj.UserID, dataObj.DocID)
-
-				fmt.Fprintf(w, "Success")
-			})
-	*/
+	// Register /kick_user endpoint for kicking users or sessions from documents
+	moderator, isModerator := i.admin.(LeapModerator)
+	kickDesc := `<POST> Kick a user from a document {"doc_id":"<id>","user_id":"<id>"}`
+	if isModerator {
+		kickDesc = `<POST> Kick a user or session from a document {"doc_id":"<id>","user_id":"<id>","session_id":"<id>","reason":"<text>"}`
+	}
+	i.Register("/kick_user", kickDesc, func(w http.ResponseWriter, r *http.Request) {
+		dataObj := struct {
+			DocID     string `json:"doc_id"`
+			UserID    string `json:"user_id"`
+			SessionID string `json:"session_id"`
+			Reason    string `json:"reason"`
+		}{}
+		if !i.readPost("kick_user", w, r, &dataObj) {
+			return
+		}
+		if len(dataObj.UserID) == 0 && len(dataObj.SessionID) == 0 {
+			i.stats.Incr("http_admin.kick_user.error", 1)
+			http.Error(w, "Either user_id or session_id is required", http.StatusBadRequest)
+			return
+		}
+		if !isModerator && (len(dataObj.SessionID) > 0 || len(dataObj.Reason) > 0) {
+			i.stats.Incr("http_admin.kick_user.error", 1)
+			http.Error(w, "Kicking sessions or with reasons is not supported", http.StatusBadRequest)
+			return
+		}
+
+		timeout := time.Second * time.Duration(i.config.RequestTimeout)
+
+		var err error
+		if !isModerator {
+			err = i.admin.KickUser(dataObj.DocID, dataObj.UserID, timeout)
+		} else if len(dataObj.SessionID) > 0 {
+			err = moderator.KickSession(dataObj.DocID, dataObj.SessionID, dataObj.Reason, timeout)
+		} else {
+			err = moderator.KickUserWithReason(dataObj.DocID, dataObj.UserID, dataObj.Reason, timeout)
+		}
+		if err != nil {
+			i.stats.Incr("http_admin.kick_user.error", 1)
+			i.logger.Errorf("/kick_user: %v\n", err)
+			if err == lib.ErrBinderNotFound || err == lib.ErrClientNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			} else {
+				http.Error(w, "Error kicking user", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		i.stats.Incr("http_admin.kick_user.success", 1)
+		i.logger.Infof("/kick_user: Kicked user %v session %v from %v\n",
+			dataObj.UserID, dataObj.SessionID, dataObj.DocID)
+
+		fmt.Fprintf(w, "Success")
+	})
 
 	// Register /get_users endpoint for listing users connected to all open documents
 	i.Register(
 		"/get_users",
 		`<GET> Get a list of all connected users {"<document_id1>":["<id1>","<id2>"],"<document_id2":["<id3>"]}`,
 		func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != "GET" {
-				i.stats.Incr("http_admin.get_users.error", 1)
-				i.logger.Warnf("/get_users: Wrong method %v\n", r.Method)
-				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+			i.serveList("get_users", w, r, func(timeout time.Duration) (interface{}, int, error) {
+				users, err := i.admin.GetUsers(timeout)
+				return users, len(users), err
+			})
+		})
+
+	if isModerator {
+		i.registerModeratorEndpoints(moderator)
+	}
+}
+
+/*
+registerModeratorEndpoints - Register the endpoints that are only available when the admin
+implements LeapModerator.
+*/
+func (i *InternalServer) registerModeratorEndpoints(moderator LeapModerator) {
+	// Register /ban_user endpoint for banning users from documents
+	i.Register(
+		"/ban_user",
+		`<POST> Ban a user from a document, or all documents without a doc_id, for a period of seconds or until lifted when zero {"doc_id":"<id>","user_id":"<id>","reason":"<text>","period_s":<n>}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			dataObj := struct {
+				DocID  string `json:"doc_id"`
+				UserID string `json:"user_id"`
+				Reason string `json:"reason"`
+				Period int64  `json:"period_s"`
+			}{}
+			if !i.readPost("ban_user", w, r, &dataObj) {
+				return
+			}
+			if dataObj.Period < 0 {
+				i.stats.Incr("http_admin.ban_user.error", 1)
+				http.Error(w, "Bad data", http.StatusBadRequest)
 				return
 			}
 
-			resultObj, err := i.admin.GetUsers(time.Second * time.Duration(i.config.RequestTimeout))
-			if err != nil {
-				i.stats.Incr("http_admin.get_users.error", 1)
-				i.logger.Errorf("/get_users: %v\n", err)
-				http.Error(w, "Error collecting users", http.StatusInternalServerError)
+			if err := moderator.BanUser(
+				dataObj.DocID,
+				dataObj.UserID,
+				dataObj.Reason,
+				time.Second*time.Duration(dataObj.Period),
+				time.Second*time.Duration(i.config.RequestTimeout),
+			); err != nil {
+				i.stats.Incr("http_admin.ban_user.error", 1)
+				i.logger.Errorf("/ban_user: %v\n", err)
+				http.Error(w, "Error banning user", http.StatusInternalServerError)
 				return
 			}
 
-			resultBytes, err := json.Marshal(resultObj)
-			if err != nil {
-				i.stats.Incr("http_admin.get_users.error", 1)
-				i.logger.Errorf("/get_users: %v\n", err)
-				http.Error(w, "Error collecting users", http.StatusInternalServerError)
+			i.stats.Incr("http_admin.ban_user.success", 1)
+			i.logger.Infof("/ban_user: Banned user %v from %v\n", dataObj.UserID, dataObj.DocID)
+
+			fmt.Fprintf(w, "Success")
+		})
+
+	// Register /unban_user endpoint for lifting bans of users
+	i.Register(
+		"/unban_user",
+		`<POST> Lift the ban of a user from a document, or all documents without a doc_id {"doc_id":"<id>","user_id":"<id>"}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			dataObj := struct {
+				DocID  string `json:"doc_id"`
+				UserID string `json:"user_id"`
+			}{}
+			if !i.readPost("unban_user", w, r, &dataObj) {
 				return
 			}
 
-			i.stats.Incr("http_admin.get_users.success", 1)
-			i.logger.Debugf("/get_users: sending users for %v documents\n", len(resultObj))
+			if !moderator.UnbanUser(dataObj.DocID, dataObj.UserID) {
+				i.stats.Incr("http_admin.unban_user.error", 1)
+				http.Error(w, "User was not banned", http.StatusNotFound)
+				return
+			}
+
+			i.stats.Incr("http_admin.unban_user.success", 1)
+			i.logger.Infof("/unban_user: Lifted ban of user %v from %v\n", dataObj.UserID, dataObj.DocID)
+
+			fmt.Fprintf(w, "Success")
+		})
 
-			w.Header().Add("Content-Type", "application/json")
-			w.Write(resultBytes)
+	// Register /get_clients endpoint for listing the clients connected to all open documents
+	i.Register(
+		"/get_clients",
+		`<GET> Get the records of all connected clients {"<document_id>":[{"user_id":"<id>","session_id":"<id>",...}]}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			i.serveList("get_clients", w, r, func(timeout time.Duration) (interface{}, int, error) {
+				clients, err := moderator.GetClients(timeout)
+				return clients, len(clients), err
+			})
 		})
 }
 
+/*
+serveList - Responds to a GET request to an endpoint with the JSON encoded result of collect, which
+also returns the number of documents listed.
+*/
+func (i *InternalServer) serveList(
+	endpoint string, w http.ResponseWriter, r *http.Request,
+	collect func(timeout time.Duration) (interface{}, int, error),
+) {
+	if r.Method != "GET" {
+		i.stats.Incr("http_admin."+endpoint+".error", 1)
+		i.logger.Warnf("/%v: Wrong method %v\n", endpoint, r.Method)
+		http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resultObj, numDocs, err := collect(time.Second * time.Duration(i.config.RequestTimeout))
+	if err != nil {
+		i.stats.Incr("http_admin."+endpoint+".error", 1)
+		i.logger.Errorf("/%v: %v\n", endpoint, err)
+		http.Error(w, "Error collecting users", http.StatusInternalServerError)
+		return
+	}
+
+	resultBytes, err := json.Marshal(resultObj)
+	if err != nil {
+		i.stats.Incr("http_admin."+endpoint+".error", 1)
+		i.logger.Errorf("/%v: %v\n", endpoint, err)
+		http.Error(w, "Error collecting users", http.StatusInternalServerError)
+		return
+	}
+
+	i.stats.Incr("http_admin."+endpoint+".success", 1)
+	i.logger.Debugf("/%v: sending users for %v documents\n", endpoint, numDocs)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(resultBytes)
+}
+
+/*
+readPost - Parses the JSON body of a POST request to an endpoint into dataObj, responds with an error
+and returns false if the request was not valid.
+*/
+func (i *InternalServer) readPost(
+	endpoint string, w http.ResponseWriter, r *http.Request, dataObj interface{},
+) bool {
+	if r.Method != "POST" {
+		i.stats.Incr("http_admin."+endpoint+".error", 1)
+		i.logger.Warnf("/%v: Wrong method %v\n", endpoint, r.Method)
+		http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		i.stats.Incr("http_admin."+endpoint+".error", 1)
+		i.logger.Errorf("/%v: %v\n", endpoint, err)
+		http.Error(w, "Bad data", http.StatusBadRequest)
+		return false
+	}
+
+	if err := json.Unmarshal(bodyBytes, dataObj); err != nil {
+		i.stats.Incr("http_admin."+endpoint+".error", 1)
+		i.logger.Errorf("/%v: %v\n", endpoint, err)
+		http.Error(w, "Bad data", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
 
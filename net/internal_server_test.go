@@ -26,8 +26,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/jeffail/leaps/lib"
 )
 
 /*--------------------------------------------------------------------------------------------------
@@ -43,6 +46,30 @@ func (f FakeAdmin) GetUsers(timeout time.Duration) (map[string][]string, error)
 	return map[string][]string{}, nil
 }
 
+type FakeModerator struct {
+	FakeAdmin
+}
+
+func (f FakeModerator) KickUserWithReason(doc, user, reason string, timeout time.Duration) error {
+	return nil
+}
+
+func (f FakeModerator) KickSession(doc, session, reason string, timeout time.Duration) error {
+	return nil
+}
+
+func (f FakeModerator) BanUser(doc, user, reason string, period, timeout time.Duration) error {
+	return nil
+}
+
+func (f FakeModerator) UnbanUser(doc, user string) bool {
+	return true
+}
+
+func (f FakeModerator) GetClients(timeout time.Duration) (map[string][]lib.ClientInfo, error) {
+	return map[string][]lib.ClientInfo{}, nil
+}
+
 func TestEndpointsEndpoint(t *testing.T) {
 	log, stats := loggerAndStats()
 
@@ -50,7 +77,7 @@ func TestEndpointsEndpoint(t *testing.T) {
 	config.Address = "localhost:8767"
 	config.Path = "/internal"
 
-	admin := FakeAdmin{}
+	admin := FakeModerator{}
 
 	internalServer, err := NewInternalServer(admin, config, log, stats)
 	if err != nil {
@@ -80,8 +107,11 @@ func TestEndpointsEndpoint(t *testing.T) {
 
 	expectedEndpoints := "/internal/endpoints: <GET> Lists the available endpoints of this leaps API\n" +
 		`/internal/stats: <GET> Returns a JSON blob of the server metrics` + "\n" +
-		// `/internal/kick_user: <POST> Kick a user from a document {"user_id":"<id>","doc_id":"<id>"}` + "\n" +
+		`/internal/kick_user: <POST> Kick a user or session from a document {"doc_id":"<id>","user_id":"<id>","session_id":"<id>","reason":"<text>"}` + "\n" +
 		`/internal/get_users: <GET> Get a list of all connected users {"<document_id1>":["<id1>","<id2>"],"<document_id2":["<id3>"]}` + "\n" +
+		`/internal/ban_user: <POST> Ban a user from a document, or all documents without a doc_id, for a period of seconds or until lifted when zero {"doc_id":"<id>","user_id":"<id>","reason":"<text>","period_s":<n>}` + "\n" +
+		`/internal/unban_user: <POST> Lift the ban of a user from a document, or all documents without a doc_id {"doc_id":"<id>","user_id":"<id>"}` + "\n" +
+		`/internal/get_clients: <GET> Get the records of all connected clients {"<document_id>":[{"user_id":"<id>","session_id":"<id>",...}]}` + "\n" +
 		"/internal/first: The first endpoint\n" +
 		"/internal/second: The second endpoint\n" +
 		"/internal/third: The third endpoint\n"
@@ -145,3 +175,72 @@ func TestRegisterEndpoint(t *testing.T) {
 
 /*--------------------------------------------------------------------------------------------------
  */
+
+func TestKickUserEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	for _, server := range []struct {
+		admin   LeapAdmin
+		address string
+	}{
+		{FakeModerator{}, "localhost:8769"},
+		{FakeAdmin{}, "localhost:8770"},
+	} {
+		config := NewInternalServerConfig()
+		config.Address = server.address
+		config.Path = "/internal"
+
+		internalServer, err := NewInternalServer(server.admin, config, log, stats)
+		if err != nil {
+			t.Errorf("Error creating server: %v\n", err)
+			return
+		}
+
+		go internalServer.Listen()
+	}
+
+	<-time.After(time.Millisecond * 500)
+
+	kickTests := []struct {
+		address string
+		body    string
+		code    int
+	}{
+		{"localhost:8769", `{"doc_id":"doc"}`, http.StatusBadRequest},
+		{"localhost:8769", `{"doc_id":"doc","user_id":""}`, http.StatusBadRequest},
+		{"localhost:8769", `not json`, http.StatusBadRequest},
+		{"localhost:8769", `{"doc_id":"doc","user_id":"user"}`, http.StatusOK},
+		{"localhost:8769", `{"doc_id":"doc","session_id":"session","reason":"spam"}`, http.StatusOK},
+		{"localhost:8770", `{"doc_id":"doc"}`, http.StatusBadRequest},
+		{"localhost:8770", `{"doc_id":"doc","user_id":"user"}`, http.StatusOK},
+		{"localhost:8770", `{"doc_id":"doc","user_id":"user","reason":"spam"}`, http.StatusBadRequest},
+		{"localhost:8770", `{"doc_id":"doc","session_id":"session"}`, http.StatusBadRequest},
+	}
+
+	for _, test := range kickTests {
+		res, err := http.Post(
+			"http://"+test.address+"/internal/kick_user", "application/json", strings.NewReader(test.body),
+		)
+		if err != nil {
+			t.Errorf("Error posting to server: %v\n", err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != test.code {
+			t.Errorf("Wrong status for %v on %v: %v != %v", test.body, test.address, res.StatusCode, test.code)
+		}
+	}
+
+	// Moderation endpoints are not served for an admin that is not a moderator
+	res, err := http.Post(
+		"http://localhost:8770/internal/ban_user", "application/json", strings.NewReader(`{"user_id":"user"}`),
+	)
+	if err != nil {
+		t.Errorf("Error posting to server: %v\n", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Wrong status for ban_user: %v != %v", res.StatusCode, http.StatusNotFound)
+	}
+}
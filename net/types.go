@@ -64,5 +64,30 @@ type LeapAdmin interface {
 	GetUsers(timeout time.Duration) (map[string][]string, error)
 }
 
+/*
+LeapModerator - An optional extension of LeapAdmin for moderating documents in finer detail, such as
+kicking with reasons, kicking single sessions, banning users and getting the records of connected
+clients per document. Admin endpoints built on it are only served when the admin implements it.
+*/
+type LeapModerator interface {
+	LeapAdmin
+
+	// Kick every client of a user from a document, the reason is sent to each kicked client.
+	KickUserWithReason(documentID, userID, reason string, timeout time.Duration) error
+
+	// Kick the client of a session from a document, the reason is sent to the kicked client.
+	KickSession(documentID, sessionID, reason string, timeout time.Duration) error
+
+	// Ban a user from a document, or from all documents when the documentID is empty, for a period
+	// of time. A period of zero bans the user until the ban is lifted.
+	BanUser(documentID, userID, reason string, period, timeout time.Duration) error
+
+	// Lift the ban of a user from a document, returns false if the user was not banned.
+	UnbanUser(documentID, userID string) bool
+
+	// Get the records of all clients connected to all open binders.
+	GetClients(timeout time.Duration) (map[string][]lib.ClientInfo, error)
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
@@ -23,10 +23,15 @@ THE SOFTWARE.
 package net
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
 	"time"
 
 	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/auth"
 	"github.com/jeffail/leaps/lib/store"
 	"github.com/jeffail/util/log"
 	"github.com/jeffail/util/metrics"
@@ -38,28 +43,82 @@ import (
 
 /*
 LeapSocketClientMessage - A structure that defines a message format to expect from clients connected
-to a text model. Commands can currently be 'submit' (submit a transform to a bound document), or
-'update' (submit an update to the users cursor position).
+to a text model. Commands can currently be 'submit' (submit a transform, which may be compound, to
+a bound document), 'update' (submit an update to the users cursor position, selection or presence
+with multiple cursors), 'undo' (revert the most recent transform submitted by this client), 'redo'
+(reapply the most recently undone transform), 'lock' (lock the range from start to end so that
+only this user, or users with at least the access level, may edit it), 'unlock' (remove a lock),
+'list_locks' (request all locks), 'comment' (start a comment thread on the range from
+start to end with the message as its content), 'reply_comment' (add the message to an existing
+thread), 'resolve_comment' (mark a thread as resolved), 'list_comments' (request all threads),
+'suggest' (propose a transform without applying it), 'accept_suggestion', 'reject_suggestion',
+'list_suggestions' (request all pending suggestions), 'replace' (replace the full content of the
+document, which is applied as an incremental transform) or 'resync' (request the authoritative
+content and version of the document). Updates beyond the rate limit of messages of a client are
+dropped without a response, although they count towards the client being kicked.
 */
 type LeapSocketClientMessage struct {
-	Command   string          `json:"command"`
-	Transform *lib.OTransform `json:"transform,omitempty"`
-	Position  *int64          `json:"position,omitempty"`
-	Message   string          `json:"message,omitempty"`
+	Command      string           `json:"command"`
+	Transform    *lib.OTransform  `json:"transform,omitempty"`
+	Position     *int64           `json:"position,omitempty"`
+	Selection    *lib.Selection   `json:"selection,omitempty"`
+	Presence     *lib.Presence    `json:"presence,omitempty"`
+	Message      string           `json:"message,omitempty"`
+	LockID       string           `json:"lock_id,omitempty"`
+	AccessLevel  auth.AccessLevel `json:"access_level,omitempty"`
+	ThreadID     string           `json:"thread_id,omitempty"`
+	SuggestionID string           `json:"suggestion_id,omitempty"`
+	Start        int              `json:"start,omitempty"`
+	End          int              `json:"end,omitempty"`
+	Content      *string          `json:"content,omitempty"`
 }
 
 /*
 LeapSocketServerMessage - A structure that defines a response message from a text model to a client.
 Type can be 'transforms' (continuous delivery), 'correction' (actual version of a submitted
-transform), 'update' (an update to a users status) or 'error' (an error message to display to the
-client).
+transform), 'update' (an update to a users status, cursors, selections and presence), 'locks' (locks
+that were changed or requested, or an error from a lock command, which is not fatal), 'comments'
+(the same for comment threads), 'suggestions' (the same for suggestions), 'resync' (the
+authoritative content and version of the document), 'external_change' (the stored document was
+modified externally, and the modification was merged and sent as the preceding transforms, or when
+it could not be merged, sent as the preceding resync), 'kicked' (the client was kicked from the
+document for the given reason, and is about to be disconnected) or 'error' (an error message to
+display to the client). Errors that clients may wish to handle differently carry a code, which is
+'rate_limited' when the client exceeded its rate limit of transforms, 'too_many_clients' when the
+document has reached its maximum number of clients, or 'banned' when the user is banned from the
+document. Only a rate limited client remains connected, its submission was rejected and it is kicked
+once it reaches the limit of offences. Corrections, resyncs and each transform carry the checksum of
+the document content at their version, if supported.
 */
 type LeapSocketServerMessage struct {
-	Type       string                  `json:"response_type"`
-	Transforms []lib.OTransform        `json:"transforms,omitempty"`
-	Updates    []lib.MessageSubmission `json:"user_updates,omitempty"`
-	Version    int                     `json:"version,omitempty"`
-	Error      string                  `json:"error,omitempty"`
+	Type        string                  `json:"response_type"`
+	Transforms  []lib.OTransform        `json:"transforms,omitempty"`
+	Updates     []lib.MessageSubmission `json:"user_updates,omitempty"`
+	Locks       []store.Lock            `json:"locks,omitempty"`
+	Comments    []store.CommentThread   `json:"comments,omitempty"`
+	Suggestions []store.Suggestion      `json:"suggestions,omitempty"`
+	Document    *store.Document         `json:"leap_document,omitempty"`
+	Version     int                     `json:"version,omitempty"`
+	Checksum    uint32                  `json:"checksum,omitempty"`
+	Error       string                  `json:"error,omitempty"`
+	Code        string                  `json:"code,omitempty"`
+	Reason      string                  `json:"reason,omitempty"`
+}
+
+/*
+errorCode - Returns the code of an error sent to clients, or an empty string for errors without a
+code.
+*/
+func errorCode(err error) string {
+	switch err {
+	case lib.ErrRateLimited:
+		return "rate_limited"
+	case lib.ErrTooManyClients:
+		return "too_many_clients"
+	case lib.ErrBanned:
+		return "banned"
+	}
+	return ""
 }
 
 /*--------------------------------------------------------------------------------------------------
@@ -75,6 +134,9 @@ type WebsocketServer struct {
 	socket    *websocket.Conn
 	binder    lib.BinderPortal
 	closeChan <-chan bool
+
+	// Guards every write to the socket, along with its write deadline and payload type
+	writeMut sync.Mutex
 }
 
 /*
@@ -113,6 +175,14 @@ func (w *WebsocketServer) Launch() {
 	// TODO: Preserve reference of doc ID?
 	w.binder.Document = store.Document{}
 
+	// Show the new client where everyone else currently is.
+	if len(w.binder.Cursors) > 0 {
+		w.send(LeapSocketServerMessage{
+			Type:    "update",
+			Updates: w.binder.Cursors,
+		})
+	}
+
 	defer func() {
 		w.binder.Exit(bindTOut)
 	}()
@@ -138,6 +208,8 @@ func (w *WebsocketServer) Launch() {
 		})
 	case <-outgoingClosedChan:
 		close(incomingCloseChan)
+		// Unblocks the incoming router when the client is silent, such as a kicked or dead peer.
+		w.socket.Close()
 		<-incomingClosedChan
 		w.binder.SendMessage(lib.Message{
 			Active: false,
@@ -163,7 +235,7 @@ func (w *WebsocketServer) loopIncoming(closeSignalChan chan<- struct{}, closeCmd
 		}
 
 		var msg LeapSocketClientMessage
-		if err := websocket.JSON.Receive(w.socket, &msg); err == nil {
+		if err := w.receive(&msg); err == nil {
 			w.logger.Tracef("Received %v command from client\n", msg.Command)
 
 			timeStarted := time.Now()
@@ -172,7 +244,7 @@ func (w *WebsocketServer) loopIncoming(closeSignalChan chan<- struct{}, closeCmd
 			case "submit":
 				if msg.Transform == nil {
 					w.logger.Errorln("Client submit contained nil transform")
-					websocket.JSON.Send(w.socket, LeapSocketServerMessage{
+					w.send(LeapSocketServerMessage{
 						Type:  "error",
 						Error: "submit error: transform was nil",
 					})
@@ -180,37 +252,130 @@ func (w *WebsocketServer) loopIncoming(closeSignalChan chan<- struct{}, closeCmd
 					closeSignalChan <- struct{}{}
 					return
 				}
-				if ver, err := w.binder.SendTransform(*msg.Transform, bindTOut); err == nil {
+				if ver, sum, err := w.binder.SendTransformChecked(*msg.Transform, bindTOut); err == nil {
 					w.logger.Traceln("Sending correction to client")
-					websocket.JSON.Send(w.socket, LeapSocketServerMessage{
-						Type:    "correction",
-						Version: ver,
+					w.send(LeapSocketServerMessage{
+						Type:     "correction",
+						Version:  ver,
+						Checksum: sum,
 					})
 					w.stats.Incr("http.websocket.submit.success", 1)
 					w.stats.Timing("http.websocket.submit.timer", int(time.Since(timeStarted).Nanoseconds()/1000))
 				} else {
 					w.logger.Errorf("Transform request failed %v\n", err)
-					websocket.JSON.Send(w.socket, LeapSocketServerMessage{
+					w.send(LeapSocketServerMessage{
 						Type:  "error",
 						Error: fmt.Sprintf("submit error: %v", err),
+						Code:  errorCode(err),
 					})
-					w.logger.Debugln("Closing websocket due to failed transform send")
 					w.stats.Incr("http.websocket.submit.error", 1)
+					if err == lib.ErrRateLimited {
+						// The binder kicks clients that keep exceeding their rate limit.
+						continue
+					}
+					w.logger.Debugln("Closing websocket due to failed transform send")
 					closeSignalChan <- struct{}{}
 					return
 				}
 			case "update":
-				if msg.Position != nil || len(msg.Message) > 0 {
+				if msg.Position != nil || msg.Selection != nil || msg.Presence != nil || len(msg.Message) > 0 {
 					w.binder.SendMessage(lib.Message{
-						Content:  msg.Message,
-						Position: msg.Position,
-						Active:   true,
+						Content:   msg.Message,
+						Position:  msg.Position,
+						Selection: msg.Selection,
+						Presence:  msg.Presence,
+						Active:    true,
 					})
 				}
+			case "undo", "redo":
+				var err error
+				if msg.Command == "undo" {
+					_, err = w.binder.Undo(bindTOut)
+				} else {
+					_, err = w.binder.Redo(bindTOut)
+				}
+				switch err {
+				case nil:
+					// The resulting transform is delivered through the outgoing router.
+					w.stats.Incr("http.websocket."+msg.Command+".success", 1)
+				case lib.ErrNothingToUndo, lib.ErrNothingToRedo:
+					w.logger.Debugf("Client %v request ignored: %v\n", msg.Command, err)
+				default:
+					w.logger.Errorf("Client %v request failed %v\n", msg.Command, err)
+					w.send(LeapSocketServerMessage{
+						Type:  "error",
+						Error: fmt.Sprintf("%v error: %v", msg.Command, err),
+						Code:  errorCode(err),
+					})
+					w.stats.Incr("http.websocket."+msg.Command+".error", 1)
+					if err == lib.ErrRateLimited {
+						continue
+					}
+					w.logger.Debugf("Closing websocket due to failed %v\n", msg.Command)
+					closeSignalChan <- struct{}{}
+					return
+				}
+			case "lock", "unlock", "list_locks":
+				w.processLock(msg, bindTOut)
+			case "comment", "reply_comment", "resolve_comment", "list_comments":
+				w.processComment(msg, bindTOut)
+			case "suggest", "accept_suggestion", "reject_suggestion", "list_suggestions":
+				w.processSuggestion(msg, bindTOut)
+			case "replace":
+				if msg.Content == nil {
+					w.logger.Errorln("Client replace contained nil content")
+					w.send(LeapSocketServerMessage{
+						Type:  "error",
+						Error: "replace error: content was nil",
+					})
+					w.logger.Debugln("Closing websocket due to nil content")
+					closeSignalChan <- struct{}{}
+					return
+				}
+				if _, err := w.binder.SendContent(*msg.Content, bindTOut); err == nil {
+					// The resulting transform is delivered through the outgoing router.
+					w.stats.Incr("http.websocket.replace.success", 1)
+				} else {
+					w.logger.Errorf("Replace request failed %v\n", err)
+					w.send(LeapSocketServerMessage{
+						Type:  "error",
+						Error: fmt.Sprintf("replace error: %v", err),
+						Code:  errorCode(err),
+					})
+					w.stats.Incr("http.websocket.replace.error", 1)
+					if err == lib.ErrRateLimited {
+						continue
+					}
+					w.logger.Debugln("Closing websocket due to failed replace")
+					closeSignalChan <- struct{}{}
+					return
+				}
+			case "resync":
+				if snapshot, err := w.binder.Resync(bindTOut); err == nil {
+					w.logger.Debugf("Sending resync of version %v to client\n", snapshot.Version)
+					w.send(LeapSocketServerMessage{
+						Type:     "resync",
+						Document: &snapshot.Document,
+						Version:  snapshot.Version,
+						Checksum: snapshot.Checksum,
+					})
+					w.stats.Incr("http.websocket.resync.success", 1)
+				} else {
+					w.logger.Errorf("Resync request failed %v\n", err)
+					w.send(LeapSocketServerMessage{
+						Type:  "error",
+						Error: fmt.Sprintf("resync error: %v", err),
+					})
+					w.stats.Incr("http.websocket.resync.error", 1)
+					closeSignalChan <- struct{}{}
+					return
+				}
 			case "ping":
-				// Do nothing
+				if err := w.binder.Ping(bindTOut); err != nil {
+					w.logger.Debugf("Failed to forward ping: %v\n", err)
+				}
 			default:
-				websocket.JSON.Send(w.socket, LeapSocketServerMessage{
+				w.send(LeapSocketServerMessage{
 					Type:  "error",
 					Error: "command not recognised",
 				})
@@ -224,6 +389,19 @@ func (w *WebsocketServer) loopIncoming(closeSignalChan chan<- struct{}, closeCmd
 }
 
 func (w *WebsocketServer) loopOutgoing(closeSignalChan chan<- struct{}, closeCmdChan <-chan struct{}) {
+	batchPeriod := time.Duration(w.config.TransformBatchPeriod) * time.Millisecond
+	pingPeriod := time.Duration(w.config.PingPeriod) * time.Millisecond
+
+	var batch []lib.OTransform
+	var batchTimer <-chan time.Time
+
+	var pingChan <-chan time.Time
+	if pingPeriod > 0 {
+		pingTicker := time.NewTicker(pingPeriod)
+		defer pingTicker.Stop()
+		pingChan = pingTicker.C
+	}
+
 	for {
 		select {
 		case <-closeCmdChan:
@@ -232,23 +410,64 @@ func (w *WebsocketServer) loopOutgoing(closeSignalChan chan<- struct{}, closeCmd
 			return
 		case tform, open := <-w.binder.TransformRcvChan:
 			if !open {
+				w.sendTransforms(batch)
 				w.logger.Debugln("Closing websocket due to closed transform channel")
 				closeSignalChan <- struct{}{}
 				return
 			}
-			w.logger.Traceln("Sending transform to client")
-			websocket.JSON.Send(w.socket, LeapSocketServerMessage{
-				Type:       "transforms",
-				Transforms: []lib.OTransform{tform},
-			})
+			if batchPeriod <= 0 {
+				w.sendTransforms([]lib.OTransform{tform})
+			} else {
+				batch = append(batch, tform)
+				if batchTimer == nil {
+					batchTimer = time.After(batchPeriod)
+				}
+			}
+		case <-batchTimer:
+			w.sendTransforms(batch)
+			batch, batchTimer = nil, nil
+		case <-pingChan:
+			if err := w.sendPing(); err != nil {
+				w.logger.Debugf("Closing websocket due to failed ping: %v\n", err)
+				w.stats.Incr("http.websocket.ping.error", 1)
+				closeSignalChan <- struct{}{}
+				return
+			}
 		case msg, open := <-w.binder.MessageRcvChan:
+			// Updates may refer to the content after transforms already received.
+			w.sendTransforms(batch)
+			batch, batchTimer = nil, nil
 			if !open {
 				w.logger.Debugln("Closing websocket due to closed message channel")
 				closeSignalChan <- struct{}{}
 				return
 			}
-			w.logger.Tracef("Sending update from client: %v, update: %v\n", *msg.Client, msg.Message)
-			websocket.JSON.Send(w.socket, LeapSocketServerMessage{
+			if msg.Message.Kicked {
+				w.logger.Debugf("Sending notice of kick: %v\n", msg.Message.Reason)
+				w.send(LeapSocketServerMessage{
+					Type:   "kicked",
+					Reason: msg.Message.Reason,
+				})
+				continue
+			}
+			if snapshot := msg.Message.Resync; snapshot != nil {
+				w.logger.Debugf("Sending resync of version %v after external change\n", snapshot.Version)
+				w.send(LeapSocketServerMessage{
+					Type:     "resync",
+					Document: &snapshot.Document,
+					Version:  snapshot.Version,
+					Checksum: snapshot.Checksum,
+				})
+			}
+			if msg.Message.ExternalChange {
+				w.logger.Traceln("Sending notice of external change")
+				w.send(LeapSocketServerMessage{
+					Type: "external_change",
+				})
+				continue
+			}
+			w.logger.Tracef("Sending update from client: %v, update: %v\n", msg.Client.UserID, msg.Message)
+			w.send(LeapSocketServerMessage{
 				Type:    "update",
 				Updates: []lib.MessageSubmission{msg},
 			})
@@ -256,5 +475,226 @@ func (w *WebsocketServer) loopOutgoing(closeSignalChan chan<- struct{}, closeCmd
 	}
 }
 
+/*
+socketTimeout - Returns how long reads and writes of the socket may take when pings are enabled, the
+peer must send a frame, which includes a pong, at least this often. Returns zero when pings are
+disabled, in which case the socket has no deadlines.
+*/
+func (w *WebsocketServer) socketTimeout() time.Duration {
+	return 2 * time.Duration(w.config.PingPeriod) * time.Millisecond
+}
+
+/*
+send - Writes a message to the client, this is the only way messages are written to the socket once
+the client is launched.
+*/
+func (w *WebsocketServer) send(msg LeapSocketServerMessage) error {
+	w.writeMut.Lock()
+	defer w.writeMut.Unlock()
+
+	if timeout := w.socketTimeout(); timeout > 0 {
+		w.socket.SetWriteDeadline(time.Now().Add(timeout))
+		defer w.socket.SetWriteDeadline(time.Time{})
+	}
+	return websocket.JSON.Send(w.socket, msg)
+}
+
+/*
+sendPing - Sends a websocket ping frame to the client, which answers with a pong that extends the read
+deadline of the socket. Returns an error if the ping could not be written in time.
+*/
+func (w *WebsocketServer) sendPing() error {
+	w.writeMut.Lock()
+	defer w.writeMut.Unlock()
+
+	w.socket.SetWriteDeadline(time.Now().Add(w.socketTimeout()))
+	defer w.socket.SetWriteDeadline(time.Time{})
+
+	payloadType := w.socket.PayloadType
+	w.socket.PayloadType = websocket.PingFrame
+	_, err := w.socket.Write(nil)
+	w.socket.PayloadType = payloadType
+	return err
+}
+
+/*
+receive - Reads the next JSON message from the client. When pings are enabled every frame read from
+the client, including pongs, extends the read deadline of the socket, so that a peer that stops
+answering is disconnected even when it has nothing to say.
+*/
+func (w *WebsocketServer) receive(v interface{}) error {
+	for {
+		if timeout := w.socketTimeout(); timeout > 0 {
+			w.socket.SetReadDeadline(time.Now().Add(timeout))
+		}
+		frame, err := w.socket.NewFrameReader()
+		if err != nil {
+			return err
+		}
+		// Answers pings and consumes pongs, which leave no frame to read.
+		if frame, err = w.socket.HandleFrame(frame); err != nil {
+			return err
+		}
+		if frame == nil {
+			continue
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(frame, websocket.DefaultMaxPayloadBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > websocket.DefaultMaxPayloadBytes {
+			return websocket.ErrFrameTooLarge
+		}
+		return websocket.JSON.Unmarshal(data, frame.PayloadType(), v)
+	}
+}
+
+/*
+sendTransforms - Sends a batch of transforms to the client as a single message, merging adjacent
+inserts and deletions where possible.
+*/
+func (w *WebsocketServer) sendTransforms(tforms []lib.OTransform) {
+	if len(tforms) == 0 {
+		return
+	}
+	merged := lib.MergeTransforms(tforms, w.binder.PositionUnit)
+	w.stats.Incr("http.websocket.transforms.merged", int64(len(tforms)-len(merged)))
+
+	w.logger.Tracef("Sending %v transforms to client\n", len(merged))
+	w.send(LeapSocketServerMessage{
+		Type:       "transforms",
+		Transforms: merged,
+	})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+processLock - Routes a lock command through to the binder and responds with the resulting locks. A
+failed lock command is reported to the client but does not close the socket.
+*/
+func (w *WebsocketServer) processLock(msg LeapSocketClientMessage, timeout time.Duration) {
+	var (
+		locks []store.Lock
+		lock  store.Lock
+		err   error
+	)
+	switch msg.Command {
+	case "lock":
+		lock, err = w.binder.AddLock(msg.Start, msg.End, msg.AccessLevel, timeout)
+	case "unlock":
+		lock, err = w.binder.RemoveLock(msg.LockID, timeout)
+	case "list_locks":
+		locks, err = w.binder.ListLocks(timeout)
+	}
+	if err != nil {
+		w.logger.Debugf("Client %v request failed %v\n", msg.Command, err)
+		w.stats.Incr("http.websocket.lock.error", 1)
+		w.send(LeapSocketServerMessage{
+			Type:  "locks",
+			Error: fmt.Sprintf("%v error: %v", msg.Command, err),
+		})
+		return
+	}
+	if msg.Command != "list_locks" {
+		locks = []store.Lock{lock}
+	}
+	w.stats.Incr("http.websocket.lock.success", 1)
+	w.send(LeapSocketServerMessage{
+		Type:  "locks",
+		Locks: locks,
+	})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+processComment - Routes a comment command through to the binder and responds with the resulting
+comment threads. A failed comment command is reported to the client but does not close the socket.
+*/
+func (w *WebsocketServer) processComment(msg LeapSocketClientMessage, timeout time.Duration) {
+	var (
+		threads []store.CommentThread
+		thread  store.CommentThread
+		err     error
+	)
+	switch msg.Command {
+	case "comment":
+		thread, err = w.binder.AddComment(msg.Start, msg.End, msg.Message, timeout)
+	case "reply_comment":
+		thread, err = w.binder.ReplyComment(msg.ThreadID, msg.Message, timeout)
+	case "resolve_comment":
+		thread, err = w.binder.ResolveComment(msg.ThreadID, timeout)
+	case "list_comments":
+		threads, err = w.binder.ListComments(timeout)
+	}
+	if err != nil {
+		w.logger.Debugf("Client %v request failed %v\n", msg.Command, err)
+		w.stats.Incr("http.websocket.comment.error", 1)
+		w.send(LeapSocketServerMessage{
+			Type:  "comments",
+			Error: fmt.Sprintf("%v error: %v", msg.Command, err),
+		})
+		return
+	}
+	if msg.Command != "list_comments" {
+		threads = []store.CommentThread{thread}
+	}
+	w.stats.Incr("http.websocket.comment.success", 1)
+	w.send(LeapSocketServerMessage{
+		Type:     "comments",
+		Comments: threads,
+	})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+processSuggestion - Routes a suggestion command through to the binder and responds with the
+resulting suggestions. A failed suggestion command is reported to the client but does not close the
+socket.
+*/
+func (w *WebsocketServer) processSuggestion(msg LeapSocketClientMessage, timeout time.Duration) {
+	var (
+		suggestions []store.Suggestion
+		suggestion  store.Suggestion
+		err         error
+	)
+	switch msg.Command {
+	case "suggest":
+		if msg.Transform == nil {
+			err = errors.New("transform was nil")
+		} else {
+			suggestion, err = w.binder.Suggest(*msg.Transform, timeout)
+		}
+	case "accept_suggestion":
+		suggestion, err = w.binder.AcceptSuggestion(msg.SuggestionID, timeout)
+	case "reject_suggestion":
+		suggestion, err = w.binder.RejectSuggestion(msg.SuggestionID, timeout)
+	case "list_suggestions":
+		suggestions, err = w.binder.ListSuggestions(timeout)
+	}
+	if err != nil {
+		w.logger.Debugf("Client %v request failed %v\n", msg.Command, err)
+		w.stats.Incr("http.websocket.suggestion.error", 1)
+		w.send(LeapSocketServerMessage{
+			Type:  "suggestions",
+			Error: fmt.Sprintf("%v error: %v", msg.Command, err),
+		})
+		return
+	}
+	if msg.Command != "list_suggestions" {
+		suggestions = []store.Suggestion{suggestion}
+	}
+	w.stats.Incr("http.websocket.suggestion.success", 1)
+	w.send(LeapSocketServerMessage{
+		Type:        "suggestions",
+		Suggestions: suggestions,
+	})
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
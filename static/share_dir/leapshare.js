@@ -418,14 +418,26 @@ var join_new_document = function(document_id) {
 		system_message("Opened document " + document_id, "blue");
 	});
 
+	leaps_client.on("external_change", function() {
+		system_message(document_id + " was modified outside of leaps, changes were merged", "blue");
+	});
+
+	leaps_client.on("kicked", function(reason) {
+		system_message("You were kicked from " + document_id + (reason ? ": " + reason : ""), "red");
+	});
+
 	leaps_client.on("user", function(user_update) {
+		var name = user_update.client.user_id;
+		if ( user_update.profile && 'string' === typeof user_update.profile.display_name ) {
+			name = user_update.profile.display_name;
+		}
 
 		if ( 'string' === typeof user_update.message.content ) {
-			chat_message(user_update.client.session_id, user_update.client.user_id, user_update.message.content);
+			chat_message(user_update.client.session_id, name, user_update.message.content);
 		}
 
-		var refresh_user_list = !users.hasOwnProperty(user_update.client.session_id);
-		users[user_update.client.session_id] = user_update.client.user_id;
+		var refresh_user_list = users[user_update.client.session_id] !== name;
+		users[user_update.client.session_id] = name;
 
 		if ( typeof user_update.message.active === 'boolean' && !user_update.message.active ) {
 			refresh_user_list = true;
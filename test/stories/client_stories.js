@@ -165,5 +165,36 @@
 			"result" : "heyo testing world you poor fool"
 		}
 	]
+},
+{
+	"name" : "coalescedtest",
+	"content" : "hello world",
+	"result" : "heyo testing world you fool",
+	"epochs" : [
+		{
+			"send" : [
+				{ "position" : 6, "num_delete" : 0, "insert" : "testing " }
+			],
+			"receive" : [
+				{
+					"response_type" : "transforms",
+					"transforms" : [
+						{ "position" : 11, "num_delete" : 0, "insert" : " you fool", "version" : 3, "span" : 2 }
+					]
+				},
+				{
+					"response_type" : "correction",
+					"version" : 4
+				},
+				{
+					"response_type" : "transforms",
+					"transforms" : [
+						{ "position" : 2, "num_delete" : 3, "insert" : "yo", "version" : 6, "span" : 2 }
+					]
+				}
+			],
+			"result" : "heyo testing world you fool"
+		}
+	]
 }
 ] }